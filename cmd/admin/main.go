@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/sater-151/tt-auth/internal/config"
+	"github.com/sater-151/tt-auth/internal/database"
+	logg "github.com/sater-151/tt-auth/internal/logger"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/notifier"
+	"github.com/sater-151/tt-auth/internal/service"
+	logger "github.com/sirupsen/logrus"
+)
+
+const usage = `usage: admin <command>
+
+commands:
+  rotate-keys   generate a new signing key and retire the current one
+  prune-keys    delete signing keys which do not verify tokens any more
+  failed-notifications [limit]
+                list notifications which could not be delivered
+  retry-notification <id>
+                queue a failed notification again
+  create-user <email> [display name]
+                add an active user
+  set-user-status <guid> <active|disabled|locked>
+                enable, disable or lock a user
+  set-password <guid>
+                set the password of a user, read from stdin
+  unlock-user <guid>
+                lift the lock after failed attempts or set by an administrator
+  unlock-ip <ip>
+                forget failed attempts from an address
+  create-client register a client from JSON metadata read from stdin
+  list-clients  list registered clients
+  show-client <id>
+                print the metadata of a client
+  update-client <id>
+                replace the metadata of a client with JSON read from stdin
+  rotate-client-secret <id>
+                issue a new secret, the old one stops working
+  delete-client <id>
+                delete a client and revoke its sessions
+  import-clients <file>
+                register the clients of a former OAUTH_CLIENTS_FILE, their secrets are kept`
+
+var ErrUnknownCommand = errors.New("unknown command")
+var ErrArgumentRequired = errors.New("argument required")
+
+func main() {
+	logg.Init()
+
+	err := godotenv.Load()
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+	if len(os.Args) < 2 {
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+	err = config.CheckSecrets()
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+
+	db, close, err := database.Open(config.GetDBConfig())
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+	defer close()
+
+	sender, err := notifier.New(config.GetMailConfig())
+	if err != nil {
+		logger.Error(err)
+		close()
+		os.Exit(1)
+	}
+
+	err = run(service.New(db, sender), os.Args[1], os.Args[2:])
+	if err != nil {
+		logger.Error(err)
+		if errors.Is(err, ErrUnknownCommand) || errors.Is(err, ErrArgumentRequired) {
+			fmt.Println(usage)
+		}
+		close()
+		os.Exit(1)
+	}
+}
+
+func run(s *service.ServiceStruct, command string, args []string) error {
+	switch command {
+	case "rotate-keys":
+		keyConfig, err := config.GetKeyConfig()
+		if err != nil {
+			return err
+		}
+		key, err := s.RotateSigningKey(keyConfig)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("key %s is published and starts signing at %s\n", key.KID, key.ActivatesAt)
+	case "prune-keys":
+		pruned, err := s.PruneSigningKeys()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d expired keys deleted\n", pruned)
+	case "failed-notifications":
+		limit := 50
+		if len(args) > 0 {
+			var err error
+			limit, err = strconv.Atoi(args[0])
+			if err != nil {
+				return err
+			}
+		}
+		notifications, err := s.ListFailedNotifications(limit)
+		if err != nil {
+			return err
+		}
+		for _, n := range notifications {
+			fmt.Printf("%s\t%s\t%s\t%s\tattempts=%d\t%s\n", n.ID, n.CreatedAt.Format(time.RFC3339), n.Template,
+				n.Message.To, n.Attempts, n.LastError)
+		}
+	case "retry-notification":
+		if len(args) == 0 {
+			return ErrArgumentRequired
+		}
+		err := s.RetryNotification(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("notification %s is queued again\n", args[0])
+	case "create-user":
+		if len(args) == 0 {
+			return ErrArgumentRequired
+		}
+		user, err := s.CreateUser(args[0], strings.Join(args[1:], " "))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("user %s created\n", user.ID)
+	case "set-user-status":
+		if len(args) < 2 {
+			return ErrArgumentRequired
+		}
+		err := s.SetUserStatus(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("user %s is %s\n", args[0], args[1])
+	case "set-password":
+		if len(args) == 0 {
+			return ErrArgumentRequired
+		}
+		// the password is not taken from arguments to keep it out of shell history
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		err = s.SetPassword(args[0], strings.TrimRight(password, "\r\n"))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("password of user %s has been changed\n", args[0])
+	case "unlock-user":
+		if len(args) == 0 {
+			return ErrArgumentRequired
+		}
+		err := s.UnlockUser(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("user %s is unlocked\n", args[0])
+	case "unlock-ip":
+		if len(args) == 0 {
+			return ErrArgumentRequired
+		}
+		err := s.UnlockIP(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("address %s is unlocked\n", args[0])
+	case "create-client":
+		client, err := readClient(os.Stdin)
+		if err != nil {
+			return err
+		}
+		client, err = s.CreateClient(client)
+		if err != nil {
+			return err
+		}
+		return printJSON(client)
+	case "list-clients":
+		clients, err := s.ListClients()
+		if err != nil {
+			return err
+		}
+		for _, c := range clients {
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\n", c.ID, c.Type, c.AuthMethod, strings.Join(c.GrantTypes, ","), c.Name)
+		}
+	case "show-client":
+		if len(args) == 0 {
+			return ErrArgumentRequired
+		}
+		client, err := s.GetClient(args[0])
+		if err != nil {
+			return err
+		}
+		return printJSON(client)
+	case "update-client":
+		if len(args) == 0 {
+			return ErrArgumentRequired
+		}
+		client, err := readClient(os.Stdin)
+		if err != nil {
+			return err
+		}
+		client.ID = args[0]
+		client, err = s.UpdateClient(client)
+		if err != nil {
+			return err
+		}
+		return printJSON(client)
+	case "rotate-client-secret":
+		if len(args) == 0 {
+			return ErrArgumentRequired
+		}
+		secret, err := s.RotateClientSecret(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Println(secret)
+	case "delete-client":
+		if len(args) == 0 {
+			return ErrArgumentRequired
+		}
+		err := s.DeleteClient(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("client %s deleted\n", args[0])
+	case "import-clients":
+		if len(args) == 0 {
+			return ErrArgumentRequired
+		}
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		var clients []models.Client
+		err = json.Unmarshal(data, &clients)
+		if err != nil {
+			return err
+		}
+		for _, client := range clients {
+			client, err = s.CreateClient(client)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("client %s imported\n", client.ID)
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownCommand, command)
+	}
+	return nil
+}
+
+func readClient(r io.Reader) (models.Client, error) {
+	var client models.Client
+	err := json.NewDecoder(r).Decode(&client)
+	return client, err
+}
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
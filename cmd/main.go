@@ -1,58 +1,143 @@
-package main
-
-import (
-	"fmt"
-	"net/http"
-
-	"github.com/go-chi/chi/v5"
-	"github.com/joho/godotenv"
-	"github.com/sater-151/tt-auth/internal/config"
-	"github.com/sater-151/tt-auth/internal/database"
-	"github.com/sater-151/tt-auth/internal/handlers"
-	logg "github.com/sater-151/tt-auth/internal/logger"
-	"github.com/sater-151/tt-auth/internal/service"
-	logger "github.com/sirupsen/logrus"
-)
-
-func main() {
-	logg.Init()
-
-	err := godotenv.Load()
-	if err != nil {
-		logger.Error(err)
-		return
-	}
-	logger.Info("getting configuration")
-	serverConfig := config.GetServerConfig()
-	dbConfig := config.GetDBConfig()
-
-	logger.Info("database connecting")
-	db, close, err := database.Open(dbConfig)
-	if err != nil {
-		logger.Error(err)
-		return
-	}
-	defer close()
-	logger.Info("database has been connected")
-
-	logger.Info("starting migration")
-	err = db.Migration()
-	if err != nil {
-		logger.Error(err)
-		return
-	}
-	logger.Info("migration done")
-
-	service := service.New(db)
-
-	r := chi.NewRouter()
-
-	r.Get("/auth", handlers.GetTokens(service))
-	r.Get("/refresh", handlers.RefreshTokens(service))
-
-	logger.Info(fmt.Sprintf("server start at port: %s\n", serverConfig.Port))
-	if err := http.ListenAndServe(":"+serverConfig.Port, r); err != nil {
-		logger.Error(fmt.Sprintf("Server error: %s\n", err.Error()))
-		return
-	}
-}
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/joho/godotenv"
+	"github.com/sater-151/tt-auth/internal/config"
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/handlers"
+	logg "github.com/sater-151/tt-auth/internal/logger"
+	"github.com/sater-151/tt-auth/internal/notifier"
+	"github.com/sater-151/tt-auth/internal/ratelimit"
+	"github.com/sater-151/tt-auth/internal/service"
+	"github.com/sater-151/tt-auth/internal/utils"
+	logger "github.com/sirupsen/logrus"
+)
+
+func main() {
+	logg.Init()
+
+	err := godotenv.Load()
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	logger.Info("getting configuration")
+	err = config.CheckSecrets()
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	serverConfig := config.GetServerConfig()
+	dbConfig := config.GetDBConfig()
+
+	logger.Info("database connecting")
+	db, close, err := database.Open(dbConfig)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	defer close()
+	logger.Info("database has been connected")
+
+	logger.Info("starting migration")
+	err = db.Migration()
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	logger.Info("migration done")
+
+	trustedProxies, err := utils.ParseTrustedProxies(serverConfig.TrustedProxies)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	utils.SetTrustedProxies(trustedProxies)
+
+	sender, err := notifier.New(config.GetMailConfig())
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+
+	webAuthn, err := service.NewWebAuthn(config.GetWebAuthnConfig())
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+
+	service := service.New(db, sender)
+	service.Accounts = config.GetAccountConfig()
+	service.WebAuthn = webAuthn
+	service.Lockout = config.GetLockoutConfig()
+	service.OAuth = config.GetOAuthConfig()
+	go notifier.NewWorker(db, sender, config.GetOutboxConfig()).Run()
+
+	logger.Info("loading signing keys")
+	keyConfig, err := config.GetKeyConfig()
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	keyRing, err := service.InitKeyRing(keyConfig)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	utils.SetKeyRing(keyRing)
+	go service.RunKeyRotation(keyRing, keyConfig)
+	logger.Info(fmt.Sprintf("access tokens are signed with %s", keyConfig.Alg))
+
+	rateLimitConfig, err := config.GetRateLimitConfig()
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	limiter, err := ratelimit.New(rateLimitConfig, db)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+
+	r := chi.NewRouter()
+	r.Use(ratelimit.Middleware(limiter, rateLimitConfig.Routes))
+
+	r.Post("/login", handlers.Login(service))
+	r.Post("/login/magic", handlers.MagicLink(service))
+	r.Get("/login/magic/callback", handlers.MagicLinkCallback(service))
+	r.Post("/register", handlers.Register(service))
+	r.Get("/verify-email", handlers.VerifyEmail(service))
+	r.Post("/password/forgot", handlers.ForgotPassword(service))
+	r.Get("/password/reset", handlers.ResetPasswordForm())
+	r.Post("/password/reset", handlers.ResetPassword(service))
+	r.Post("/mfa/totp/enroll", handlers.EnrollTOTP(service))
+	r.Post("/mfa/totp/confirm", handlers.ConfirmTOTP(service))
+	r.Post("/mfa/verify", handlers.VerifyMFA(service))
+	r.Post("/webauthn/register/begin", handlers.WebAuthnRegisterBegin(service))
+	r.Post("/webauthn/register/finish", handlers.WebAuthnRegisterFinish(service))
+	r.Post("/webauthn/login/begin", handlers.WebAuthnLoginBegin(service))
+	r.Post("/webauthn/login/finish", handlers.WebAuthnLoginFinish(service))
+	r.Get("/refresh", handlers.RefreshTokens(service))
+	r.Post("/logout", handlers.Logout(service))
+	r.Post("/logout/all", handlers.LogoutAll(service))
+	r.Post("/revoke", handlers.Revoke(service))
+	r.Get("/.well-known/jwks.json", handlers.JWKS())
+	r.Post("/introspect", handlers.Introspect(service))
+	r.Get("/oauth/authorize", handlers.Authorize(service))
+	r.Post("/oauth/consent", handlers.Consent(service))
+	r.Post("/oauth/token", handlers.Token(service))
+	r.Post("/register/client", handlers.RegisterClient(service))
+	r.Get("/register/client/{clientID}", handlers.GetClientRegistration(service))
+	r.Put("/register/client/{clientID}", handlers.UpdateClientRegistration(service))
+	r.Delete("/register/client/{clientID}", handlers.DeleteClientRegistration(service))
+
+	logger.Info(fmt.Sprintf("server start at port: %s\n", serverConfig.Port))
+	if err := http.ListenAndServe(":"+serverConfig.Port, r); err != nil {
+		logger.Error(fmt.Sprintf("Server error: %s\n", err.Error()))
+		return
+	}
+}
@@ -1,43 +1,285 @@
-package config
-
-import (
-	"os"
-
-	"github.com/sater-151/tt-auth/internal/models"
-	logger "github.com/sirupsen/logrus"
-)
-
-func GetServerConfig() models.ServerConfig {
-	var config models.ServerConfig
-	config.Port = os.Getenv("SERVER_PORT")
-	return config
-}
-
-func GetDBConfig() models.DBConfig {
-	var dbConfig models.DBConfig
-	dbConfig.User = os.Getenv("POSTGRES_USER")
-	if dbConfig.User == "" {
-		logger.Warn("postgres user is empty")
-	}
-	dbConfig.Pass = os.Getenv("POSTGRES_PASSWORD")
-	if dbConfig.Pass == "" {
-		logger.Warn("postgres password if empty")
-	}
-	dbConfig.Dbname = os.Getenv("POSTGRES_DB")
-	if dbConfig.Dbname == "" {
-		logger.Warn("postgres database name if empty")
-	}
-	dbConfig.Sslmode = os.Getenv("SSLMODE")
-	if dbConfig.Sslmode == "" {
-		logger.Warn("postgres sslmode is empty")
-	}
-	dbConfig.Port = os.Getenv("DB_PORT")
-	if dbConfig.Port == "" {
-		logger.Warn("postgres port is empty")
-	}
-	dbConfig.Host = os.Getenv("DB_HOST")
-	if dbConfig.Host == "" {
-		logger.Warn("postgres host is empty")
-	}
-	return dbConfig
-}
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sater-151/tt-auth/internal/models"
+	logger "github.com/sirupsen/logrus"
+)
+
+func GetServerConfig() models.ServerConfig {
+	var config models.ServerConfig
+	config.Port = os.Getenv("SERVER_PORT")
+	config.TrustedProxies = os.Getenv("TRUSTED_PROXIES")
+	return config
+}
+
+func GetDBConfig() models.DBConfig {
+	var dbConfig models.DBConfig
+	dbConfig.User = os.Getenv("POSTGRES_USER")
+	if dbConfig.User == "" {
+		logger.Warn("postgres user is empty")
+	}
+	dbConfig.Pass = os.Getenv("POSTGRES_PASSWORD")
+	if dbConfig.Pass == "" {
+		logger.Warn("postgres password if empty")
+	}
+	dbConfig.Dbname = os.Getenv("POSTGRES_DB")
+	if dbConfig.Dbname == "" {
+		logger.Warn("postgres database name if empty")
+	}
+	dbConfig.Sslmode = os.Getenv("SSLMODE")
+	if dbConfig.Sslmode == "" {
+		logger.Warn("postgres sslmode is empty")
+	}
+	dbConfig.Port = os.Getenv("DB_PORT")
+	if dbConfig.Port == "" {
+		logger.Warn("postgres port is empty")
+	}
+	dbConfig.Host = os.Getenv("DB_HOST")
+	if dbConfig.Host == "" {
+		logger.Warn("postgres host is empty")
+	}
+	return dbConfig
+}
+
+func GetMailConfig() models.MailConfig {
+	var mailConfig models.MailConfig
+	mailConfig.Notifier = os.Getenv("NOTIFIER")
+	if mailConfig.Notifier == "" {
+		mailConfig.Notifier = "outbox"
+	}
+	mailConfig.OutboxFile = os.Getenv("NOTIFIER_OUTBOX_FILE")
+	mailConfig.Host = os.Getenv("SMTP_HOST")
+	mailConfig.Port = os.Getenv("SMTP_PORT")
+	mailConfig.Username = os.Getenv("SMTP_USERNAME")
+	mailConfig.Password = os.Getenv("SMTP_PASSWORD")
+	mailConfig.From = os.Getenv("SMTP_FROM")
+	mailConfig.TLS = os.Getenv("SMTP_TLS")
+	if mailConfig.TLS == "" {
+		mailConfig.TLS = "starttls"
+	}
+	mailConfig.Timeout = getSeconds("SMTP_TIMEOUT", 10*time.Second)
+	if mailConfig.Notifier == "smtp" {
+		if mailConfig.Host == "" {
+			logger.Warn("smtp host is empty")
+		}
+		if mailConfig.From == "" {
+			logger.Warn("smtp sender is empty")
+		}
+	}
+	return mailConfig
+}
+
+func GetOutboxConfig() models.OutboxConfig {
+	var outboxConfig models.OutboxConfig
+	outboxConfig.Workers = getInt("NOTIFIER_WORKERS", 4)
+	outboxConfig.MaxAttempts = getInt("NOTIFIER_MAX_ATTEMPTS", 8)
+	outboxConfig.RetryBase = getSeconds("NOTIFIER_RETRY_BASE", 30*time.Second)
+	outboxConfig.RetryMax = getSeconds("NOTIFIER_RETRY_MAX", time.Hour)
+	outboxConfig.PollInterval = getSeconds("NOTIFIER_POLL_INTERVAL", 5*time.Second)
+	outboxConfig.Lease = time.Minute + getSeconds("SMTP_TIMEOUT", 10*time.Second)
+	return outboxConfig
+}
+
+const MinPepperLength = 32
+
+var ErrWeakJWTSecret = errors.New("JWT_SECRET is shorter than the output of the JWT_ALG hash")
+var ErrWeakRTPepper = errors.New("RT_PEPPER is too short")
+
+// CheckSecrets refuses to start with an empty or short RT_PEPPER, which keys the hashes of stored tokens,
+// and with a JWT_SECRET shorter than the hash output, RFC 7518 3.2, when tokens are signed by HMAC
+func CheckSecrets() error {
+	if len(os.Getenv("RT_PEPPER")) < MinPepperLength {
+		return fmt.Errorf("%w: at least %d bytes are required", ErrWeakRTPepper, MinPepperLength)
+	}
+	alg := os.Getenv("JWT_ALG")
+	if alg == "" {
+		alg = "HS512"
+	}
+	method, ok := jwt.GetSigningMethod(alg).(*jwt.SigningMethodHMAC)
+	if ok && len(os.Getenv("JWT_SECRET")) < method.Hash.Size() {
+		return fmt.Errorf("%w: at least %d bytes are required for %s", ErrWeakJWTSecret, method.Hash.Size(), alg)
+	}
+	return nil
+}
+
+var ErrSigningEncryptionKey = errors.New("SIGNING_KEY_ENCRYPTION_KEY must be 32 bytes encoded in base64")
+
+// GetKeyConfig fails without the key encrypting the stored signing keys,
+// so a missing key is found at startup and not at the first rotation
+func GetKeyConfig() (models.KeyConfig, error) {
+	var keyConfig models.KeyConfig
+	key, err := base64.StdEncoding.DecodeString(os.Getenv("SIGNING_KEY_ENCRYPTION_KEY"))
+	if err != nil || len(key) != 32 {
+		return keyConfig, ErrSigningEncryptionKey
+	}
+	keyConfig.Alg = os.Getenv("JWT_ALG")
+	if keyConfig.Alg == "" {
+		keyConfig.Alg = "HS512"
+	}
+	keyConfig.PrivateKeyFile = os.Getenv("JWT_PRIVATE_KEY_FILE")
+	keyConfig.RotationPeriod = getSeconds("JWT_KEY_ROTATION_PERIOD", 0)
+	keyConfig.PublishAhead = getSeconds("JWT_KEY_PUBLISH_AHEAD", 10*time.Minute)
+	keyConfig.ReloadInterval = getSeconds("JWT_KEY_RELOAD_INTERVAL", time.Minute)
+	// a retired key has to verify every access token it has signed
+	keyConfig.VerifyFor = getSeconds("ATEXPIRES", 0) + getSeconds("JWT_LEEWAY", 0)
+	return keyConfig, nil
+}
+
+func GetAccountConfig() models.AccountConfig {
+	var config models.AccountConfig
+	config.PublicURL = strings.TrimRight(os.Getenv("PUBLIC_URL"), "/")
+	if config.PublicURL == "" {
+		config.PublicURL = "http://localhost:" + os.Getenv("SERVER_PORT")
+		logger.Warn("public url is empty, using " + config.PublicURL)
+	}
+	if value := os.Getenv("REQUIRE_EMAIL_VERIFIED"); value != "" {
+		required, err := strconv.ParseBool(value)
+		if err != nil {
+			logger.Warn("REQUIRE_EMAIL_VERIFIED is not a boolean")
+		}
+		config.RequireEmailVerified = required
+	}
+	config.VerifyEmailTTL = getSeconds("VERIFY_EMAIL_TTL", 24*time.Hour)
+	config.ResetPasswordTTL = getSeconds("RESET_PASSWORD_TTL", time.Hour)
+	config.ResetPasswordLimit = getInt("RESET_PASSWORD_LIMIT", 3)
+	config.ResetPasswordWindow = getSeconds("RESET_PASSWORD_WINDOW", 15*time.Minute)
+	config.MFAIssuer = os.Getenv("MFA_ISSUER")
+	if config.MFAIssuer == "" {
+		config.MFAIssuer = "tt-auth"
+	}
+	config.MFAChallengeTTL = getSeconds("MFA_CHALLENGE_TTL", 5*time.Minute)
+	config.MFAMaxAttempts = getInt("MFA_MAX_ATTEMPTS", 5)
+	config.MagicLinkTTL = getSeconds("MAGIC_LINK_TTL", 10*time.Minute)
+	config.MagicLinkLimit = getInt("MAGIC_LINK_LIMIT", 3)
+	config.MagicLinkWindow = getSeconds("MAGIC_LINK_WINDOW", 15*time.Minute)
+	return config
+}
+
+func GetOAuthConfig() models.OAuthConfig {
+	var config models.OAuthConfig
+	config.CodeTTL = getSeconds("OAUTH_CODE_TTL", time.Minute)
+	config.RegistrationToken = os.Getenv("OAUTH_REGISTRATION_TOKEN")
+	config.RegistrationScope = os.Getenv("OAUTH_REGISTRATION_SCOPE")
+	return config
+}
+
+func GetLockoutConfig() models.LockoutConfig {
+	var config models.LockoutConfig
+	config.Threshold = getInt("LOCKOUT_THRESHOLD", 10)
+	config.IPThreshold = getInt("LOCKOUT_IP_THRESHOLD", 50)
+	config.FreeAttempts = getInt("LOCKOUT_FREE_ATTEMPTS", 3)
+	config.DelayBase = getSeconds("LOCKOUT_DELAY_BASE", time.Second)
+	config.DelayMax = getSeconds("LOCKOUT_DELAY_MAX", time.Minute)
+	config.Cooldown = getSeconds("LOCKOUT_COOLDOWN", 15*time.Minute)
+	config.Window = getSeconds("LOCKOUT_WINDOW", time.Hour)
+	return config
+}
+
+// GetWebAuthnConfig takes the relying party from PUBLIC_URL unless WEBAUTHN_RP_ID and WEBAUTHN_ORIGINS are set
+func GetWebAuthnConfig() models.WebAuthnConfig {
+	var config models.WebAuthnConfig
+	publicURL, err := url.Parse(GetAccountConfig().PublicURL)
+	if err != nil {
+		logger.Warn("public url is invalid")
+		publicURL = &url.URL{}
+	}
+	config.RPID = os.Getenv("WEBAUTHN_RP_ID")
+	if config.RPID == "" {
+		config.RPID = publicURL.Hostname()
+	}
+	config.RPDisplayName = os.Getenv("WEBAUTHN_RP_NAME")
+	if config.RPDisplayName == "" {
+		config.RPDisplayName = "tt-auth"
+	}
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			config.Origins = append(config.Origins, origin)
+		}
+	}
+	if len(config.Origins) == 0 {
+		config.Origins = []string{publicURL.Scheme + "://" + publicURL.Host}
+	}
+	return config
+}
+
+var ErrInvalidRateLimit = errors.New("invalid rate limit")
+
+const defaultRateLimits = "/login=10/1m,/mfa/verify=10/1m,/login/magic=5/1m,/password/forgot=5/1m," +
+	"/webauthn/login/begin=10/1m,/webauthn/login/finish=10/1m,/refresh=30/1m,/oauth/token=30/1m,/register/client=10/1m"
+
+// GetRateLimitConfig reads limits as a comma separated list of path=requests/period,
+// for example /login=10/1m. RATE_LIMITS=none turns limiting off.
+func GetRateLimitConfig() (models.RateLimitConfig, error) {
+	var config models.RateLimitConfig
+	config.Backend = os.Getenv("RATE_LIMIT_BACKEND")
+	if config.Backend == "" {
+		config.Backend = "memory"
+	}
+	config.Routes = make(map[string]models.RateLimit)
+	list := os.Getenv("RATE_LIMITS")
+	if list == "" {
+		list = defaultRateLimits
+	}
+	if list == "none" {
+		return config, nil
+	}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		route, value, ok := strings.Cut(item, "=")
+		requests, period, ok2 := strings.Cut(value, "/")
+		if !ok || !ok2 {
+			return config, fmt.Errorf("%w: %s", ErrInvalidRateLimit, item)
+		}
+		var limit models.RateLimit
+		var err error
+		limit.Requests, err = strconv.Atoi(requests)
+		if err != nil || limit.Requests <= 0 {
+			return config, fmt.Errorf("%w: %s", ErrInvalidRateLimit, item)
+		}
+		limit.Period, err = time.ParseDuration(period)
+		if err != nil || limit.Period <= 0 {
+			return config, fmt.Errorf("%w: %s", ErrInvalidRateLimit, item)
+		}
+		config.Routes[strings.TrimSpace(route)] = limit
+	}
+	return config, nil
+}
+
+func getSeconds(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil {
+		logger.Warn(name + " is not a number of seconds")
+		return def
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func getInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		logger.Warn(name + " is not a number")
+		return def
+	}
+	return number
+}
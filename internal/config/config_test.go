@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckSecrets(t *testing.T) {
+	tests := []struct {
+		id      int
+		alg     string
+		secret  string
+		pepper  string
+		wantErr error
+	}{
+		{
+			id:     1,
+			secret: strings.Repeat("s", 64),
+			pepper: strings.Repeat("p", MinPepperLength),
+		},
+		{
+			id:      2,
+			secret:  strings.Repeat("s", 64),
+			pepper:  "",
+			wantErr: ErrWeakRTPepper,
+		},
+		{
+			id:      3,
+			secret:  strings.Repeat("s", 64),
+			pepper:  "pepper",
+			wantErr: ErrWeakRTPepper,
+		},
+		{
+			id:      4,
+			secret:  "",
+			pepper:  strings.Repeat("p", MinPepperLength),
+			wantErr: ErrWeakJWTSecret,
+		},
+		{
+			id:      5,
+			alg:     "HS512",
+			secret:  strings.Repeat("s", 32),
+			pepper:  strings.Repeat("p", MinPepperLength),
+			wantErr: ErrWeakJWTSecret,
+		},
+		{
+			id:     6,
+			alg:    "HS256",
+			secret: strings.Repeat("s", 32),
+			pepper: strings.Repeat("p", MinPepperLength),
+		},
+		{
+			id:     7,
+			alg:    "ES256",
+			secret: "",
+			pepper: strings.Repeat("p", MinPepperLength),
+		},
+	}
+	for _, test := range tests {
+		fmt.Printf("Тест id: %v\n", test.id)
+		t.Setenv("JWT_ALG", test.alg)
+		t.Setenv("JWT_SECRET", test.secret)
+		t.Setenv("RT_PEPPER", test.pepper)
+
+		err := CheckSecrets()
+		if test.wantErr == nil {
+			assert.NoError(t, err, "секреты должны быть приняты")
+			continue
+		}
+		assert.ErrorIs(t, err, test.wantErr, "ошибка не соответствует ожидаемой")
+	}
+}
@@ -0,0 +1,215 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/models"
+	logger "github.com/sirupsen/logrus"
+)
+
+var ErrClientNotFound = errors.New("client not found")
+var ErrClientExists = errors.New("client already exists")
+
+type ClientRepository interface {
+	CreateClient(client models.Client) error
+	GetClient(id string) (models.Client, error)
+	ListClients() ([]models.Client, error)
+	UpdateClient(client models.Client) error
+	SetClientSecret(id, secretHash string) error
+	DeleteClient(id string) error
+	UseClientAssertion(clientID, jti string, expiresAt time.Time) (bool, error)
+}
+
+const clientColumns = `id, secret_hash, name, client_type, auth_method, redirect_uris, grant_types, scope, jwks,
+	access_token_ttl, refresh_token_ttl, created_at, registration_token_hash, first_party`
+
+func (db *DBStruct) CreateClient(client models.Client) error {
+	logger.Debug("creating client " + client.ID)
+	redirectURIs, grantTypes, jwks, err := clientJSON(client)
+	if err != nil {
+		return err
+	}
+	_, err = db.db.Exec(`INSERT INTO clients (id, secret_hash, name, client_type, auth_method, redirect_uris, grant_types,
+		scope, jwks, access_token_ttl, refresh_token_ttl, registration_token_hash, first_party)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7, $8, $9, $10, $11, NULLIF($12, ''), $13)`,
+		client.ID, client.SecretHash, client.Name, client.Type, client.AuthMethod, redirectURIs, grantTypes, client.Scope,
+		jwks, client.AccessTokenTTL, client.RefreshTokenTTL, client.RegistrationTokenHash, client.FirstParty)
+	if isUniqueViolation(err) {
+		return ErrClientExists
+	}
+	return err
+}
+
+func (db *DBStruct) GetClient(id string) (models.Client, error) {
+	client, err := scanClient(db.db.QueryRow("SELECT "+clientColumns+" FROM clients WHERE id=$1", id))
+	if err == sql.ErrNoRows {
+		return client, ErrClientNotFound
+	}
+	return client, err
+}
+
+func (db *DBStruct) ListClients() ([]models.Client, error) {
+	rows, err := db.db.Query("SELECT " + clientColumns + " FROM clients ORDER BY created_at, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []models.Client
+	for rows.Next() {
+		client, err := scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, rows.Err()
+}
+
+// UpdateClient replaces the metadata of the client, the secret is changed only by SetClientSecret
+func (db *DBStruct) UpdateClient(client models.Client) error {
+	logger.Debug("updating client " + client.ID)
+	redirectURIs, grantTypes, jwks, err := clientJSON(client)
+	if err != nil {
+		return err
+	}
+	result, err := db.db.Exec(`UPDATE clients SET name=$2, client_type=$3, auth_method=$4, redirect_uris=$5, grant_types=$6,
+		scope=$7, jwks=$8, access_token_ttl=$9, refresh_token_ttl=$10, first_party=$11, updated_at=now() WHERE id=$1`,
+		client.ID, client.Name, client.Type, client.AuthMethod, redirectURIs, grantTypes, client.Scope, jwks,
+		client.AccessTokenTTL, client.RefreshTokenTTL, client.FirstParty)
+	if err != nil {
+		return err
+	}
+	return clientAffected(result)
+}
+
+func (db *DBStruct) SetClientSecret(id, secretHash string) error {
+	logger.Debug("setting secret of client " + id)
+	result, err := db.db.Exec("UPDATE clients SET secret_hash=NULLIF($2, ''), updated_at=now() WHERE id=$1",
+		id, secretHash)
+	if err != nil {
+		return err
+	}
+	return clientAffected(result)
+}
+
+// DeleteClient removes the client with its unused authorization codes and revokes the sessions issued to it
+func (db *DBStruct) DeleteClient(id string) error {
+	logger.Debug("deleting client " + id)
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE sessions SET revoked_at=now() WHERE client_id=$1 AND revoked_at IS NULL", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM authorization_codes WHERE client_id=$1", id)
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec("DELETE FROM clients WHERE id=$1", id)
+	if err != nil {
+		return err
+	}
+	err = clientAffected(result)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseClientAssertion records the jti of a client assertion until it expires,
+// false means the assertion has been used already
+func (db *DBStruct) UseClientAssertion(clientID, jti string, expiresAt time.Time) (bool, error) {
+	_, err := db.db.Exec("DELETE FROM client_assertions WHERE expires_at < now()")
+	if err != nil {
+		return false, err
+	}
+	result, err := db.db.Exec(`INSERT INTO client_assertions (client_id, jti, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`, clientID, jti, expiresAt)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func clientJSON(client models.Client) ([]byte, []byte, []byte, error) {
+	redirectURIs, err := json.Marshal(nonNil(client.RedirectURIs))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	grantTypes, err := json.Marshal(nonNil(client.GrantTypes))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if client.JWKS == nil {
+		return redirectURIs, grantTypes, nil, nil
+	}
+	jwks, err := json.Marshal(client.JWKS)
+	return redirectURIs, grantTypes, jwks, err
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+func scanClient(row interface{ Scan(...any) error }) (models.Client, error) {
+	var client models.Client
+	var secretHash, registrationTokenHash sql.NullString
+	var redirectURIs, grantTypes, jwks []byte
+	err := row.Scan(
+		&client.ID,
+		&secretHash,
+		&client.Name,
+		&client.Type,
+		&client.AuthMethod,
+		&redirectURIs,
+		&grantTypes,
+		&client.Scope,
+		&jwks,
+		&client.AccessTokenTTL,
+		&client.RefreshTokenTTL,
+		&client.CreatedAt,
+		&registrationTokenHash,
+		&client.FirstParty,
+	)
+	if err != nil {
+		return client, err
+	}
+	client.SecretHash = secretHash.String
+	client.RegistrationTokenHash = registrationTokenHash.String
+	err = json.Unmarshal(redirectURIs, &client.RedirectURIs)
+	if err != nil {
+		return client, err
+	}
+	err = json.Unmarshal(grantTypes, &client.GrantTypes)
+	if err != nil {
+		return client, err
+	}
+	if jwks != nil {
+		client.JWKS = &models.JWKSet{}
+		err = json.Unmarshal(jwks, client.JWKS)
+	}
+	return client, err
+}
+
+func clientAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrClientNotFound
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/models"
+	logger "github.com/sirupsen/logrus"
+)
+
+var ErrKeyRotationPending = errors.New("signing key rotation is already pending")
+
+// keys are rotated by a single replica at a time
+const keyRotationLock = 80001
+
+func (db *DBStruct) ListSigningKeys() ([]models.SigningKey, error) {
+	rows, err := db.db.Query(`SELECT kid, alg, private_key, created_at, activates_at, retires_at, expires_at
+		FROM signing_keys WHERE expires_at IS NULL OR expires_at > now() ORDER BY activates_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.SigningKey
+	for rows.Next() {
+		var key models.SigningKey
+		var retiresAt, expiresAt sql.NullTime
+		err = rows.Scan(&key.KID, &key.Alg, &key.PrivateKey, &key.CreatedAt, &key.ActivatesAt, &retiresAt, &expiresAt)
+		if err != nil {
+			return nil, err
+		}
+		key.RetiresAt = retiresAt.Time
+		key.ExpiresAt = expiresAt.Time
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// AddInitialSigningKey stores the key only if there is no usable key yet
+func (db *DBStruct) AddInitialSigningKey(key models.SigningKey) error {
+	logger.Debug("adding initial signing key")
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("SELECT pg_advisory_xact_lock($1)", keyRotationLock)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO signing_keys (kid, alg, private_key, activates_at)
+		SELECT $1, $2, $3, now() WHERE NOT EXISTS (
+			SELECT 1 FROM signing_keys WHERE expires_at IS NULL OR expires_at > now()
+		)`, key.KID, key.Alg, key.PrivateKey)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RotateSigningKey publishes the new key and retires the current ones at its activation
+func (db *DBStruct) RotateSigningKey(key models.SigningKey, verifyFor time.Duration) error {
+	logger.Debug("rotating signing key")
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("SELECT pg_advisory_xact_lock($1)", keyRotationLock)
+	if err != nil {
+		return err
+	}
+	var pending bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM signing_keys WHERE activates_at > now())").Scan(&pending)
+	if err != nil {
+		return err
+	}
+	if pending {
+		return ErrKeyRotationPending
+	}
+	_, err = tx.Exec(`UPDATE signing_keys SET retires_at=$1, expires_at=$1 + make_interval(secs => $2)
+		WHERE retires_at IS NULL`, key.ActivatesAt, verifyFor.Seconds())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO signing_keys (kid, alg, private_key, activates_at) VALUES ($1, $2, $3, $4)",
+		key.KID, key.Alg, key.PrivateKey, key.ActivatesAt)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	logger.Debug("signing key has been rotated")
+	return nil
+}
+
+func (db *DBStruct) PruneSigningKeys() (int64, error) {
+	res, err := db.db.Exec("DELETE FROM signing_keys WHERE expires_at <= now()")
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
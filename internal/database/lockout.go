@@ -0,0 +1,135 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/models"
+	logger "github.com/sirupsen/logrus"
+)
+
+type LockoutRepository interface {
+	GetAttempts(keys []string) ([]models.Attempts, error)
+	AddFailure(key string, window time.Duration) (models.Attempts, error)
+	LockKey(key string, until time.Time, notification *models.Notification) error
+	ResetFailures(key string) error
+	TakeAttempt(key string, config models.LockoutConfig) (models.Attempts, bool, error)
+	FailAttempt(key string) (models.Attempts, error)
+	ForgiveAttempt(key string) error
+}
+
+func (db *DBStruct) GetAttempts(keys []string) ([]models.Attempts, error) {
+	rows, err := db.db.Query("SELECT key, failures, last_failure_at, locked_until FROM auth_failures WHERE key = ANY($1)", keys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []models.Attempts
+	for rows.Next() {
+		var a models.Attempts
+		var lockedUntil sql.NullTime
+		err = rows.Scan(&a.Key, &a.Failures, &a.LastFailureAt, &lockedUntil)
+		if err != nil {
+			return nil, err
+		}
+		a.LockedUntil = lockedUntil.Time
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+// AddFailure counts a failure, the counter starts again after a quiet window or an expired lock
+func (db *DBStruct) AddFailure(key string, window time.Duration) (models.Attempts, error) {
+	a := models.Attempts{Key: key}
+	var lockedUntil sql.NullTime
+	err := db.db.QueryRow(`INSERT INTO auth_failures (key, failures, last_failure_at) VALUES ($1, 1, now())
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE
+				WHEN auth_failures.last_failure_at < now() - make_interval(secs => $2)
+					OR auth_failures.locked_until < now() THEN 1
+				ELSE auth_failures.failures + 1 END,
+			locked_until = CASE WHEN auth_failures.locked_until < now() THEN NULL ELSE auth_failures.locked_until END,
+			last_failure_at = now()
+		RETURNING failures, last_failure_at, locked_until`, key, window.Seconds()).
+		Scan(&a.Failures, &a.LastFailureAt, &lockedUntil)
+	a.LockedUntil = lockedUntil.Time
+	return a, err
+}
+
+// LockKey locks the account or address and queues the notification about it in one transaction
+func (db *DBStruct) LockKey(key string, until time.Time, notification *models.Notification) error {
+	logger.Debug("locking " + key)
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE auth_failures SET locked_until=$2 WHERE key=$1", key, until)
+	if err != nil {
+		return err
+	}
+	if notification != nil {
+		err = insertNotification(tx, *notification)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *DBStruct) ResetFailures(key string) error {
+	_, err := db.db.Exec("DELETE FROM auth_failures WHERE key=$1", key)
+	return err
+}
+
+// TakeAttempt counts the attempt as a failure before it is made, the check and the count are one
+// statement, so concurrent attempts can not all pass the delay. It returns false without counting
+// while the key is locked or has to wait. The attempt is settled with FailAttempt or ForgiveAttempt.
+func (db *DBStruct) TakeAttempt(key string, config models.LockoutConfig) (models.Attempts, bool, error) {
+	a := models.Attempts{Key: key}
+	var lockedUntil sql.NullTime
+	var taken bool
+	err := db.db.QueryRow(`WITH taken AS (
+			INSERT INTO auth_failures AS f (key, failures, last_failure_at) VALUES ($1, 1, now())
+			ON CONFLICT (key) DO UPDATE SET
+				failures = CASE
+					WHEN f.last_failure_at < now() - make_interval(secs => $2) OR f.locked_until <= now() THEN 1
+					ELSE f.failures + 1 END,
+				last_failure_at = CASE
+					WHEN f.last_failure_at < now() - make_interval(secs => $2) OR f.locked_until <= now() THEN now()
+					ELSE f.last_failure_at END,
+				locked_until = NULL
+			WHERE (f.locked_until IS NULL OR f.locked_until <= now()) AND (
+				f.last_failure_at < now() - make_interval(secs => $2) OR f.locked_until IS NOT NULL
+				OR f.failures <= $3::int
+				OR f.last_failure_at + make_interval(secs => LEAST($5::float8,
+					$4::float8 * power(2, f.failures - $3::int - 1))) <= now())
+			RETURNING f.failures, f.last_failure_at, f.locked_until
+		)
+		SELECT failures, last_failure_at, locked_until, true FROM taken
+		UNION ALL
+		SELECT failures, last_failure_at, locked_until, false FROM auth_failures
+			WHERE key=$1 AND NOT EXISTS (SELECT 1 FROM taken)`,
+		key, config.Window.Seconds(), config.FreeAttempts, config.DelayBase.Seconds(), config.DelayMax.Seconds()).
+		Scan(&a.Failures, &a.LastFailureAt, &lockedUntil, &taken)
+	a.LockedUntil = lockedUntil.Time
+	return a, taken, err
+}
+
+// FailAttempt settles a taken attempt which has failed, the delay starts from now
+func (db *DBStruct) FailAttempt(key string) (models.Attempts, error) {
+	a := models.Attempts{Key: key}
+	var lockedUntil sql.NullTime
+	err := db.db.QueryRow(`UPDATE auth_failures SET last_failure_at=now() WHERE key=$1
+		RETURNING failures, last_failure_at, locked_until`, key).Scan(&a.Failures, &a.LastFailureAt, &lockedUntil)
+	a.LockedUntil = lockedUntil.Time
+	return a, err
+}
+
+// ForgiveAttempt takes back the failure counted for a taken attempt which has succeeded
+func (db *DBStruct) ForgiveAttempt(key string) error {
+	_, err := db.db.Exec("UPDATE auth_failures SET failures = GREATEST(failures - 1, 0) WHERE key=$1", key)
+	return err
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/sater-151/tt-auth/internal/models"
+	logger "github.com/sirupsen/logrus"
+)
+
+var ErrMFANotEnrolled = errors.New("mfa is not enrolled")
+var ErrMFAAlreadyEnrolled = errors.New("mfa is already enrolled")
+
+type MFARepository interface {
+	GetTOTP(guid string) (models.TOTP, error)
+	SaveTOTP(guid, secret string) error
+	ConfirmTOTP(guid string, step int64, recoveryCodeHashes []string) error
+	UseTOTPStep(guid string, step int64) (bool, error)
+	UseRecoveryCode(guid, codeHash string) (bool, error)
+}
+
+func (db *DBStruct) GetTOTP(guid string) (models.TOTP, error) {
+	totp := models.TOTP{UserID: guid}
+	if !validUUID(guid) {
+		return totp, ErrMFANotEnrolled
+	}
+	var confirmedAt sql.NullTime
+	err := db.db.QueryRow("SELECT secret, confirmed_at, last_used_step FROM user_totp WHERE user_id=$1", guid).
+		Scan(&totp.Secret, &confirmedAt, &totp.LastUsedStep)
+	if err == sql.ErrNoRows {
+		return totp, ErrMFANotEnrolled
+	}
+	totp.Confirmed = confirmedAt.Valid
+	return totp, err
+}
+
+// SaveTOTP starts enrollment, an unconfirmed secret is replaced but a confirmed one is kept
+func (db *DBStruct) SaveTOTP(guid, secret string) error {
+	logger.Debug("saving totp secret")
+	res, err := db.db.Exec(`INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret=$2, last_used_step=0, created_at=now()
+		WHERE user_totp.confirmed_at IS NULL`, guid, secret)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrMFAAlreadyEnrolled
+	}
+	return nil
+}
+
+// ConfirmTOTP enables the authenticator and replaces recovery codes of the user
+func (db *DBStruct) ConfirmTOTP(guid string, step int64, recoveryCodeHashes []string) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE user_totp SET confirmed_at=now(), last_used_step=$2
+		WHERE user_id=$1 AND confirmed_at IS NULL AND last_used_step < $2`, guid, step)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrMFAAlreadyEnrolled
+	}
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id=$1", guid)
+	if err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		_, err = tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)", guid, hash)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep remembers the step of an accepted code, false means the code was already used
+func (db *DBStruct) UseTOTPStep(guid string, step int64) (bool, error) {
+	res, err := db.db.Exec(`UPDATE user_totp SET last_used_step=$2
+		WHERE user_id=$1 AND confirmed_at IS NOT NULL AND last_used_step < $2`, guid, step)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	return rows == 1, err
+}
+
+func (db *DBStruct) UseRecoveryCode(guid, codeHash string) (bool, error) {
+	res, err := db.db.Exec(`UPDATE recovery_codes SET used_at=now()
+		WHERE user_id=$1 AND code_hash=$2 AND used_at IS NULL`, guid, codeHash)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	return rows == 1, err
+}
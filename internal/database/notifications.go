@@ -0,0 +1,112 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/models"
+	logger "github.com/sirupsen/logrus"
+)
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertNotification(db execer, notification models.Notification) error {
+	_, err := db.Exec("INSERT INTO notifications (template, recipient, subject, body) VALUES ($1, $2, $3, $4)",
+		notification.Template, notification.Message.To, notification.Message.Subject, notification.Message.Body)
+	return err
+}
+
+func (db *DBStruct) EnqueueNotification(notification models.Notification) error {
+	logger.Debug("enqueueing notification")
+	return insertNotification(db.db, notification)
+}
+
+// ClaimNotifications takes due notifications and hides them from other workers for the lease
+func (db *DBStruct) ClaimNotifications(limit int, lease time.Duration) ([]models.Notification, error) {
+	rows, err := db.db.Query(`UPDATE notifications SET attempts=attempts+1,
+		next_attempt_at=now() + make_interval(secs => $2)
+		WHERE id IN (
+			SELECT id FROM notifications WHERE status='pending' AND next_attempt_at <= now()
+			ORDER BY next_attempt_at LIMIT $1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, template, recipient, subject, body, status, attempts, next_attempt_at, created_at`,
+		limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	return scanNotifications(rows)
+}
+
+func (db *DBStruct) MarkNotificationSent(id string) error {
+	_, err := db.db.Exec("UPDATE notifications SET status='sent', sent_at=now(), last_error=NULL WHERE id=$1", id)
+	return err
+}
+
+func (db *DBStruct) MarkNotificationFailed(id, lastError string, retryAt time.Time, dead bool) error {
+	status := models.NotificationPending
+	if dead {
+		status = models.NotificationDead
+	}
+	_, err := db.db.Exec("UPDATE notifications SET status=$2, last_error=$3, next_attempt_at=$4 WHERE id=$1",
+		id, status, lastError, retryAt)
+	return err
+}
+
+func (db *DBStruct) ListFailedNotifications(limit int) ([]models.Notification, error) {
+	rows, err := db.db.Query(`SELECT id, template, recipient, subject, body, status, attempts, next_attempt_at, created_at,
+		last_error FROM notifications WHERE status='dead' ORDER BY created_at DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []models.Notification
+	for rows.Next() {
+		var notification models.Notification
+		var lastError sql.NullString
+		err = rows.Scan(&notification.ID, &notification.Template, &notification.Message.To, &notification.Message.Subject,
+			&notification.Message.Body, &notification.Status, &notification.Attempts, &notification.NextAttemptAt,
+			&notification.CreatedAt, &lastError)
+		if err != nil {
+			return nil, err
+		}
+		notification.LastError = lastError.String
+		notifications = append(notifications, notification)
+	}
+	return notifications, rows.Err()
+}
+
+// RetryNotification gives a dead notification a new set of attempts
+func (db *DBStruct) RetryNotification(id string) error {
+	res, err := db.db.Exec(`UPDATE notifications SET status='pending', attempts=0, next_attempt_at=now()
+		WHERE id=$1 AND status='dead'`, id)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func scanNotifications(rows *sql.Rows) ([]models.Notification, error) {
+	defer rows.Close()
+	var notifications []models.Notification
+	for rows.Next() {
+		var notification models.Notification
+		err := rows.Scan(&notification.ID, &notification.Template, &notification.Message.To, &notification.Message.Subject,
+			&notification.Message.Body, &notification.Status, &notification.Attempts, &notification.NextAttemptAt,
+			&notification.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications, rows.Err()
+}
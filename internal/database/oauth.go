@@ -0,0 +1,120 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/models"
+	logger "github.com/sirupsen/logrus"
+)
+
+var ErrCodeInvalid = errors.New("authorization code is invalid or expired")
+var ErrCodeReused = errors.New("authorization code has been used already")
+var ErrConsentNotFound = errors.New("consent not found")
+
+type OAuthRepository interface {
+	CreateAuthorizationCode(code models.AuthorizationCode) error
+	GetAuthorizationCode(codeHash string) (models.AuthorizationCode, error)
+	ConsumeAuthorizationCode(codeHash string, session models.Session) (models.AuthorizationCode, error)
+	GetConsent(guid, clientID string) (string, error)
+	SaveConsent(guid, clientID, scope string) error
+}
+
+func (db *DBStruct) CreateAuthorizationCode(code models.AuthorizationCode) error {
+	logger.Debug("creating authorization code")
+	_, err := db.db.Exec(`INSERT INTO authorization_codes (code_hash, client_id, user_id, redirect_uri, scope, expires_at,
+		code_challenge, redirect_uri_sent) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		code.Hash, code.ClientID, code.UserID, code.RedirectURI, code.Scope, code.ExpiresAt, code.CodeChallenge,
+		code.RedirectURISent)
+	return err
+}
+
+// GetAuthorizationCode looks the code up without using it. A used code is returned with ErrCodeReused
+// and the session it has started.
+func (db *DBStruct) GetAuthorizationCode(codeHash string) (models.AuthorizationCode, error) {
+	code := models.AuthorizationCode{Hash: codeHash}
+	var usedAt sql.NullTime
+	var usedBy sql.NullString
+	err := db.db.QueryRow(`SELECT client_id, user_id, redirect_uri, scope, expires_at, code_challenge, redirect_uri_sent,
+		used_at, session_id FROM authorization_codes WHERE code_hash=$1`, codeHash).Scan(
+		&code.ClientID,
+		&code.UserID,
+		&code.RedirectURI,
+		&code.Scope,
+		&code.ExpiresAt,
+		&code.CodeChallenge,
+		&code.RedirectURISent,
+		&usedAt,
+		&usedBy,
+	)
+	if err == sql.ErrNoRows {
+		return code, ErrCodeInvalid
+	}
+	if err != nil {
+		return code, err
+	}
+	if usedAt.Valid {
+		code.SessionID = usedBy.String
+		return code, ErrCodeReused
+	}
+	if code.ExpiresAt.Before(time.Now()) {
+		return code, ErrCodeInvalid
+	}
+	return code, nil
+}
+
+// ConsumeAuthorizationCode marks the code used and starts its session in one transaction, so the code
+// can be exchanged only once and a replay always finds the session to end. When another exchange
+// has used the code first, it is returned like by GetAuthorizationCode.
+func (db *DBStruct) ConsumeAuthorizationCode(codeHash string, session models.Session) (models.AuthorizationCode, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return models.AuthorizationCode{}, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE authorization_codes SET used_at=now(), session_id=$2
+		WHERE code_hash=$1 AND used_at IS NULL AND expires_at > now()`, codeHash, session.ID)
+	if err != nil {
+		return models.AuthorizationCode{}, err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return models.AuthorizationCode{}, err
+	}
+	if count == 0 {
+		code, err := db.GetAuthorizationCode(codeHash)
+		if err == nil {
+			err = ErrCodeInvalid
+		}
+		return code, err
+	}
+	err = insertSession(tx, session)
+	if err != nil {
+		return models.AuthorizationCode{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return models.AuthorizationCode{}, err
+	}
+	return models.AuthorizationCode{Hash: codeHash, SessionID: session.ID}, nil
+}
+
+// GetConsent returns the scope the user has granted to the client
+func (db *DBStruct) GetConsent(guid, clientID string) (string, error) {
+	var scope string
+	err := db.db.QueryRow("SELECT scope FROM oauth_consents WHERE user_id=$1 AND client_id=$2", guid, clientID).Scan(&scope)
+	if err == sql.ErrNoRows {
+		return "", ErrConsentNotFound
+	}
+	return scope, err
+}
+
+// SaveConsent records the scope the user grants to the client, it replaces the one granted before
+func (db *DBStruct) SaveConsent(guid, clientID, scope string) error {
+	logger.Debug("saving consent of user " + guid + " to client " + clientID)
+	_, err := db.db.Exec(`INSERT INTO oauth_consents (user_id, client_id, scope) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, client_id) DO UPDATE SET scope=EXCLUDED.scope, updated_at=now()`, guid, clientID, scope)
+	return err
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// TakeRateTokens refills the buckets of the keys for the time passed since their last use
+// and takes one token from each of them if none is empty.
+// It returns the tokens left in the order of the keys and whether the tokens were taken.
+func (db *DBStruct) TakeRateTokens(keys []string, rate float64, burst int) ([]float64, bool, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	// rows are locked in the same order by every request, so they can not deadlock
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return keys[order[a]] < keys[order[b]] })
+
+	// the upserts lock the rows until the tokens are taken
+	tokens := make([]float64, len(keys))
+	allowed := true
+	for _, i := range order {
+		err = tx.QueryRow(`INSERT INTO rate_limits AS r (key, tokens, updated_at) VALUES ($1, $3::float8, now())
+			ON CONFLICT (key) DO UPDATE SET
+				tokens = LEAST($3::float8, r.tokens + EXTRACT(EPOCH FROM now() - r.updated_at)::float8 * $2::float8),
+				updated_at = now()
+			RETURNING tokens`, keys[i], rate, burst).Scan(&tokens[i])
+		if err != nil {
+			return nil, false, err
+		}
+		if tokens[i] < 1 {
+			allowed = false
+		}
+	}
+	if !allowed {
+		return tokens, false, tx.Commit()
+	}
+	_, err = tx.Exec("UPDATE rate_limits SET tokens = tokens - 1 WHERE key = ANY($1)", keys)
+	if err != nil {
+		return nil, false, err
+	}
+	for i := range tokens {
+		tokens[i]--
+	}
+	return tokens, true, tx.Commit()
+}
+
+// PruneRateLimits deletes buckets which have been refilled long ago
+func (db *DBStruct) PruneRateLimits(idle time.Duration) (int64, error) {
+	res, err := db.db.Exec("DELETE FROM rate_limits WHERE updated_at < now() - make_interval(secs => $1)", idle.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,182 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/models"
+	logger "github.com/sirupsen/logrus"
+)
+
+func (db *DBStruct) CreateSession(session models.Session) (string, error) {
+	logger.Debug("creating session")
+	err := insertSession(db.db, session)
+	if err != nil {
+		return "", err
+	}
+	logger.Debug("session has been created")
+	return session.ID, nil
+}
+
+func insertSession(db execer, session models.Session) error {
+	_, err := db.Exec(`INSERT INTO sessions (id, user_id, rt_selector, rt_hash, user_agent, client_ip, expires_at, idle_expires_at,
+		client_id, scope)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), $10)`,
+		session.ID, session.UserID, session.RTSelector, session.RTHash, session.UserAgent, session.ClientIP,
+		session.ExpiresAt, nullTime(session.IdleExpiresAt), session.ClientID, session.Scope)
+	return err
+}
+
+const sessionColumns = `id, user_id, rt_selector, rt_hash, user_agent, client_ip, created_at, last_used_at, expires_at,
+	idle_expires_at, client_id, scope`
+
+func scanSession(row *sql.Row) (models.Session, error) {
+	var session models.Session
+	var userAgent, clientIP, clientID sql.NullString
+	var idleExpiresAt sql.NullTime
+	err := row.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.RTSelector,
+		&session.RTHash,
+		&userAgent,
+		&clientIP,
+		&session.CreatedAt,
+		&session.LastUsedAt,
+		&session.ExpiresAt,
+		&idleExpiresAt,
+		&clientID,
+		&session.Scope,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return session, ErrUnauthorized
+		}
+		return session, err
+	}
+	session.UserAgent = userAgent.String
+	session.ClientIP = clientIP.String
+	session.IdleExpiresAt = idleExpiresAt.Time
+	session.ClientID = clientID.String
+	return session, nil
+}
+
+func (db *DBStruct) GetSession(selector string) (models.Session, error) {
+	return scanSession(db.db.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE rt_selector=$1 AND revoked_at IS NULL",
+		selector))
+}
+
+func (db *DBStruct) GetSessionByID(id string) (models.Session, error) {
+	return scanSession(db.db.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE id=$1 AND revoked_at IS NULL", id))
+}
+
+// RotateSession replaces the presented refresh token of the session. The notification is enqueued in the same
+// transaction, so it is sent only if the rotation succeeds. sql.ErrNoRows means the session has been revoked
+// or the presented token has been rotated by a concurrent request.
+func (db *DBStruct) RotateSession(presentedSelector string, session models.Session, notification *models.Notification) error {
+	logger.Debug("rotating session refresh token")
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldSelector, oldHash string
+	err = tx.QueryRow(`SELECT rt_selector, rt_hash FROM sessions WHERE id=$1 AND rt_selector=$2 AND revoked_at IS NULL
+		FOR UPDATE`, session.ID, presentedSelector).Scan(&oldSelector, &oldHash)
+	if err != nil {
+		return err
+	}
+	// remember the rotated token so that its reuse can be detected
+	_, err = tx.Exec("INSERT INTO rotated_tokens (rt_selector, rt_hash, session_id) VALUES ($1, $2, $3)",
+		oldSelector, oldHash, session.ID)
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec(`UPDATE sessions SET rt_selector=$2, rt_hash=$3, user_agent=$4, client_ip=$5, idle_expires_at=$6,
+		last_used_at=now() WHERE id=$1 AND rt_selector=$7`,
+		session.ID, session.RTSelector, session.RTHash, session.UserAgent, session.ClientIP, nullTime(session.IdleExpiresAt),
+		presentedSelector)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	if notification != nil {
+		err = insertNotification(tx, *notification)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	logger.Debug("session refresh token has been rotated")
+	return nil
+}
+
+func (db *DBStruct) GetRotatedSession(selector string) (models.Session, error) {
+	var session models.Session
+	var clientIP, clientID sql.NullString
+	err := db.db.QueryRow(`SELECT s.id, s.user_id, s.client_ip, s.client_id, r.rt_selector, r.rt_hash FROM rotated_tokens r
+		JOIN sessions s ON s.id=r.session_id WHERE r.rt_selector=$1`, selector).Scan(
+		&session.ID,
+		&session.UserID,
+		&clientIP,
+		&clientID,
+		&session.RTSelector,
+		&session.RTHash,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return session, ErrUnauthorized
+		}
+		return session, err
+	}
+	session.ClientIP = clientIP.String
+	session.ClientID = clientID.String
+	return session, nil
+}
+
+func (db *DBStruct) RevokeSession(id string) error {
+	logger.Debug("revoking session")
+	res, err := db.db.Exec("UPDATE sessions SET revoked_at=now() WHERE id=$1 AND revoked_at IS NULL", id)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	logger.Debug("session has been revoked")
+	return nil
+}
+
+func (db *DBStruct) RevokeUserSessions(guid string) error {
+	logger.Debug("revoking all sessions of user")
+	_, err := db.db.Exec("UPDATE sessions SET revoked_at=now() WHERE user_id=$1 AND revoked_at IS NULL", guid)
+	if err != nil {
+		return err
+	}
+	logger.Debug("sessions have been revoked")
+	return nil
+}
+
+func (db *DBStruct) AddSecurityEvent(event models.SecurityEvent) error {
+	_, err := db.db.Exec("INSERT INTO security_events (user_id, session_id, event) VALUES ($1, NULLIF($2, '')::uuid, $3)",
+		event.UserID, event.SessionID, event.Event)
+	return err
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,216 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/models"
+	logger "github.com/sirupsen/logrus"
+)
+
+var ErrTokenInvalid = errors.New("token is invalid, expired or already used")
+
+func insertUserToken(db execer, token models.UserToken) error {
+	_, err := db.Exec(`INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at, binding_hash)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''))`, token.UserID, token.Purpose, token.Hash, token.ExpiresAt, token.BindingHash)
+	return err
+}
+
+// consumeUserToken marks the token used and returns its user, a token works only once
+func consumeUserToken(tx *sql.Tx, purpose, hash string) (string, error) {
+	var userID string
+	err := tx.QueryRow(`UPDATE user_tokens SET used_at=now()
+		WHERE token_hash=$1 AND purpose=$2 AND used_at IS NULL AND expires_at > now()
+		RETURNING user_id`, hash, purpose).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", ErrTokenInvalid
+	}
+	return userID, err
+}
+
+// RegisterUser creates the user together with the verification token and the email carrying it
+func (db *DBStruct) RegisterUser(user models.User, passwordHash string, token models.UserToken,
+	notification models.Notification) (models.User, error) {
+	logger.Debug("registering user")
+	tx, err := db.db.Begin()
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	created, err := scanUser(tx.QueryRow(`INSERT INTO users (email, email_verified, display_name, status, password_hash)
+		VALUES ($1, false, $2, $3, $4) RETURNING `+userColumns,
+		user.Email, user.DisplayName, user.Status, passwordHash))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return models.User{}, ErrEmailTaken
+		}
+		return models.User{}, err
+	}
+	token.UserID = created.ID
+	err = insertUserToken(tx, token)
+	if err != nil {
+		return models.User{}, err
+	}
+	err = insertNotification(tx, notification)
+	if err != nil {
+		return models.User{}, err
+	}
+	return created, tx.Commit()
+}
+
+func (db *DBStruct) VerifyEmail(tokenHash string) (string, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	userID, err := consumeUserToken(tx, models.TokenVerifyEmail, tokenHash)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec("UPDATE users SET email_verified=true, updated_at=now() WHERE id=$1", userID)
+	if err != nil {
+		return "", err
+	}
+	return userID, tx.Commit()
+}
+
+// CreateUserToken replaces unused tokens of the same purpose and queues the email carrying the new one
+func (db *DBStruct) CreateUserToken(token models.UserToken, notification *models.Notification) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE user_tokens SET used_at=now() WHERE user_id=$1 AND purpose=$2 AND used_at IS NULL",
+		token.UserID, token.Purpose)
+	if err != nil {
+		return err
+	}
+	err = insertUserToken(tx, token)
+	if err != nil {
+		return err
+	}
+	if notification != nil {
+		err = insertNotification(tx, *notification)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *DBStruct) GetUserToken(purpose, tokenHash string) (models.UserToken, error) {
+	token := models.UserToken{Purpose: purpose, Hash: tokenHash}
+	err := db.db.QueryRow(`SELECT user_id, expires_at, attempts FROM user_tokens
+		WHERE token_hash=$1 AND purpose=$2 AND used_at IS NULL AND expires_at > now()`, tokenHash, purpose).
+		Scan(&token.UserID, &token.ExpiresAt, &token.Attempts)
+	if err == sql.ErrNoRows {
+		return token, ErrTokenInvalid
+	}
+	return token, err
+}
+
+func (db *DBStruct) ConsumeUserToken(purpose, tokenHash string) (string, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	userID, err := consumeUserToken(tx, purpose, tokenHash)
+	if err != nil {
+		return "", err
+	}
+	return userID, tx.Commit()
+}
+
+// FailUserToken counts a wrong answer to the token, it is burnt after maxAttempts
+func (db *DBStruct) FailUserToken(purpose, tokenHash string, maxAttempts int) error {
+	_, err := db.db.Exec(`UPDATE user_tokens SET attempts=attempts+1,
+		used_at=CASE WHEN attempts+1 >= $3 THEN now() END
+		WHERE token_hash=$1 AND purpose=$2 AND used_at IS NULL`, tokenHash, purpose, maxAttempts)
+	return err
+}
+
+// ResetPassword sets the new password and closes every session of the user
+func (db *DBStruct) ResetPassword(tokenHash, passwordHash string) (string, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	userID, err := consumeUserToken(tx, models.TokenResetPassword, tokenHash)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec("UPDATE users SET password_hash=$2, updated_at=now() WHERE id=$1", userID, passwordHash)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec("UPDATE sessions SET revoked_at=now() WHERE user_id=$1 AND revoked_at IS NULL", userID)
+	if err != nil {
+		return "", err
+	}
+	return userID, tx.Commit()
+}
+
+// CreateLimitedUserToken works like CreateUserToken unless the user already got limit tokens
+// of the purpose within the window, then nothing is created and false is returned
+func (db *DBStruct) CreateLimitedUserToken(token models.UserToken, notification models.Notification, limit int,
+	window time.Duration) (bool, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// the user row serializes concurrent requests of the same user
+	_, err = tx.Exec("SELECT id FROM users WHERE id=$1 FOR UPDATE", token.UserID)
+	if err != nil {
+		return false, err
+	}
+	var issued int
+	err = tx.QueryRow(`SELECT count(*) FROM user_tokens
+		WHERE user_id=$1 AND purpose=$2 AND created_at > now() - make_interval(secs => $3)`,
+		token.UserID, token.Purpose, window.Seconds()).Scan(&issued)
+	if err != nil {
+		return false, err
+	}
+	if issued >= limit {
+		return false, nil
+	}
+
+	_, err = tx.Exec("UPDATE user_tokens SET used_at=now() WHERE user_id=$1 AND purpose=$2 AND used_at IS NULL",
+		token.UserID, token.Purpose)
+	if err != nil {
+		return false, err
+	}
+	err = insertUserToken(tx, token)
+	if err != nil {
+		return false, err
+	}
+	err = insertNotification(tx, notification)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// ConsumeBoundUserToken is ConsumeUserToken for tokens tied to a browser,
+// a request from another browser leaves the token untouched
+func (db *DBStruct) ConsumeBoundUserToken(purpose, tokenHash, bindingHash string) (string, error) {
+	var userID string
+	err := db.db.QueryRow(`UPDATE user_tokens SET used_at=now()
+		WHERE token_hash=$1 AND purpose=$2 AND binding_hash=$3 AND used_at IS NULL AND expires_at > now()
+		RETURNING user_id`, tokenHash, purpose, bindingHash).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", ErrTokenInvalid
+	}
+	return userID, err
+}
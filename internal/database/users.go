@@ -0,0 +1,155 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/sater-151/tt-auth/internal/models"
+	logger "github.com/sirupsen/logrus"
+)
+
+var ErrEmailTaken = errors.New("email or username is already taken")
+
+type UserRepository interface {
+	GetUser(guid string) (models.User, error)
+	GetUserByEmail(email string) (models.User, error)
+	GetUserByLogin(login string) (models.User, error)
+	GetPasswordHash(guid string) (string, error)
+	SetPasswordHash(guid, hash string) error
+	CreateUser(user models.User) (models.User, error)
+	UpdateUser(user models.User) error
+	SetUserStatus(guid, status string) error
+	RegisterUser(user models.User, passwordHash string, token models.UserToken, notification models.Notification) (models.User, error)
+	VerifyEmail(tokenHash string) (string, error)
+	CreateUserToken(token models.UserToken, notification *models.Notification) error
+	GetUserToken(purpose, tokenHash string) (models.UserToken, error)
+	ConsumeUserToken(purpose, tokenHash string) (string, error)
+	FailUserToken(purpose, tokenHash string, maxAttempts int) error
+	CreateLimitedUserToken(token models.UserToken, notification models.Notification, limit int, window time.Duration) (bool, error)
+	ConsumeBoundUserToken(purpose, tokenHash, bindingHash string) (string, error)
+	ResetPassword(tokenHash, passwordHash string) (string, error)
+}
+
+const userColumns = "id, email, username, email_verified, display_name, status, created_at, updated_at"
+
+func scanUser(row *sql.Row) (models.User, error) {
+	var user models.User
+	var email, username sql.NullString
+	err := row.Scan(&user.ID, &email, &username, &user.EmailVerified, &user.DisplayName, &user.Status, &user.CreatedAt,
+		&user.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return user, ErrUserNotFound
+		}
+		return user, err
+	}
+	user.Email = email.String
+	user.Username = username.String
+	return user, nil
+}
+
+func (db *DBStruct) GetUser(guid string) (models.User, error) {
+	if !validUUID(guid) {
+		return models.User{}, ErrUserNotFound
+	}
+	return scanUser(db.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id=$1", guid))
+}
+
+func (db *DBStruct) GetUserByEmail(email string) (models.User, error) {
+	return scanUser(db.db.QueryRow("SELECT "+userColumns+" FROM users WHERE lower(email)=lower($1)", email))
+}
+
+// GetUserByLogin looks the user up by email or username
+func (db *DBStruct) GetUserByLogin(login string) (models.User, error) {
+	return scanUser(db.db.QueryRow("SELECT "+userColumns+" FROM users WHERE lower(email)=lower($1) OR lower(username)=lower($1)",
+		login))
+}
+
+func (db *DBStruct) GetPasswordHash(guid string) (string, error) {
+	var hash sql.NullString
+	err := db.db.QueryRow("SELECT password_hash FROM users WHERE id=$1", guid).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", ErrUserNotFound
+	}
+	return hash.String, err
+}
+
+func (db *DBStruct) SetPasswordHash(guid, hash string) error {
+	if !validUUID(guid) {
+		return ErrUserNotFound
+	}
+	res, err := db.db.Exec("UPDATE users SET password_hash=$2, updated_at=now() WHERE id=$1", guid, hash)
+	return checkUserUpdated(res, err)
+}
+
+func (db *DBStruct) CreateUser(user models.User) (models.User, error) {
+	logger.Debug("creating user")
+	if user.Status == "" {
+		user.Status = models.UserActive
+	}
+	created, err := scanUser(db.db.QueryRow(`INSERT INTO users (email, username, email_verified, display_name, status)
+		VALUES (NULLIF($1, ''), NULLIF($2, ''), $3, $4, $5) RETURNING `+userColumns,
+		user.Email, user.Username, user.EmailVerified, user.DisplayName, user.Status))
+	if isUniqueViolation(err) {
+		return created, ErrEmailTaken
+	}
+	return created, err
+}
+
+func (db *DBStruct) UpdateUser(user models.User) error {
+	res, err := db.db.Exec(`UPDATE users SET email=NULLIF($2, ''), username=NULLIF($3, ''), email_verified=$4,
+		display_name=$5, updated_at=now() WHERE id=$1`, user.ID, user.Email, user.Username, user.EmailVerified, user.DisplayName)
+	if isUniqueViolation(err) {
+		return ErrEmailTaken
+	}
+	return checkUserUpdated(res, err)
+}
+
+func (db *DBStruct) SetUserStatus(guid, status string) error {
+	if !validUUID(guid) {
+		return ErrUserNotFound
+	}
+	res, err := db.db.Exec("UPDATE users SET status=$2, updated_at=now() WHERE id=$1", guid, status)
+	return checkUserUpdated(res, err)
+}
+
+func checkUserUpdated(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// validUUID keeps malformed guids from reaching postgres as a syntax error
+func validUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, c := range s {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/models"
+	logger "github.com/sirupsen/logrus"
+)
+
+var ErrCredentialExists = errors.New("credential is already registered")
+
+type WebAuthnRepository interface {
+	SaveWebAuthnSession(sessionHash, ceremony string, data []byte, expiresAt time.Time) error
+	TakeWebAuthnSession(sessionHash, ceremony string) ([]byte, error)
+	ListWebAuthnCredentials(guid string) ([]models.WebAuthnCredential, error)
+	AddWebAuthnCredential(credential models.WebAuthnCredential) error
+	UpdateWebAuthnCredential(credential models.WebAuthnCredential) (bool, error)
+}
+
+func (db *DBStruct) SaveWebAuthnSession(sessionHash, ceremony string, data []byte, expiresAt time.Time) error {
+	_, err := db.db.Exec("DELETE FROM webauthn_sessions WHERE expires_at < now()")
+	if err != nil {
+		return err
+	}
+	_, err = db.db.Exec("INSERT INTO webauthn_sessions (session_hash, ceremony, data, expires_at) VALUES ($1, $2, $3, $4)",
+		sessionHash, ceremony, data, expiresAt)
+	return err
+}
+
+// TakeWebAuthnSession deletes the session, so a challenge is answered only once
+func (db *DBStruct) TakeWebAuthnSession(sessionHash, ceremony string) ([]byte, error) {
+	var data []byte
+	err := db.db.QueryRow(`DELETE FROM webauthn_sessions WHERE session_hash=$1 AND ceremony=$2 AND expires_at > now()
+		RETURNING data`, sessionHash, ceremony).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, ErrTokenInvalid
+	}
+	return data, err
+}
+
+func (db *DBStruct) ListWebAuthnCredentials(guid string) ([]models.WebAuthnCredential, error) {
+	if !validUUID(guid) {
+		return nil, nil
+	}
+	rows, err := db.db.Query("SELECT user_id, credential_id, sign_count, credential FROM webauthn_credentials WHERE user_id=$1",
+		guid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var credentials []models.WebAuthnCredential
+	for rows.Next() {
+		var credential models.WebAuthnCredential
+		err = rows.Scan(&credential.UserID, &credential.CredentialID, &credential.SignCount, &credential.Credential)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials, rows.Err()
+}
+
+func (db *DBStruct) AddWebAuthnCredential(credential models.WebAuthnCredential) error {
+	logger.Debug("adding webauthn credential")
+	_, err := db.db.Exec(`INSERT INTO webauthn_credentials (user_id, credential_id, sign_count, credential)
+		VALUES ($1, $2, $3, $4)`, credential.UserID, credential.CredentialID, credential.SignCount, credential.Credential)
+	if isUniqueViolation(err) {
+		return ErrCredentialExists
+	}
+	return err
+}
+
+// UpdateWebAuthnCredential saves the credential after login. False means the sign count did not grow,
+// which happens when two logins race with the same assertion or the authenticator was cloned.
+func (db *DBStruct) UpdateWebAuthnCredential(credential models.WebAuthnCredential) (bool, error) {
+	res, err := db.db.Exec(`UPDATE webauthn_credentials SET sign_count=$3, credential=$4, last_used_at=now()
+		WHERE user_id=$1 AND credential_id=$2 AND (sign_count < $3 OR (sign_count = 0 AND $3 = 0))`,
+		credential.UserID, credential.CredentialID, credential.SignCount, credential.Credential)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	return rows == 1, err
+}
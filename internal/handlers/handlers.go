@@ -1,190 +1,250 @@
-package handlers
-
-import (
-	"database/sql"
-	"encoding/base64"
-	"errors"
-	"fmt"
-	"net/http"
-	"os"
-	"strconv"
-	"time"
-
-	"github.com/sater-151/tt-auth/internal/database"
-	"github.com/sater-151/tt-auth/internal/service"
-	"github.com/sater-151/tt-auth/internal/utils"
-	logger "github.com/sirupsen/logrus"
-)
-
-var ErrGUIDRequired = errors.New("guid required")
-
-func GetTokens(s service.ServiceInterface) http.HandlerFunc {
-	return func(res http.ResponseWriter, req *http.Request) {
-		logger.Info("getting tokens")
-		guid := req.FormValue("guid")
-		if guid == "" {
-			logger.Error(ErrGUIDRequired)
-			http.Error(res, ErrGUIDRequired.Error(), http.StatusBadRequest)
-			return
-		}
-
-		aToken, rToken, err := utils.GenerateTokens(req.Host)
-		if err != nil {
-			logger.Error(err)
-			http.Error(res, "", http.StatusInternalServerError)
-			return
-		}
-		// save refresh token
-		err = s.InsertRT(guid, rToken)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				logger.Error(err)
-				http.Error(res, "", http.StatusUnauthorized)
-				return
-			} else {
-				logger.Error(err)
-				http.Error(res, "", http.StatusInternalServerError)
-				return
-			}
-		}
-
-		rtB64 := base64.StdEncoding.EncodeToString([]byte(rToken))
-		atTimeExp, err := strconv.Atoi(os.Getenv("ATEXPIRES"))
-		if err != nil {
-			logger.Error(err)
-			http.Error(res, "", http.StatusInternalServerError)
-			return
-		}
-		rtTimeExp, err := strconv.Atoi(os.Getenv("RTEXPIRES"))
-		if err != nil {
-			logger.Error(err)
-			http.Error(res, "", http.StatusInternalServerError)
-			return
-		}
-		atExp := time.Now().Add(time.Duration(atTimeExp) * time.Second)
-		rtExp := time.Now().Add(time.Duration(rtTimeExp) * time.Second)
-		http.SetCookie(res, &http.Cookie{
-			Name:     "at",
-			Value:    aToken,
-			Expires:  atExp,
-			HttpOnly: true,
-		})
-		http.SetCookie(res, &http.Cookie{
-			Name:     "rt",
-			Value:    rtB64,
-			Expires:  rtExp,
-			HttpOnly: true,
-		})
-		logger.Info("tokens have been sent")
-	}
-}
-
-func RefreshTokens(s service.ServiceInterface) http.HandlerFunc {
-	return func(res http.ResponseWriter, req *http.Request) {
-		logger.Info("refreshing tokens")
-		guid := req.FormValue("guid")
-		if guid == "" {
-			logger.Error(ErrGUIDRequired)
-			http.Error(res, ErrGUIDRequired.Error(), http.StatusBadRequest)
-			return
-		}
-
-		rtCookie, err := req.Cookie("rt")
-		if err != nil {
-			logger.Error(err)
-			http.Error(res, "", http.StatusUnauthorized)
-			return
-		}
-		gettingRTBase64, err := base64.StdEncoding.DecodeString(rtCookie.Value)
-		if err != nil {
-			logger.Error(err)
-			http.Error(res, "", http.StatusInternalServerError)
-			return
-		}
-
-		logger.Debug("starting generate tokens")
-		aToken, rToken, err := utils.GenerateTokens(req.Host)
-		if err != nil {
-			logger.Error(err)
-			http.Error(res, "", http.StatusInternalServerError)
-			return
-		}
-
-		logger.Debug("comparing refresh tokens")
-		comp, err := s.CompareRT(string(gettingRTBase64), guid)
-		if err != nil {
-			fmt.Println(3)
-			logger.Error(err)
-			http.Error(res, "", http.StatusInternalServerError)
-			return
-		}
-		if !comp {
-			logger.Error(database.ErrUnauthorized)
-			http.Error(res, "", http.StatusUnauthorized)
-			return
-		}
-		atCook, err := req.Cookie("at")
-		if err != nil {
-			logger.Error(err)
-			http.Error(res, "", http.StatusUnauthorized)
-			return
-		}
-
-		logger.Debug("checking host")
-		ok, err := utils.CheckHost(atCook.Value, req.Host)
-		if err != nil {
-			logger.Error(err)
-			http.Error(res, "", http.StatusInternalServerError)
-			return
-		}
-		if !ok {
-			logger.Warn("another ip")
-			s.EmailWarning(guid)
-		}
-
-		// save refresh token
-		err = s.InsertRT(guid, rToken)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				logger.Error(err)
-				http.Error(res, "", http.StatusUnauthorized)
-				return
-			} else {
-				logger.Error(err)
-				http.Error(res, "", http.StatusInternalServerError)
-				return
-			}
-		}
-
-		rtB64 := base64.StdEncoding.EncodeToString([]byte(rToken))
-
-		atTimeExp, err := strconv.Atoi(os.Getenv("ATEXPIRES"))
-		if err != nil {
-			logger.Error(err)
-			http.Error(res, "", http.StatusInternalServerError)
-			return
-		}
-		rtTimeExp, err := strconv.Atoi(os.Getenv("RTEXPIRES"))
-		if err != nil {
-			logger.Error(err)
-			http.Error(res, "", http.StatusInternalServerError)
-			return
-		}
-		atExp := time.Now().Add(time.Duration(atTimeExp) * time.Second)
-		rtExp := time.Now().Add(time.Duration(rtTimeExp) * time.Second)
-
-		http.SetCookie(res, &http.Cookie{
-			Name:     "at",
-			Value:    aToken,
-			Expires:  atExp,
-			HttpOnly: true,
-		})
-		http.SetCookie(res, &http.Cookie{
-			Name:     "rt",
-			Value:    rtB64,
-			Expires:  rtExp,
-			HttpOnly: true,
-		})
-		logger.Info("tokens have been refreshed")
-	}
-}
+package handlers
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/service"
+	"github.com/sater-151/tt-auth/internal/utils"
+	logger "github.com/sirupsen/logrus"
+)
+
+var ErrGUIDRequired = errors.New("guid required")
+
+func RefreshTokens(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logger.Info("refreshing tokens")
+		guid := req.FormValue("guid")
+		if guid == "" {
+			logger.Error(ErrGUIDRequired)
+			http.Error(res, ErrGUIDRequired.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rtCookie, err := req.Cookie("rt")
+		if err != nil {
+			logger.Error(err)
+			http.Error(res, "", http.StatusUnauthorized)
+			return
+		}
+		gettingRTBase64, err := base64.StdEncoding.DecodeString(rtCookie.Value)
+		if err != nil {
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+
+		// failures are counted per address only, the guid is not proven before the token is checked
+		clientIP := utils.ClientIP(req)
+		err = s.TakeAttempt(clientIP)
+		if err != nil {
+			if writeUserError(res, err) {
+				return
+			}
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+		logger.Debug("comparing refresh tokens")
+		session, err := s.CompareRT(string(gettingRTBase64), guid)
+		// an expired token is an honest mistake, a wrong or replayed one is not
+		failed := errors.Is(err, database.ErrUnauthorized) || errors.Is(err, service.ErrRTReused)
+		finishErr := s.FinishAttempt(clientIP, failed)
+		if finishErr != nil {
+			logger.Error(finishErr)
+		}
+		if err == nil {
+			// the owner of the session is known now, a locked account can not refresh either
+			err = s.CheckAttempts(session.UserID, "")
+		}
+		if err != nil {
+			if writeUserError(res, err) {
+				return
+			}
+			if errors.Is(err, database.ErrUnauthorized) || errors.Is(err, service.ErrRTReused) ||
+				errors.Is(err, service.ErrRTExpired) {
+				logger.Error(err)
+				http.Error(res, "", http.StatusUnauthorized)
+				return
+			}
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+		atCook, err := req.Cookie("at")
+		if err != nil {
+			logger.Error(err)
+			http.Error(res, "", http.StatusUnauthorized)
+			return
+		}
+
+		logger.Debug("checking host")
+		ok, err := utils.CheckHost(atCook.Value, session.ID, clientIP)
+		if err != nil {
+			// the key of an old token may have been pruned or the token is forged,
+			// its host is unknown either way
+			logger.Warn(err)
+			ok = false
+		}
+		var warning *models.LoginWarning
+		if !ok {
+			logger.Warn("another ip")
+			warning = &models.LoginWarning{
+				OldIP:     session.ClientIP,
+				NewIP:     clientIP,
+				UserAgent: req.UserAgent(),
+			}
+		}
+
+		logger.Debug("starting generate tokens")
+		aToken, rToken, err := utils.GenerateTokens(guid, session.ID, clientIP)
+		if err != nil {
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+		atExp, _, err := utils.TokensExpiration()
+		if err != nil {
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+		idleExp, err := utils.IdleExpiration()
+		if err != nil {
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+
+		// rotate refresh token of the presented session only,
+		// the absolute lifetime of the session stays the same
+		session.RT = rToken
+		session.UserAgent = req.UserAgent()
+		session.ClientIP = clientIP
+		session.IdleExpiresAt = idleExp
+		err = s.RotateSession(session, warning)
+		if err != nil {
+			if errors.Is(err, service.ErrRTReused) {
+				logger.Error(err)
+				http.Error(res, "", http.StatusUnauthorized)
+				return
+			} else {
+				logger.Error(err)
+				http.Error(res, "", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		setTokenCookies(res, aToken, rToken, atExp, session.ExpiresAt)
+		logger.Info("tokens have been refreshed")
+	}
+}
+
+// issueTokens starts a new session of the user and sets the token cookies, amr names the methods
+// the user has authenticated with. It writes the error response itself and returns false on failure.
+func issueTokens(res http.ResponseWriter, req *http.Request, s service.ServiceInterface, guid string,
+	amr ...string) bool {
+	clientIP := utils.ClientIP(req)
+	sessionID, err := utils.NewUUID()
+	if err != nil {
+		logger.Error(err)
+		http.Error(res, "", http.StatusInternalServerError)
+		return false
+	}
+	aToken, rToken, err := utils.GenerateTokens(guid, sessionID, clientIP, amr...)
+	if err != nil {
+		logger.Error(err)
+		http.Error(res, "", http.StatusInternalServerError)
+		return false
+	}
+	atExp, rtExp, err := utils.TokensExpiration()
+	if err != nil {
+		logger.Error(err)
+		http.Error(res, "", http.StatusInternalServerError)
+		return false
+	}
+	idleExp, err := utils.IdleExpiration()
+	if err != nil {
+		logger.Error(err)
+		http.Error(res, "", http.StatusInternalServerError)
+		return false
+	}
+
+	// save refresh token in a new session
+	err = s.CreateSession(models.Session{
+		ID:            sessionID,
+		UserID:        guid,
+		RT:            rToken,
+		UserAgent:     req.UserAgent(),
+		ClientIP:      clientIP,
+		ExpiresAt:     rtExp,
+		IdleExpiresAt: idleExp,
+	})
+	if err != nil {
+		if writeUserError(res, err) {
+			return false
+		}
+		logger.Error(err)
+		http.Error(res, "", http.StatusInternalServerError)
+		return false
+	}
+
+	setTokenCookies(res, aToken, rToken, atExp, rtExp)
+	return true
+}
+
+// writeUserError reports the reason a user can not get tokens, it returns false for other errors
+func writeUserError(res http.ResponseWriter, err error) bool {
+	var attemptErr *service.AttemptError
+	switch {
+	case errors.As(err, &attemptErr):
+		logger.Error(err)
+		res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(attemptErr.RetryAfter.Seconds()))))
+		if errors.Is(err, service.ErrTemporarilyLocked) {
+			writeJSON(res, http.StatusLocked, oauthError{Error: "account_locked"})
+		} else {
+			writeJSON(res, http.StatusTooManyRequests, oauthError{Error: "too_many_attempts"})
+		}
+	case errors.Is(err, database.ErrUserNotFound), errors.Is(err, sql.ErrNoRows):
+		logger.Error(err)
+		writeJSON(res, http.StatusUnauthorized, oauthError{Error: "user_not_found"})
+	case errors.Is(err, service.ErrUserDisabled):
+		logger.Error(err)
+		writeJSON(res, http.StatusForbidden, oauthError{Error: "user_disabled"})
+	case errors.Is(err, service.ErrUserLocked):
+		logger.Error(err)
+		writeJSON(res, http.StatusLocked, oauthError{Error: "user_locked"})
+	case errors.Is(err, service.ErrEmailNotVerified):
+		logger.Error(err)
+		writeJSON(res, http.StatusForbidden, oauthError{Error: "email_not_verified"})
+	default:
+		return false
+	}
+	return true
+}
+
+func setTokenCookies(res http.ResponseWriter, aToken, rToken string, atExp, rtExp time.Time) {
+	rtB64 := base64.StdEncoding.EncodeToString([]byte(rToken))
+	http.SetCookie(res, &http.Cookie{
+		Name:     "at",
+		Value:    aToken,
+		Expires:  atExp,
+		HttpOnly: true,
+		// cross-site posts, like a forged consent, are sent without the session
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(res, &http.Cookie{
+		Name:     "rt",
+		Value:    rtB64,
+		Expires:  rtExp,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
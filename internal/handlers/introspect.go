@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/service"
+	logger "github.com/sirupsen/logrus"
+)
+
+const ClientAssertionJWT = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// Introspect implements RFC 7662 token introspection for resource servers.
+func Introspect(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logger.Info("introspecting token")
+		client, err := s.AuthenticateClient(clientCredentials(req))
+		if err == nil && client.Type == models.ClientPublic {
+			err = fmt.Errorf("%w: public client %s can not introspect tokens", service.ErrInvalidClient, client.ID)
+		}
+		if err != nil {
+			if errors.Is(err, service.ErrInvalidClient) {
+				logger.Error(err)
+				res.Header().Set("WWW-Authenticate", `Basic realm="tt-auth"`)
+				writeJSON(res, http.StatusUnauthorized, oauthError{Error: "invalid_client"})
+				return
+			}
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+
+		token := req.PostFormValue("token")
+		if token == "" {
+			writeJSON(res, http.StatusBadRequest, oauthError{Error: "invalid_request", ErrorDescription: "token required"})
+			return
+		}
+		introspection, err := s.Introspect(decodeCookieToken(token), req.PostFormValue("token_type_hint"))
+		if err != nil {
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(res, http.StatusOK, introspection)
+		logger.Info("token has been introspected by " + client.ID)
+	}
+}
+
+// clientCredentials supports client_secret_basic, client_secret_post, private_key_jwt and none,
+// a request using more than one method gets no method
+func clientCredentials(req *http.Request) models.ClientCredentials {
+	credentials := models.ClientCredentials{
+		ID:        req.PostFormValue("client_id"),
+		Secret:    req.PostFormValue("client_secret"),
+		Assertion: req.PostFormValue("client_assertion"),
+		Endpoint:  req.URL.Path,
+	}
+	id, secret, basic := req.BasicAuth()
+	switch {
+	case basic && credentials.Secret == "" && credentials.Assertion == "":
+		// RFC 6749 requires the credentials to be form encoded before base64
+		if decodedID, err := url.QueryUnescape(id); err == nil {
+			id = decodedID
+		}
+		if decodedSecret, err := url.QueryUnescape(secret); err == nil {
+			secret = decodedSecret
+		}
+		credentials.ID, credentials.Secret = id, secret
+		credentials.Method = models.AuthClientSecretBasic
+	case basic:
+	case credentials.Assertion != "" && credentials.Secret == "":
+		if req.PostFormValue("client_assertion_type") == ClientAssertionJWT {
+			credentials.Method = models.AuthPrivateKeyJWT
+		}
+	case credentials.Secret != "":
+		credentials.Method = models.AuthClientSecretPost
+	default:
+		credentials.Method = models.AuthNone
+	}
+	return credentials
+}
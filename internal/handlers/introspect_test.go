@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func clientSecret(id, secret string) interface{} {
+	return mock.MatchedBy(func(credentials models.ClientCredentials) bool {
+		return credentials.ID == id && credentials.Secret == secret
+	})
+}
+
+func TestIntrospect(t *testing.T) {
+	tests := []struct {
+		id             int
+		basicAuth      bool
+		form           url.Values
+		wantStatusCode int
+		wantActive     bool
+	}{
+		{
+			id:             1,
+			basicAuth:      true,
+			form:           url.Values{"token": {"active"}},
+			wantStatusCode: 200,
+			wantActive:     true,
+		},
+		{
+			id:             2,
+			form:           url.Values{"token": {"revoked"}, "client_id": {"rs"}, "client_secret": {"secret"}},
+			wantStatusCode: 200,
+			wantActive:     false,
+		},
+		{
+			id:             3,
+			form:           url.Values{"token": {"active"}, "client_id": {"rs"}, "client_secret": {"wrong"}},
+			wantStatusCode: 401,
+		},
+		{
+			id:             4,
+			basicAuth:      true,
+			form:           url.Values{},
+			wantStatusCode: 400,
+		},
+		{
+			id:             5,
+			form:           url.Values{"token": {"active"}, "client_id": {"spa"}},
+			wantStatusCode: 401,
+		},
+	}
+	serviceMock := new(MockService)
+	serviceMock.On("AuthenticateClient", clientSecret("rs", "secret")).Return(models.Client{ID: "rs"}, nil)
+	serviceMock.On("AuthenticateClient", clientSecret("rs", "wrong")).Return(models.Client{}, service.ErrInvalidClient)
+	serviceMock.On("AuthenticateClient", clientSecret("spa", "")).Return(models.Client{ID: "spa",
+		Type: models.ClientPublic, AuthMethod: models.AuthNone}, nil)
+	serviceMock.On("Introspect", "active", "").Return(models.Introspection{Active: true, Sub: "guid", SessionID: "session"}, nil)
+	serviceMock.On("Introspect", "revoked", "").Return(models.Introspection{}, nil)
+
+	for _, test := range tests {
+		fmt.Printf("Тест id: %v\n", test.id)
+		req := httptest.NewRequest("POST", "/introspect", strings.NewReader(test.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if test.basicAuth {
+			req.SetBasicAuth("rs", "secret")
+		}
+		resReqorder := httptest.NewRecorder()
+		handler := http.HandlerFunc(Introspect(serviceMock))
+		handler.ServeHTTP(resReqorder, req)
+
+		require.Equal(t, test.wantStatusCode, resReqorder.Code, "статус код не соответствует ожидаемому")
+		if test.wantStatusCode == 200 {
+			var body map[string]interface{}
+			require.NoError(t, json.NewDecoder(resReqorder.Body).Decode(&body))
+			assert.Equal(t, test.wantActive, body["active"])
+			if !test.wantActive {
+				assert.Equal(t, 1, len(body), "неактивный токен раскрывает данные")
+			}
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/sater-151/tt-auth/internal/utils"
+	logger "github.com/sirupsen/logrus"
+)
+
+func JWKS() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		set, err := utils.JWKS()
+		if err != nil {
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+		res.Header().Set("Content-Type", "application/json")
+		res.Header().Set("Cache-Control", "public, max-age=300")
+		err = json.NewEncoder(res).Encode(set)
+		if err != nil {
+			logger.Error(err)
+		}
+	}
+}
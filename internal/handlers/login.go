@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/service"
+	"github.com/sater-151/tt-auth/internal/utils"
+	logger "github.com/sirupsen/logrus"
+)
+
+var ErrCredentialsRequired = errors.New("login and password required")
+
+// Login starts a session after checking the password, or asks for the second factor,
+// the login is either email or username
+func Login(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logger.Info("login")
+		login := req.PostFormValue("login")
+		if login == "" {
+			login = req.PostFormValue("email")
+		}
+		if login == "" {
+			login = req.PostFormValue("username")
+		}
+		password := req.PostFormValue("password")
+		if login == "" || password == "" {
+			logger.Error(ErrCredentialsRequired)
+			writeJSON(res, http.StatusBadRequest, oauthError{Error: "invalid_request",
+				ErrorDescription: ErrCredentialsRequired.Error()})
+			return
+		}
+
+		user, err := s.Login(login, password, utils.ClientIP(req))
+		if err != nil {
+			if errors.Is(err, service.ErrInvalidCredentials) {
+				logger.Error(err)
+				writeJSON(res, http.StatusUnauthorized, oauthError{Error: "invalid_credentials"})
+				return
+			}
+			if writeUserError(res, err) {
+				return
+			}
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+
+		if !startSession(res, req, s, user.ID, models.AMRPassword) {
+			return
+		}
+		logger.Info("user has logged in")
+	}
+}
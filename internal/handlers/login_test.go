@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogin(t *testing.T) {
+	tests := []struct {
+		id             int
+		form           url.Values
+		wantStatusCode int
+		wantError      string
+	}{
+		{
+			id:             1,
+			form:           url.Values{"login": {"user@example.com"}, "password": {"password"}},
+			wantStatusCode: 200,
+		},
+		{
+			id:             2,
+			form:           url.Values{"username": {"user"}, "password": {"password"}},
+			wantStatusCode: 200,
+		},
+		{
+			id:             3,
+			form:           url.Values{"login": {"user@example.com"}, "password": {"wrong"}},
+			wantStatusCode: 401,
+			wantError:      "invalid_credentials",
+		},
+		{
+			id:             4,
+			form:           url.Values{"login": {"user@example.com"}},
+			wantStatusCode: 400,
+			wantError:      "invalid_request",
+		},
+		{
+			id:             5,
+			form:           url.Values{"login": {"locked@example.com"}, "password": {"password"}},
+			wantStatusCode: 423,
+			wantError:      "user_locked",
+		},
+		{
+			id:             6,
+			form:           url.Values{"login": {"slow@example.com"}, "password": {"password"}},
+			wantStatusCode: 429,
+			wantError:      "too_many_attempts",
+		},
+		{
+			id:             7,
+			form:           url.Values{"login": {"blocked@example.com"}, "password": {"password"}},
+			wantStatusCode: 423,
+			wantError:      "account_locked",
+		},
+		{
+			id:             8,
+			form:           url.Values{"login": {"disabled@example.com"}, "password": {"password"}},
+			wantStatusCode: 403,
+			wantError:      "user_disabled",
+		},
+		{
+			id:             9,
+			form:           url.Values{"login": {"unverified@example.com"}, "password": {"password"}},
+			wantStatusCode: 403,
+			wantError:      "email_not_verified",
+		},
+		{
+			id:             10,
+			form:           url.Values{"login": {"deleted@example.com"}, "password": {"password"}},
+			wantStatusCode: 401,
+			wantError:      "user_not_found",
+		},
+	}
+	serviceMock := new(MockService)
+	serviceMock.On("Login", "user@example.com", "password", mock.Anything).Return(models.User{ID: "login"}, nil)
+	serviceMock.On("Login", "user", "password", mock.Anything).Return(models.User{ID: "login"}, nil)
+	serviceMock.On("Login", "user@example.com", "wrong", mock.Anything).Return(models.User{}, service.ErrInvalidCredentials)
+	serviceMock.On("Login", "locked@example.com", "password", mock.Anything).Return(models.User{}, service.ErrUserLocked)
+	serviceMock.On("Login", "slow@example.com", "password", mock.Anything).Return(models.User{},
+		&service.AttemptError{Err: service.ErrTooManyAttempts, RetryAfter: 1500 * time.Millisecond})
+	serviceMock.On("Login", "blocked@example.com", "password", mock.Anything).Return(models.User{},
+		&service.AttemptError{Err: service.ErrTemporarilyLocked, RetryAfter: 15 * time.Minute})
+	// the user may be disabled or deleted between the password check and the session
+	for _, guid := range []string{"disabled", "unverified", "deleted"} {
+		serviceMock.On("Login", guid+"@example.com", "password", mock.Anything).Return(models.User{ID: guid}, nil)
+	}
+	serviceMock.On("MFAChallenge", mock.Anything).Return("", nil)
+	serviceMock.On("CreateSession", sessionOf("login")).Return(nil)
+	serviceMock.On("CreateSession", sessionOf("disabled")).Return(service.ErrUserDisabled)
+	serviceMock.On("CreateSession", sessionOf("unverified")).Return(service.ErrEmailNotVerified)
+	serviceMock.On("CreateSession", sessionOf("deleted")).Return(database.ErrUserNotFound)
+
+	for _, test := range tests {
+		fmt.Printf("Тест id: %v\n", test.id)
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(test.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resRecorder := httptest.NewRecorder()
+		handler := http.HandlerFunc(Login(serviceMock))
+		handler.ServeHTTP(resRecorder, req)
+
+		require.Equal(t, test.wantStatusCode, resRecorder.Code, "статус код не соответствует ожидаемому")
+		if test.wantStatusCode == 200 {
+			cookies := resRecorder.Result().Cookies()
+			require.Equal(t, 2, len(cookies))
+			assert.Equal(t, "at", cookies[0].Name, "название куки не соответствует")
+			assert.Equal(t, "rt", cookies[1].Name, "название куки не соответствует")
+			continue
+		}
+		var body oauthError
+		require.NoError(t, json.NewDecoder(resRecorder.Body).Decode(&body))
+		assert.Equal(t, test.wantError, body.Error, "код ошибки не соответствует ожидаемому")
+		switch test.wantError {
+		case "too_many_attempts":
+			assert.Equal(t, "2", resRecorder.Header().Get("Retry-After"), "заголовок Retry-After не соответствует")
+		case "account_locked":
+			assert.Equal(t, "900", resRecorder.Header().Get("Retry-After"), "заголовок Retry-After не соответствует")
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/service"
+	logger "github.com/sirupsen/logrus"
+)
+
+type oauthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+func Logout(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logger.Info("logout")
+		rtCookie, err := req.Cookie("rt")
+		if err == nil {
+			rt, err := base64.StdEncoding.DecodeString(rtCookie.Value)
+			if err == nil {
+				err = s.RevokeRT(string(rt))
+			}
+			if err != nil && !errors.Is(err, database.ErrUnauthorized) {
+				logger.Error(err)
+				http.Error(res, "", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		clearTokenCookies(res)
+		res.WriteHeader(http.StatusNoContent)
+		logger.Info("session has been closed")
+	}
+}
+
+func LogoutAll(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logger.Info("logout from all sessions")
+		guid := req.FormValue("guid")
+		if guid == "" {
+			logger.Error(ErrGUIDRequired)
+			http.Error(res, ErrGUIDRequired.Error(), http.StatusBadRequest)
+			return
+		}
+		rtCookie, err := req.Cookie("rt")
+		if err != nil {
+			logger.Error(err)
+			http.Error(res, "", http.StatusUnauthorized)
+			return
+		}
+		rt, err := base64.StdEncoding.DecodeString(rtCookie.Value)
+		if err != nil {
+			logger.Error(err)
+			http.Error(res, "", http.StatusUnauthorized)
+			return
+		}
+
+		// only the owner of a live session can close the others
+		_, err = s.CompareRT(string(rt), guid)
+		if err != nil {
+			if errors.Is(err, database.ErrUnauthorized) || errors.Is(err, service.ErrRTReused) ||
+				errors.Is(err, service.ErrRTExpired) {
+				logger.Error(err)
+				http.Error(res, "", http.StatusUnauthorized)
+				return
+			}
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+		err = s.RevokeAllSessions(guid)
+		if err != nil {
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+
+		clearTokenCookies(res)
+		res.WriteHeader(http.StatusNoContent)
+		logger.Info("all sessions have been closed")
+	}
+}
+
+// Revoke implements RFC 7009 token revocation for refresh tokens. The client must authenticate
+// like at the token endpoint and can revoke only the tokens issued to it.
+func Revoke(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logger.Info("revoking token")
+		client, err := s.AuthenticateClient(clientCredentials(req))
+		if err != nil {
+			if errors.Is(err, service.ErrInvalidClient) {
+				logger.Error(err)
+				res.Header().Set("WWW-Authenticate", `Basic realm="tt-auth"`)
+				writeJSON(res, http.StatusUnauthorized, oauthError{Error: "invalid_client"})
+				return
+			}
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+		token := req.PostFormValue("token")
+		if token == "" {
+			writeJSON(res, http.StatusBadRequest, oauthError{Error: "invalid_request", ErrorDescription: "token required"})
+			return
+		}
+		if req.PostFormValue("token_type_hint") == "access_token" {
+			writeJSON(res, http.StatusBadRequest, oauthError{Error: "unsupported_token_type"})
+			return
+		}
+
+		err = s.RevokeClientRT(client, decodeCookieToken(token))
+		if errors.Is(err, service.ErrTokenNotOwned) {
+			logger.Error(err)
+			writeJSON(res, http.StatusBadRequest, oauthError{Error: "unauthorized_client",
+				ErrorDescription: "token has been issued to another client"})
+			return
+		}
+		if err != nil && !errors.Is(err, database.ErrUnauthorized) {
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+		// invalid tokens are not reported to the client
+		res.WriteHeader(http.StatusOK)
+		logger.Info("token has been revoked")
+	}
+}
+
+// decodeCookieToken accepts a refresh token sent either as is or as the value of the rt cookie
+func decodeCookieToken(token string) string {
+	if decoded, err := base64.StdEncoding.DecodeString(token); err == nil {
+		return string(decoded)
+	}
+	return token
+}
+
+func clearTokenCookies(res http.ResponseWriter) {
+	http.SetCookie(res, &http.Cookie{
+		Name:     "at",
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	http.SetCookie(res, &http.Cookie{
+		Name:     "rt",
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
+
+func writeJSON(res http.ResponseWriter, status int, v interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("Cache-Control", "no-store")
+	res.WriteHeader(status)
+	err := json.NewEncoder(res).Encode(v)
+	if err != nil {
+		logger.Error(err)
+	}
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogout(t *testing.T) {
+	tests := []struct {
+		id             int
+		rToken         string
+		wantStatusCode int
+	}{
+		{
+			id:             1,
+			rToken:         "selector.verifier",
+			wantStatusCode: 204,
+		},
+		{
+			id:             2,
+			rToken:         "unknown.verifier",
+			wantStatusCode: 204,
+		},
+		{
+			id:             3,
+			rToken:         "",
+			wantStatusCode: 204,
+		},
+	}
+	serviceMock := new(MockService)
+	serviceMock.On("RevokeRT", "selector.verifier").Return(nil)
+	serviceMock.On("RevokeRT", "unknown.verifier").Return(database.ErrUnauthorized)
+
+	for _, test := range tests {
+		fmt.Printf("Тест id: %v\n", test.id)
+		req := httptest.NewRequest("POST", "/logout", nil)
+		if test.rToken != "" {
+			req.AddCookie(&http.Cookie{
+				Name:  "rt",
+				Value: base64.StdEncoding.EncodeToString([]byte(test.rToken)),
+			})
+		}
+		resReqorder := httptest.NewRecorder()
+		handler := http.HandlerFunc(Logout(serviceMock))
+		handler.ServeHTTP(resReqorder, req)
+
+		require.Equal(t, test.wantStatusCode, resReqorder.Code, "статус код не соответствует ожидаемому")
+		cook := resReqorder.Result().Cookies()
+		require.Equal(t, 2, len(cook))
+		for _, c := range cook {
+			assert.Empty(t, c.Value, "куки не очищена")
+			assert.True(t, c.MaxAge < 0, "куки не удалена")
+		}
+	}
+}
+
+func TestLogoutAll(t *testing.T) {
+	tests := []struct {
+		id             int
+		guid           string
+		rToken         string
+		wantStatusCode int
+	}{
+		{
+			id:             1,
+			guid:           "true",
+			rToken:         "selector.verifier",
+			wantStatusCode: 204,
+		},
+		{
+			id:             2,
+			guid:           "",
+			rToken:         "selector.verifier",
+			wantStatusCode: 400,
+		},
+		{
+			id:             3,
+			guid:           "true",
+			rToken:         "",
+			wantStatusCode: 401,
+		},
+		{
+			id:             4,
+			guid:           "false",
+			rToken:         "selector.verifier",
+			wantStatusCode: 401,
+		},
+	}
+	serviceMock := new(MockService)
+	serviceMock.On("CompareRT", "selector.verifier", "true").Return(models.Session{ID: "session", UserID: "true"}, nil)
+	serviceMock.On("CompareRT", "selector.verifier", "false").Return(models.Session{}, database.ErrUnauthorized)
+	serviceMock.On("RevokeAllSessions", "true").Return(nil)
+
+	for _, test := range tests {
+		fmt.Printf("Тест id: %v\n", test.id)
+		req := httptest.NewRequest("POST", fmt.Sprintf("/logout/all?guid=%s", test.guid), nil)
+		if test.rToken != "" {
+			req.AddCookie(&http.Cookie{
+				Name:  "rt",
+				Value: base64.StdEncoding.EncodeToString([]byte(test.rToken)),
+			})
+		}
+		resReqorder := httptest.NewRecorder()
+		handler := http.HandlerFunc(LogoutAll(serviceMock))
+		handler.ServeHTTP(resReqorder, req)
+
+		require.Equal(t, test.wantStatusCode, resReqorder.Code, "статус код не соответствует ожидаемому")
+	}
+	serviceMock.AssertNumberOfCalls(t, "RevokeAllSessions", 1)
+}
+
+func TestRevoke(t *testing.T) {
+	tests := []struct {
+		id             int
+		secret         string
+		form           url.Values
+		wantStatusCode int
+	}{
+		{
+			id:             1,
+			secret:         "secret",
+			form:           url.Values{"token": {"selector.verifier"}},
+			wantStatusCode: 200,
+		},
+		{
+			id:             2,
+			secret:         "secret",
+			form:           url.Values{"token": {base64.StdEncoding.EncodeToString([]byte("selector.verifier"))}},
+			wantStatusCode: 200,
+		},
+		{
+			id:             3,
+			secret:         "secret",
+			form:           url.Values{"token": {"unknown.verifier"}, "token_type_hint": {"refresh_token"}},
+			wantStatusCode: 200,
+		},
+		{
+			id:             4,
+			secret:         "secret",
+			form:           url.Values{},
+			wantStatusCode: 400,
+		},
+		{
+			id:             5,
+			secret:         "secret",
+			form:           url.Values{"token": {"access"}, "token_type_hint": {"access_token"}},
+			wantStatusCode: 400,
+		},
+		{
+			id:             6,
+			secret:         "wrong",
+			form:           url.Values{"token": {"selector.verifier"}},
+			wantStatusCode: 401,
+		},
+		{
+			id:             7,
+			secret:         "secret",
+			form:           url.Values{"token": {"other.verifier"}},
+			wantStatusCode: 400,
+		},
+	}
+	client := models.Client{ID: "app"}
+	serviceMock := new(MockService)
+	serviceMock.On("AuthenticateClient", clientSecret("app", "secret")).Return(client, nil)
+	serviceMock.On("AuthenticateClient", clientSecret("app", "wrong")).Return(models.Client{}, service.ErrInvalidClient)
+	serviceMock.On("RevokeClientRT", client, "selector.verifier").Return(nil)
+	serviceMock.On("RevokeClientRT", client, "unknown.verifier").Return(database.ErrUnauthorized)
+	serviceMock.On("RevokeClientRT", client, "other.verifier").Return(service.ErrTokenNotOwned)
+
+	for _, test := range tests {
+		fmt.Printf("Тест id: %v\n", test.id)
+		req := httptest.NewRequest("POST", "/revoke", strings.NewReader(test.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("app", test.secret)
+		resReqorder := httptest.NewRecorder()
+		handler := http.HandlerFunc(Revoke(serviceMock))
+		handler.ServeHTTP(resReqorder, req)
+
+		require.Equal(t, test.wantStatusCode, resReqorder.Code, "статус код не соответствует ожидаемому")
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/service"
+	"github.com/sater-151/tt-auth/internal/utils"
+	logger "github.com/sirupsen/logrus"
+)
+
+const magicLinkCookie = "magic"
+
+// MagicLink always answers 202 and sets the nonce cookie, whether the email is known or not
+func MagicLink(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logger.Info("magic link requested")
+		email := req.PostFormValue("email")
+		if email == "" {
+			writeJSON(res, http.StatusBadRequest, oauthError{Error: "invalid_request", ErrorDescription: "email required"})
+			return
+		}
+		nonce, err := s.MagicLink(email)
+		if err != nil {
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+
+		// lax, because the callback is opened from a link in the email
+		http.SetCookie(res, &http.Cookie{
+			Name:     magicLinkCookie,
+			Value:    nonce,
+			Path:     "/login/magic",
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		res.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func MagicLinkCallback(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logger.Info("magic link login")
+		token := req.FormValue("token")
+		if token == "" {
+			writeJSON(res, http.StatusBadRequest, oauthError{Error: "invalid_request", ErrorDescription: "token required"})
+			return
+		}
+		nonceCookie, err := req.Cookie(magicLinkCookie)
+		if err != nil {
+			logger.Error(err)
+			writeJSON(res, http.StatusUnauthorized, oauthError{Error: "invalid_token",
+				ErrorDescription: "the link has to be opened in the browser where it was requested"})
+			return
+		}
+		guid, err := s.MagicLinkLogin(token, nonceCookie.Value, utils.ClientIP(req))
+		if err != nil {
+			if writeUserError(res, err) {
+				return
+			}
+			if errors.Is(err, database.ErrTokenInvalid) {
+				logger.Error(err)
+				writeJSON(res, http.StatusUnauthorized, oauthError{Error: "invalid_token"})
+				return
+			}
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+
+		http.SetCookie(res, &http.Cookie{
+			Name:     magicLinkCookie,
+			Value:    "",
+			Path:     "/login/magic",
+			MaxAge:   -1,
+			HttpOnly: true,
+		})
+		if !startSession(res, req, s, guid, models.AMREmail) {
+			return
+		}
+		logger.Info("user has logged in")
+	}
+}
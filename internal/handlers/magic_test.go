@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMagicLink(t *testing.T) {
+	serviceMock := new(MockService)
+	serviceMock.On("MagicLink", "user@example.com").Return("nonce", nil)
+
+	req := httptest.NewRequest("POST", "/login/magic", strings.NewReader("email=user%40example.com"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resRecorder := httptest.NewRecorder()
+	http.HandlerFunc(MagicLink(serviceMock)).ServeHTTP(resRecorder, req)
+
+	require.Equal(t, 202, resRecorder.Code, "статус код не соответствует ожидаемому")
+	cookies := resRecorder.Result().Cookies()
+	require.Equal(t, 1, len(cookies))
+	assert.Equal(t, magicLinkCookie, cookies[0].Name, "название куки не соответствует")
+	assert.Equal(t, "nonce", cookies[0].Value, "nonce не соответствует")
+	assert.True(t, cookies[0].HttpOnly, "кука доступна скриптам")
+}
+
+func TestMagicLinkCallback(t *testing.T) {
+	tests := []struct {
+		id             int
+		token          string
+		nonce          string
+		wantStatusCode int
+	}{
+		{
+			id:             1,
+			token:          "link",
+			nonce:          "nonce",
+			wantStatusCode: 200,
+		},
+		{
+			id:             2,
+			token:          "link",
+			nonce:          "other browser",
+			wantStatusCode: 401,
+		},
+		{
+			id:             3,
+			token:          "link",
+			wantStatusCode: 401,
+		},
+		{
+			id:             4,
+			nonce:          "nonce",
+			wantStatusCode: 400,
+		},
+		{
+			id:             5,
+			token:          "locked",
+			nonce:          "nonce",
+			wantStatusCode: 423,
+		},
+	}
+	serviceMock := new(MockService)
+	serviceMock.On("MagicLinkLogin", "link", "nonce", mock.Anything).Return("magic", nil)
+	serviceMock.On("MagicLinkLogin", "link", "other browser", mock.Anything).Return("", database.ErrTokenInvalid)
+	serviceMock.On("MagicLinkLogin", "locked", "nonce", mock.Anything).Return("",
+		&service.AttemptError{Err: service.ErrTemporarilyLocked, RetryAfter: 15 * time.Minute})
+	serviceMock.On("MFAChallenge", "magic").Return("", nil)
+	serviceMock.On("CreateSession", sessionOf("magic")).Return(nil)
+
+	for _, test := range tests {
+		fmt.Printf("Тест id: %v\n", test.id)
+		req := httptest.NewRequest("GET", "/login/magic/callback?token="+test.token, nil)
+		if test.nonce != "" {
+			req.AddCookie(&http.Cookie{Name: magicLinkCookie, Value: test.nonce})
+		}
+		resRecorder := httptest.NewRecorder()
+		http.HandlerFunc(MagicLinkCallback(serviceMock)).ServeHTTP(resRecorder, req)
+
+		require.Equal(t, test.wantStatusCode, resRecorder.Code, "статус код не соответствует ожидаемому")
+		if test.wantStatusCode == 200 {
+			names := []string{}
+			for _, cookie := range resRecorder.Result().Cookies() {
+				names = append(names, cookie.Name)
+			}
+			assert.Equal(t, []string{magicLinkCookie, "at", "rt"}, names, "куки не соответствуют")
+		}
+	}
+}
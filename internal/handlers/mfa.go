@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/service"
+	"github.com/sater-151/tt-auth/internal/utils"
+	logger "github.com/sirupsen/logrus"
+)
+
+var ErrAccessTokenRequired = errors.New("access token required")
+var ErrReauthRequired = errors.New("recent authentication required")
+
+type mfaChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+type recoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func EnrollTOTP(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logger.Info("enrolling totp")
+		guid, err := recentUser(req)
+		if err != nil {
+			writeRecentUserError(res, err)
+			return
+		}
+		enrollment, err := s.EnrollTOTP(guid)
+		if err != nil {
+			if errors.Is(err, database.ErrMFAAlreadyEnrolled) {
+				logger.Error(err)
+				writeJSON(res, http.StatusConflict, oauthError{Error: "mfa_already_enrolled"})
+				return
+			}
+			if writeUserError(res, err) {
+				return
+			}
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(res, http.StatusOK, enrollment)
+		logger.Info("totp secret has been sent")
+	}
+}
+
+func ConfirmTOTP(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logger.Info("confirming totp")
+		guid, err := recentUser(req)
+		if err != nil {
+			writeRecentUserError(res, err)
+			return
+		}
+		codes, err := s.ConfirmTOTP(guid, req.PostFormValue("code"))
+		if err != nil {
+			switch {
+			case errors.Is(err, service.ErrInvalidMFACode):
+				logger.Error(err)
+				writeJSON(res, http.StatusBadRequest, oauthError{Error: "invalid_code"})
+			case errors.Is(err, database.ErrMFANotEnrolled):
+				logger.Error(err)
+				writeJSON(res, http.StatusBadRequest, oauthError{Error: "mfa_not_enrolled"})
+			case errors.Is(err, database.ErrMFAAlreadyEnrolled):
+				logger.Error(err)
+				writeJSON(res, http.StatusConflict, oauthError{Error: "mfa_already_enrolled"})
+			default:
+				logger.Error(err)
+				http.Error(res, "", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		writeJSON(res, http.StatusOK, recoveryCodes{RecoveryCodes: codes})
+		logger.Info("totp has been enabled")
+	}
+}
+
+// VerifyMFA exchanges the challenge returned by login and a TOTP or recovery code for tokens
+func VerifyMFA(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logger.Info("verifying second factor")
+		challenge := req.PostFormValue("mfa_token")
+		code := req.PostFormValue("code")
+		if challenge == "" || code == "" {
+			writeJSON(res, http.StatusBadRequest, oauthError{Error: "invalid_request",
+				ErrorDescription: "mfa_token and code required"})
+			return
+		}
+		guid, err := s.VerifyMFA(challenge, code, utils.ClientIP(req))
+		if err != nil {
+			if errors.Is(err, database.ErrTokenInvalid) {
+				logger.Error(err)
+				writeJSON(res, http.StatusUnauthorized, oauthError{Error: "invalid_token"})
+				return
+			}
+			if errors.Is(err, service.ErrInvalidMFACode) {
+				logger.Error(err)
+				writeJSON(res, http.StatusUnauthorized, oauthError{Error: "invalid_code"})
+				return
+			}
+			if writeUserError(res, err) {
+				return
+			}
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+
+		if !issueTokens(res, req, s, guid, models.AMRMFA) {
+			return
+		}
+		logger.Info("tokens have been sent")
+	}
+}
+
+// startSession asks for the second factor when the user has enrolled one and issues tokens otherwise
+func startSession(res http.ResponseWriter, req *http.Request, s service.ServiceInterface, guid string,
+	amr ...string) bool {
+	challenge, err := s.MFAChallenge(guid)
+	if err != nil {
+		if writeUserError(res, err) {
+			return false
+		}
+		logger.Error(err)
+		http.Error(res, "", http.StatusInternalServerError)
+		return false
+	}
+	if challenge != "" {
+		writeJSON(res, http.StatusOK, mfaChallenge{MFARequired: true, MFAToken: challenge})
+		logger.Info("second factor required")
+		return true
+	}
+	return issueTokens(res, req, s, guid, amr...)
+}
+
+// recentUser takes the user from the at cookie of a first-party session that has just been started
+// with a password, a second factor or a passkey. Tokens issued to clients and refreshed ones are refused.
+func recentUser(req *http.Request) (string, error) {
+	atCookie, err := req.Cookie("at")
+	if err != nil {
+		return "", ErrAccessTokenRequired
+	}
+	claims, err := utils.ValidateAccessToken(atCookie.Value)
+	if err != nil {
+		return "", err
+	}
+	if claims.ClientID != "" {
+		return "", fmt.Errorf("%w: token has been issued to client %s", ErrAccessTokenRequired, claims.ClientID)
+	}
+	maxAge, err := utils.ReauthMaxAge()
+	if err != nil {
+		return "", err
+	}
+	if claims.AuthTime == nil || time.Since(claims.AuthTime.Time) > maxAge {
+		return "", ErrReauthRequired
+	}
+	if !slices.ContainsFunc(claims.AMR, func(method string) bool {
+		return method == models.AMRPassword || method == models.AMRMFA || method == models.AMROTP ||
+			method == models.AMRPasskey
+	}) {
+		return "", ErrReauthRequired
+	}
+	return claims.Subject, nil
+}
+
+// writeRecentUserError asks to log in again when the session is too old for the request
+func writeRecentUserError(res http.ResponseWriter, err error) {
+	logger.Error(err)
+	if errors.Is(err, ErrReauthRequired) {
+		writeJSON(res, http.StatusUnauthorized, oauthError{Error: "login_required",
+			ErrorDescription: "log in again with a password or a passkey"})
+		return
+	}
+	writeJSON(res, http.StatusUnauthorized, oauthError{Error: "invalid_token"})
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/service"
+	"github.com/sater-151/tt-auth/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnrollTOTP(t *testing.T) {
+	aToken, _, err := utils.GenerateTokens("mfa", "session", "192.0.2.1", models.AMRPassword)
+	require.NoError(t, err)
+	enrolled, _, err := utils.GenerateTokens("enrolled", "session", "192.0.2.1", models.AMRPassword)
+	require.NoError(t, err)
+	refreshed, _, err := utils.GenerateTokens("mfa", "session", "192.0.2.1")
+	require.NoError(t, err)
+
+	tests := []struct {
+		id             int
+		cookie         string
+		authorization  string
+		wantStatusCode int
+	}{
+		{
+			id:             1,
+			cookie:         aToken,
+			wantStatusCode: 200,
+		},
+		{
+			id:             2,
+			cookie:         enrolled,
+			wantStatusCode: 409,
+		},
+		{
+			id:             3,
+			cookie:         "broken",
+			wantStatusCode: 401,
+		},
+		{
+			id:             4,
+			wantStatusCode: 401,
+		},
+		{
+			id:             5,
+			authorization:  "Bearer " + aToken,
+			wantStatusCode: 401,
+		},
+		{
+			id:             6,
+			cookie:         refreshed,
+			wantStatusCode: 401,
+		},
+	}
+	serviceMock := new(MockService)
+	serviceMock.On("EnrollTOTP", "mfa").Return(models.TOTPEnrollment{Secret: "JBSWY3DPEHPK3PXP",
+		URI: "otpauth://totp/tt-auth:user?secret=JBSWY3DPEHPK3PXP"}, nil)
+	serviceMock.On("EnrollTOTP", "enrolled").Return(models.TOTPEnrollment{}, database.ErrMFAAlreadyEnrolled)
+
+	for _, test := range tests {
+		fmt.Printf("Тест id: %v\n", test.id)
+		req := httptest.NewRequest("POST", "/mfa/totp/enroll", nil)
+		if test.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "at", Value: test.cookie})
+		}
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+		resRecorder := httptest.NewRecorder()
+		handler := http.HandlerFunc(EnrollTOTP(serviceMock))
+		handler.ServeHTTP(resRecorder, req)
+
+		require.Equal(t, test.wantStatusCode, resRecorder.Code, "статус код не соответствует ожидаемому")
+		if test.wantStatusCode == 200 {
+			var body models.TOTPEnrollment
+			require.NoError(t, json.NewDecoder(resRecorder.Body).Decode(&body))
+			assert.Equal(t, "JBSWY3DPEHPK3PXP", body.Secret, "секрет не соответствует ожидаемому")
+			assert.True(t, strings.HasPrefix(body.URI, "otpauth://totp/"), "неверный otpauth uri")
+		}
+	}
+}
+
+func TestConfirmTOTP(t *testing.T) {
+	aToken, _, err := utils.GenerateTokens("mfa", "session", "192.0.2.1", models.AMRPassword)
+	require.NoError(t, err)
+
+	tests := []struct {
+		id             int
+		code           string
+		wantStatusCode int
+	}{
+		{
+			id:             1,
+			code:           "123456",
+			wantStatusCode: 200,
+		},
+		{
+			id:             2,
+			code:           "000000",
+			wantStatusCode: 400,
+		},
+	}
+	serviceMock := new(MockService)
+	serviceMock.On("ConfirmTOTP", "mfa", "123456").Return([]string{"abcde-fghjk"}, nil)
+	serviceMock.On("ConfirmTOTP", "mfa", "000000").Return(nil, service.ErrInvalidMFACode)
+
+	for _, test := range tests {
+		fmt.Printf("Тест id: %v\n", test.id)
+		form := url.Values{"code": {test.code}}
+		req := httptest.NewRequest("POST", "/mfa/totp/confirm", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "at", Value: aToken})
+		resRecorder := httptest.NewRecorder()
+		handler := http.HandlerFunc(ConfirmTOTP(serviceMock))
+		handler.ServeHTTP(resRecorder, req)
+
+		require.Equal(t, test.wantStatusCode, resRecorder.Code, "статус код не соответствует ожидаемому")
+		if test.wantStatusCode == 200 {
+			var body recoveryCodes
+			require.NoError(t, json.NewDecoder(resRecorder.Body).Decode(&body))
+			assert.Equal(t, []string{"abcde-fghjk"}, body.RecoveryCodes, "коды восстановления не соответствуют")
+		}
+	}
+}
+
+func TestLoginWithMFA(t *testing.T) {
+	serviceMock := new(MockService)
+	serviceMock.On("Login", "user@example.com", "password", mock.Anything).Return(models.User{ID: "mfa"}, nil)
+	serviceMock.On("MFAChallenge", "mfa").Return("challenge", nil)
+
+	form := url.Values{"login": {"user@example.com"}, "password": {"password"}}
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resRecorder := httptest.NewRecorder()
+	handler := http.HandlerFunc(Login(serviceMock))
+	handler.ServeHTTP(resRecorder, req)
+
+	require.Equal(t, 200, resRecorder.Code, "статус код не соответствует ожидаемому")
+	assert.Empty(t, resRecorder.Result().Cookies(), "токены выданы до проверки второго фактора")
+	var body mfaChallenge
+	require.NoError(t, json.NewDecoder(resRecorder.Body).Decode(&body))
+	assert.True(t, body.MFARequired, "не запрошен второй фактор")
+	assert.Equal(t, "challenge", body.MFAToken, "токен проверки не соответствует")
+}
+
+func TestVerifyMFA(t *testing.T) {
+	tests := []struct {
+		id             int
+		form           url.Values
+		wantStatusCode int
+	}{
+		{
+			id:             1,
+			form:           url.Values{"mfa_token": {"challenge"}, "code": {"123456"}},
+			wantStatusCode: 200,
+		},
+		{
+			id:             2,
+			form:           url.Values{"mfa_token": {"challenge"}, "code": {"000000"}},
+			wantStatusCode: 401,
+		},
+		{
+			id:             3,
+			form:           url.Values{"mfa_token": {"expired"}, "code": {"123456"}},
+			wantStatusCode: 401,
+		},
+		{
+			id:             4,
+			form:           url.Values{"mfa_token": {"challenge"}},
+			wantStatusCode: 400,
+		},
+		{
+			id:             5,
+			form:           url.Values{"mfa_token": {"challenge"}, "code": {"111111"}},
+			wantStatusCode: 429,
+		},
+	}
+	serviceMock := new(MockService)
+	serviceMock.On("VerifyMFA", "challenge", "123456", mock.Anything).Return("mfa", nil)
+	serviceMock.On("VerifyMFA", "challenge", "000000", mock.Anything).Return("", service.ErrInvalidMFACode)
+	serviceMock.On("VerifyMFA", "expired", "123456", mock.Anything).Return("", database.ErrTokenInvalid)
+	serviceMock.On("VerifyMFA", "challenge", "111111", mock.Anything).Return("",
+		&service.AttemptError{Err: service.ErrTooManyAttempts, RetryAfter: time.Second})
+	serviceMock.On("CreateSession", sessionOf("mfa")).Return(nil)
+
+	for _, test := range tests {
+		fmt.Printf("Тест id: %v\n", test.id)
+		req := httptest.NewRequest("POST", "/mfa/verify", strings.NewReader(test.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resRecorder := httptest.NewRecorder()
+		handler := http.HandlerFunc(VerifyMFA(serviceMock))
+		handler.ServeHTTP(resRecorder, req)
+
+		require.Equal(t, test.wantStatusCode, resRecorder.Code, "статус код не соответствует ожидаемому")
+		if test.wantStatusCode == 200 {
+			require.Equal(t, 2, len(resRecorder.Result().Cookies()))
+		}
+	}
+}
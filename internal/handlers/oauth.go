@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/service"
+	"github.com/sater-151/tt-auth/internal/utils"
+	logger "github.com/sirupsen/logrus"
+)
+
+// Token is the OAuth 2.0 token endpoint, RFC 6749 section 3.2
+func Token(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logger.Info("oauth token request")
+		client, err := s.AuthenticateClient(clientCredentials(req))
+		if err != nil {
+			if errors.Is(err, service.ErrInvalidClient) {
+				logger.Error(err)
+				res.Header().Set("WWW-Authenticate", `Basic realm="tt-auth"`)
+				writeJSON(res, http.StatusUnauthorized, oauthError{Error: "invalid_client"})
+				return
+			}
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+
+		tokens, err := s.Token(client, models.TokenRequest{
+			GrantType:    req.PostFormValue("grant_type"),
+			Code:         req.PostFormValue("code"),
+			RedirectURI:  req.PostFormValue("redirect_uri"),
+			RefreshToken: req.PostFormValue("refresh_token"),
+			CodeVerifier: req.PostFormValue("code_verifier"),
+			Scope:        req.PostFormValue("scope"),
+			ClientIP:     utils.ClientIP(req),
+			UserAgent:    req.UserAgent(),
+		})
+		if err != nil {
+			if writeTokenError(res, err) {
+				return
+			}
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+
+		res.Header().Set("Pragma", "no-cache")
+		writeJSON(res, http.StatusOK, tokens)
+		logger.Info("tokens have been issued to " + client.ID)
+	}
+}
+
+// consentPrompt asks the frontend to show the client and the scope to the user,
+// the consent is sent to /oauth/consent and the authorization request repeated
+type consentPrompt struct {
+	ConsentRequired bool   `json:"consent_required"`
+	ClientID        string `json:"client_id"`
+	ClientName      string `json:"client_name,omitempty"`
+	Scope           string `json:"scope,omitempty"`
+}
+
+// Authorize is the authorization endpoint of the code flow, RFC 6749 section 4.1. The user must be
+// logged in with the at cookie, the code is sent to the registered redirect URI of the client.
+// Clients that are not first-party get codes only after the user has consented.
+func Authorize(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logger.Info("oauth authorization request")
+		query := req.URL.Query()
+		request := models.AuthorizationRequest{
+			ResponseType:        query.Get("response_type"),
+			ClientID:            query.Get("client_id"),
+			RedirectURI:         query.Get("redirect_uri"),
+			Scope:               query.Get("scope"),
+			State:               query.Get("state"),
+			CodeChallenge:       query.Get("code_challenge"),
+			CodeChallengeMethod: query.Get("code_challenge_method"),
+		}
+		request.RedirectURISent = request.RedirectURI != ""
+		client, redirectURI, err := s.AuthorizationClient(request.ClientID, request.RedirectURI)
+		if err != nil {
+			// without a trusted redirect URI the error can only be shown to the user
+			if errors.Is(err, service.ErrInvalidClient) || errors.Is(err, service.ErrInvalidRedirectURI) {
+				logger.Error(err)
+				writeJSON(res, http.StatusBadRequest, oauthError{Error: "invalid_request", ErrorDescription: err.Error()})
+				return
+			}
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+		request.RedirectURI = redirectURI
+
+		guid, err := cookieUser(req)
+		if err != nil {
+			logger.Error(err)
+			writeJSON(res, http.StatusUnauthorized, oauthError{Error: "login_required"})
+			return
+		}
+
+		code, err := s.Authorize(guid, client, request)
+		if errors.Is(err, service.ErrConsentRequired) && query.Get("prompt") != "none" {
+			logger.Info("consent required for " + client.ID)
+			scope := request.Scope
+			if scope == "" {
+				scope = client.Scope
+			}
+			writeJSON(res, http.StatusOK, consentPrompt{ConsentRequired: true, ClientID: client.ID,
+				ClientName: client.Name, Scope: scope})
+			return
+		}
+		if err != nil {
+			logger.Error(err)
+			redirectWithParams(res, req, redirectURI, url.Values{"error": {authorizeErrorCode(err)},
+				"state": {request.State}})
+			return
+		}
+		redirectWithParams(res, req, redirectURI, url.Values{"code": {code}, "state": {request.State}})
+		logger.Info("authorization code has been issued to " + client.ID)
+	}
+}
+
+// Consent records that the logged in user lets the client get codes for the scope
+func Consent(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logger.Info("oauth consent")
+		guid, err := cookieUser(req)
+		if err != nil {
+			logger.Error(err)
+			writeJSON(res, http.StatusUnauthorized, oauthError{Error: "login_required"})
+			return
+		}
+		err = s.GrantConsent(guid, req.PostFormValue("client_id"), req.PostFormValue("scope"))
+		if err != nil {
+			switch {
+			case errors.Is(err, service.ErrInvalidClient):
+				logger.Error(err)
+				writeJSON(res, http.StatusBadRequest, oauthError{Error: "invalid_request", ErrorDescription: err.Error()})
+			case errors.Is(err, service.ErrUnauthorizedClient):
+				logger.Error(err)
+				writeJSON(res, http.StatusBadRequest, oauthError{Error: "unauthorized_client"})
+			case errors.Is(err, service.ErrInvalidScope):
+				logger.Error(err)
+				writeJSON(res, http.StatusBadRequest, oauthError{Error: "invalid_scope"})
+			default:
+				if writeUserError(res, err) {
+					return
+				}
+				logger.Error(err)
+				http.Error(res, "", http.StatusInternalServerError)
+			}
+			return
+		}
+		res.WriteHeader(http.StatusNoContent)
+		logger.Info("consent has been granted")
+	}
+}
+
+func authorizeErrorCode(err error) string {
+	switch {
+	case errors.Is(err, service.ErrConsentRequired):
+		return "consent_required"
+	case errors.Is(err, service.ErrUnsupportedResponseType):
+		return "unsupported_response_type"
+	case errors.Is(err, service.ErrUnauthorizedClient):
+		return "unauthorized_client"
+	case errors.Is(err, service.ErrInvalidRequest):
+		return "invalid_request"
+	case errors.Is(err, service.ErrInvalidScope):
+		return "invalid_scope"
+	case errors.Is(err, database.ErrUserNotFound), errors.Is(err, service.ErrUserDisabled),
+		errors.Is(err, service.ErrUserLocked), errors.Is(err, service.ErrEmailNotVerified):
+		return "access_denied"
+	}
+	return "server_error"
+}
+
+// cookieUser accepts only the at cookie of a first-party session, so that a token issued
+// to one client can not be used to authorize another
+func cookieUser(req *http.Request) (string, error) {
+	atCookie, err := req.Cookie("at")
+	if err != nil {
+		return "", ErrAccessTokenRequired
+	}
+	claims, err := utils.ValidateAccessToken(atCookie.Value)
+	if err != nil {
+		return "", err
+	}
+	if claims.ClientID != "" {
+		return "", fmt.Errorf("%w: token has been issued to client %s", ErrAccessTokenRequired, claims.ClientID)
+	}
+	return claims.Subject, nil
+}
+
+// redirectWithParams adds the parameters to the query of the redirect URI, empty ones are left out
+func redirectWithParams(res http.ResponseWriter, req *http.Request, redirectURI string, params url.Values) {
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		logger.Error(err)
+		http.Error(res, "", http.StatusInternalServerError)
+		return
+	}
+	query := target.Query()
+	for key, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	target.RawQuery = query.Encode()
+	res.Header().Set("Cache-Control", "no-store")
+	http.Redirect(res, req, target.String(), http.StatusFound)
+}
+
+// writeTokenError writes the RFC 6749 error response, it returns false for other errors
+func writeTokenError(res http.ResponseWriter, err error) bool {
+	var code string
+	switch {
+	case errors.Is(err, service.ErrInvalidRequest):
+		code = "invalid_request"
+	case errors.Is(err, service.ErrInvalidGrant):
+		code = "invalid_grant"
+	case errors.Is(err, service.ErrUnauthorizedClient):
+		code = "unauthorized_client"
+	case errors.Is(err, service.ErrUnsupportedGrantType):
+		code = "unsupported_grant_type"
+	case errors.Is(err, service.ErrInvalidScope):
+		code = "invalid_scope"
+	default:
+		return writeUserError(res, err)
+	}
+	logger.Error(err)
+	writeJSON(res, http.StatusBadRequest, oauthError{Error: code, ErrorDescription: err.Error()})
+	return true
+}
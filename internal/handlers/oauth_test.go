@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/service"
+	"github.com/sater-151/tt-auth/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func grantType(grant string) interface{} {
+	return mock.MatchedBy(func(request models.TokenRequest) bool {
+		return request.GrantType == grant
+	})
+}
+
+func TestToken(t *testing.T) {
+	tests := []struct {
+		id             int
+		form           url.Values
+		wantStatusCode int
+		wantError      string
+	}{
+		{
+			id:             1,
+			form:           url.Values{"grant_type": {"client_credentials"}},
+			wantStatusCode: 200,
+		},
+		{
+			id:             2,
+			form:           url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"used"}},
+			wantStatusCode: 400,
+			wantError:      "invalid_grant",
+		},
+		{
+			id:             3,
+			form:           url.Values{"grant_type": {"password"}},
+			wantStatusCode: 400,
+			wantError:      "unsupported_grant_type",
+		},
+		{
+			id:             4,
+			form:           url.Values{"grant_type": {"authorization_code"}},
+			wantStatusCode: 400,
+			wantError:      "unauthorized_client",
+		},
+		{
+			id:             5,
+			form:           url.Values{"grant_type": {"client_credentials"}, "client_secret": {"wrong"}},
+			wantStatusCode: 401,
+			wantError:      "invalid_client",
+		},
+	}
+	serviceMock := new(MockService)
+	client := models.Client{ID: "app", GrantTypes: []string{"client_credentials", "refresh_token"}}
+	serviceMock.On("AuthenticateClient", clientSecret("app", "secret")).Return(client, nil)
+	serviceMock.On("AuthenticateClient", clientSecret("app", "wrong")).Return(models.Client{}, service.ErrInvalidClient)
+	serviceMock.On("Token", client, grantType("client_credentials")).Return(models.TokenResponse{
+		AccessToken: "access", TokenType: "Bearer", ExpiresIn: 60, Scope: "read"}, nil)
+	serviceMock.On("Token", client, grantType("refresh_token")).Return(models.TokenResponse{},
+		fmt.Errorf("%w: %w", service.ErrInvalidGrant, service.ErrRTReused))
+	serviceMock.On("Token", client, grantType("password")).Return(models.TokenResponse{}, service.ErrUnsupportedGrantType)
+	serviceMock.On("Token", client, grantType("authorization_code")).Return(models.TokenResponse{},
+		service.ErrUnauthorizedClient)
+
+	for _, test := range tests {
+		fmt.Printf("Тест id: %v\n", test.id)
+		form := url.Values{"client_id": {"app"}, "client_secret": {"secret"}}
+		for key, value := range test.form {
+			form[key] = value
+		}
+		req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resRecorder := httptest.NewRecorder()
+		handler := http.HandlerFunc(Token(serviceMock))
+		handler.ServeHTTP(resRecorder, req)
+
+		require.Equal(t, test.wantStatusCode, resRecorder.Code, "статус код не соответствует ожидаемому")
+		assert.Equal(t, "no-store", resRecorder.Header().Get("Cache-Control"), "ответ может быть закэширован")
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(resRecorder.Body).Decode(&body))
+		if test.wantStatusCode == 200 {
+			assert.Equal(t, "access", body["access_token"])
+			assert.Equal(t, "Bearer", body["token_type"])
+			assert.Equal(t, float64(60), body["expires_in"])
+			assert.NotContains(t, body, "refresh_token", "client_credentials не выдает refresh токен")
+			continue
+		}
+		assert.Equal(t, test.wantError, body["error"], "код ошибки не соответствует ожидаемому")
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	aToken, _, err := utils.GenerateTokens("user", "session", "192.0.2.1")
+	require.NoError(t, err)
+	tests := []struct {
+		id             int
+		query          string
+		loggedIn       bool
+		wantStatusCode int
+		wantParams     url.Values
+	}{
+		{
+			id:             1,
+			query:          "response_type=code&client_id=app&state=xyz&code_challenge=good&code_challenge_method=S256",
+			loggedIn:       true,
+			wantStatusCode: 302,
+			wantParams:     url.Values{"code": {"code"}, "state": {"xyz"}},
+		},
+		{
+			id:             2,
+			query:          "response_type=code&client_id=app&state=xyz&code_challenge=bad&code_challenge_method=plain",
+			loggedIn:       true,
+			wantStatusCode: 302,
+			wantParams:     url.Values{"error": {"invalid_request"}, "state": {"xyz"}},
+		},
+		{
+			id:             3,
+			query:          "response_type=code&client_id=app&redirect_uri=https://evil.example.com/cb",
+			loggedIn:       true,
+			wantStatusCode: 400,
+		},
+		{
+			id:             4,
+			query:          "response_type=code&client_id=app&state=xyz&code_challenge=good&code_challenge_method=S256",
+			wantStatusCode: 401,
+		},
+		{
+			id:             5,
+			query:          "response_type=code&client_id=app&state=xyz&code_challenge=new&code_challenge_method=S256",
+			loggedIn:       true,
+			wantStatusCode: 200,
+		},
+		{
+			id:             6,
+			query:          "response_type=code&client_id=app&state=xyz&code_challenge=new&code_challenge_method=S256&prompt=none",
+			loggedIn:       true,
+			wantStatusCode: 302,
+			wantParams:     url.Values{"error": {"consent_required"}, "state": {"xyz"}},
+		},
+	}
+	client := models.Client{ID: "app", RedirectURIs: []string{"https://app.example.com/cb?tenant=1"}, Scope: "read"}
+	serviceMock := new(MockService)
+	serviceMock.On("AuthorizationClient", "app", "").Return(client, client.RedirectURIs[0], nil)
+	serviceMock.On("AuthorizationClient", "app", "https://evil.example.com/cb").Return(models.Client{}, "",
+		service.ErrInvalidRedirectURI)
+	serviceMock.On("Authorize", "user", client, mock.MatchedBy(func(request models.AuthorizationRequest) bool {
+		return request.CodeChallenge == "good"
+	})).Return("code", nil)
+	serviceMock.On("Authorize", "user", client, mock.MatchedBy(func(request models.AuthorizationRequest) bool {
+		return request.CodeChallenge == "new"
+	})).Return("", fmt.Errorf("%w: app", service.ErrConsentRequired))
+	serviceMock.On("Authorize", "user", client, mock.Anything).Return("",
+		fmt.Errorf("%w: code_challenge_method S256 required", service.ErrInvalidRequest))
+
+	for _, test := range tests {
+		fmt.Printf("Тест id: %v\n", test.id)
+		req := httptest.NewRequest("GET", "/oauth/authorize?"+test.query, nil)
+		if test.loggedIn {
+			req.AddCookie(&http.Cookie{Name: "at", Value: aToken})
+		}
+		resRecorder := httptest.NewRecorder()
+		handler := http.HandlerFunc(Authorize(serviceMock))
+		handler.ServeHTTP(resRecorder, req)
+
+		require.Equal(t, test.wantStatusCode, resRecorder.Code, "статус код не соответствует ожидаемому")
+		if test.wantStatusCode == 200 {
+			var body consentPrompt
+			require.NoError(t, json.NewDecoder(resRecorder.Body).Decode(&body))
+			assert.Equal(t, consentPrompt{ConsentRequired: true, ClientID: "app", Scope: "read"}, body,
+				"запрос согласия не соответствует ожидаемому")
+			continue
+		}
+		if test.wantStatusCode != 302 {
+			assert.Empty(t, resRecorder.Header().Get("Location"), "ошибка отправлена на непроверенный адрес")
+			continue
+		}
+		location, err := url.Parse(resRecorder.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, "app.example.com", location.Host, "переадресация не на зарегистрированный адрес")
+		assert.Equal(t, "1", location.Query().Get("tenant"), "потерян исходный запрос адреса")
+		for key := range test.wantParams {
+			assert.Equal(t, test.wantParams.Get(key), location.Query().Get(key), "параметр %s не соответствует", key)
+		}
+	}
+}
+
+func TestConsent(t *testing.T) {
+	aToken, _, err := utils.GenerateTokens("user", "session", "192.0.2.1")
+	require.NoError(t, err)
+	clientToken, err := utils.NewAccessToken(&models.Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "user"},
+		ClientID: "app"}, 0)
+	require.NoError(t, err)
+	tests := []struct {
+		id             int
+		token          string
+		form           url.Values
+		wantStatusCode int
+	}{
+		{
+			id:             1,
+			token:          aToken,
+			form:           url.Values{"client_id": {"app"}, "scope": {"read"}},
+			wantStatusCode: 204,
+		},
+		{
+			id:             2,
+			token:          aToken,
+			form:           url.Values{"client_id": {"app"}, "scope": {"admin"}},
+			wantStatusCode: 400,
+		},
+		{
+			id:             3,
+			token:          aToken,
+			form:           url.Values{"client_id": {"unknown"}},
+			wantStatusCode: 400,
+		},
+		{
+			id:             4,
+			token:          clientToken,
+			form:           url.Values{"client_id": {"app"}, "scope": {"read"}},
+			wantStatusCode: 401,
+		},
+		{
+			id:             5,
+			form:           url.Values{"client_id": {"app"}, "scope": {"read"}},
+			wantStatusCode: 401,
+		},
+	}
+	serviceMock := new(MockService)
+	serviceMock.On("GrantConsent", "user", "app", "read").Return(nil)
+	serviceMock.On("GrantConsent", "user", "app", "admin").Return(service.ErrInvalidScope)
+	serviceMock.On("GrantConsent", "user", "unknown", "").Return(service.ErrInvalidClient)
+
+	for _, test := range tests {
+		fmt.Printf("Тест id: %v\n", test.id)
+		req := httptest.NewRequest("POST", "/oauth/consent", strings.NewReader(test.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if test.token != "" {
+			req.AddCookie(&http.Cookie{Name: "at", Value: test.token})
+		}
+		resRecorder := httptest.NewRecorder()
+		handler := http.HandlerFunc(Consent(serviceMock))
+		handler.ServeHTTP(resRecorder, req)
+
+		require.Equal(t, test.wantStatusCode, resRecorder.Code, "статус код не соответствует ожидаемому")
+	}
+	serviceMock.AssertNumberOfCalls(t, "GrantConsent", 3)
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/service"
+	logger "github.com/sirupsen/logrus"
+)
+
+// ForgotPassword always answers 202, so it can not be used to find out which emails are registered
+func ForgotPassword(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logger.Info("password reset requested")
+		email := req.PostFormValue("email")
+		if email != "" {
+			err := s.ForgotPassword(email)
+			if err != nil {
+				logger.Error(err)
+			}
+		}
+		res.WriteHeader(http.StatusAccepted)
+	}
+}
+
+var resetPasswordForm = template.Must(template.New("reset").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Reset password</title></head>
+<body>
+<form method="post" action="/password/reset">
+<input type="hidden" name="token" value="{{.}}">
+<label>New password <input type="password" name="password" autocomplete="new-password" required></label>
+<button type="submit">Reset password</button>
+</form>
+</body>
+</html>
+`))
+
+// ResetPasswordForm is where the emailed link leads, the form posts the token with the new password to ResetPassword
+func ResetPasswordForm() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		token := req.URL.Query().Get("token")
+		if token == "" {
+			writeJSON(res, http.StatusBadRequest, oauthError{Error: "invalid_request", ErrorDescription: "token required"})
+			return
+		}
+		res.Header().Set("Content-Type", "text/html; charset=utf-8")
+		// the token must not leak to other sites through the referer
+		res.Header().Set("Referrer-Policy", "no-referrer")
+		res.Header().Set("Cache-Control", "no-store")
+		err := resetPasswordForm.Execute(res, token)
+		if err != nil {
+			logger.Error(err)
+		}
+	}
+}
+
+func ResetPassword(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logger.Info("resetting password")
+		token := req.PostFormValue("token")
+		if token == "" {
+			writeJSON(res, http.StatusBadRequest, oauthError{Error: "invalid_request", ErrorDescription: "token required"})
+			return
+		}
+		err := s.ResetPassword(token, req.PostFormValue("password"))
+		if err != nil {
+			if errors.Is(err, service.ErrPasswordTooShort) {
+				logger.Error(err)
+				writeJSON(res, http.StatusBadRequest, oauthError{Error: "invalid_request", ErrorDescription: err.Error()})
+				return
+			}
+			if errors.Is(err, database.ErrTokenInvalid) {
+				logger.Error(err)
+				writeJSON(res, http.StatusBadRequest, oauthError{Error: "invalid_token"})
+				return
+			}
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+
+		// every session of the user has been closed together with the password change
+		clearTokenCookies(res)
+		res.WriteHeader(http.StatusNoContent)
+		logger.Info("password has been reset")
+	}
+}
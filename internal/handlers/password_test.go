@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/service"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForgotPassword(t *testing.T) {
+	tests := []struct {
+		id             int
+		email          string
+		wantStatusCode int
+	}{
+		{
+			id:             1,
+			email:          "user@example.com",
+			wantStatusCode: 202,
+		},
+		{
+			id:             2,
+			email:          "unknown@example.com",
+			wantStatusCode: 202,
+		},
+		{
+			id:             3,
+			email:          "broken@example.com",
+			wantStatusCode: 202,
+		},
+	}
+	serviceMock := new(MockService)
+	serviceMock.On("ForgotPassword", "user@example.com").Return(nil)
+	serviceMock.On("ForgotPassword", "unknown@example.com").Return(nil)
+	serviceMock.On("ForgotPassword", "broken@example.com").Return(errors.New("db is down"))
+
+	for _, test := range tests {
+		fmt.Printf("Тест id: %v\n", test.id)
+		form := url.Values{"email": {test.email}}
+		req := httptest.NewRequest("POST", "/password/forgot", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resRecorder := httptest.NewRecorder()
+		handler := http.HandlerFunc(ForgotPassword(serviceMock))
+		handler.ServeHTTP(resRecorder, req)
+
+		require.Equal(t, test.wantStatusCode, resRecorder.Code, "статус код не соответствует ожидаемому")
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	tests := []struct {
+		id             int
+		form           url.Values
+		wantStatusCode int
+	}{
+		{
+			id:             1,
+			form:           url.Values{"token": {"valid"}, "password": {"new password"}},
+			wantStatusCode: 204,
+		},
+		{
+			id:             2,
+			form:           url.Values{"token": {"used"}, "password": {"new password"}},
+			wantStatusCode: 400,
+		},
+		{
+			id:             3,
+			form:           url.Values{"token": {"valid"}, "password": {"short"}},
+			wantStatusCode: 400,
+		},
+		{
+			id:             4,
+			form:           url.Values{"password": {"new password"}},
+			wantStatusCode: 400,
+		},
+	}
+	serviceMock := new(MockService)
+	serviceMock.On("ResetPassword", "valid", "new password").Return(nil)
+	serviceMock.On("ResetPassword", "used", "new password").Return(database.ErrTokenInvalid)
+	serviceMock.On("ResetPassword", "valid", "short").Return(service.ErrPasswordTooShort)
+
+	for _, test := range tests {
+		fmt.Printf("Тест id: %v\n", test.id)
+		req := httptest.NewRequest("POST", "/password/reset", strings.NewReader(test.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resRecorder := httptest.NewRecorder()
+		handler := http.HandlerFunc(ResetPassword(serviceMock))
+		handler.ServeHTTP(resRecorder, req)
+
+		require.Equal(t, test.wantStatusCode, resRecorder.Code, "статус код не соответствует ожидаемому")
+	}
+}
+
+func TestResetPasswordForm(t *testing.T) {
+	tests := []struct {
+		id             int
+		query          string
+		wantStatusCode int
+		wantBody       string
+	}{
+		{
+			id:             1,
+			query:          "?token=abc%22def",
+			wantStatusCode: 200,
+			wantBody:       `value="abc&#34;def"`,
+		},
+		{
+			id:             2,
+			query:          "",
+			wantStatusCode: 400,
+		},
+	}
+
+	for _, test := range tests {
+		fmt.Printf("Тест id: %v\n", test.id)
+		req := httptest.NewRequest("GET", "/password/reset"+test.query, nil)
+		resRecorder := httptest.NewRecorder()
+		handler := http.HandlerFunc(ResetPasswordForm())
+		handler.ServeHTTP(resRecorder, req)
+
+		require.Equal(t, test.wantStatusCode, resRecorder.Code, "статус код не соответствует ожидаемому")
+		require.Contains(t, resRecorder.Body.String(), test.wantBody, "форма не содержит токен")
+	}
+}
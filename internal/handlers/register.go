@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/service"
+	logger "github.com/sirupsen/logrus"
+)
+
+// Register answers 202 whether the email was free or taken, so it can not be used to find out
+// which emails are registered. The owner of a taken address is told about the attempt by email.
+func Register(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logger.Info("registering user")
+		err := s.Register(req.PostFormValue("email"), req.PostFormValue("password"),
+			req.PostFormValue("display_name"))
+		if err != nil {
+			if errors.Is(err, service.ErrInvalidEmail) || errors.Is(err, service.ErrPasswordTooShort) {
+				logger.Error(err)
+				writeJSON(res, http.StatusBadRequest, oauthError{Error: "invalid_request", ErrorDescription: err.Error()})
+				return
+			}
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+
+		res.WriteHeader(http.StatusAccepted)
+		logger.Info("registration has been accepted")
+	}
+}
+
+func VerifyEmail(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logger.Info("verifying email")
+		token := req.FormValue("token")
+		if token == "" {
+			writeJSON(res, http.StatusBadRequest, oauthError{Error: "invalid_request", ErrorDescription: "token required"})
+			return
+		}
+		err := s.VerifyEmail(token)
+		if err != nil {
+			if errors.Is(err, database.ErrTokenInvalid) {
+				logger.Error(err)
+				writeJSON(res, http.StatusBadRequest, oauthError{Error: "invalid_token"})
+				return
+			}
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+
+		res.WriteHeader(http.StatusNoContent)
+		logger.Info("email has been verified")
+	}
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegister(t *testing.T) {
+	tests := []struct {
+		id             int
+		form           url.Values
+		wantStatusCode int
+		wantError      string
+	}{
+		{
+			id:             1,
+			form:           url.Values{"email": {"new@example.com"}, "password": {"password"}, "display_name": {"New"}},
+			wantStatusCode: 202,
+		},
+		{
+			id:             2,
+			form:           url.Values{"email": {"taken@example.com"}, "password": {"password"}},
+			wantStatusCode: 202,
+		},
+		{
+			id:             3,
+			form:           url.Values{"email": {"new@example.com"}, "password": {"short"}},
+			wantStatusCode: 400,
+			wantError:      "invalid_request",
+		},
+	}
+	serviceMock := new(MockService)
+	serviceMock.On("Register", "new@example.com", "password", "New").Return(nil)
+	serviceMock.On("Register", "taken@example.com", "password", "").Return(nil)
+	serviceMock.On("Register", "new@example.com", "short", "").Return(service.ErrPasswordTooShort)
+
+	for _, test := range tests {
+		fmt.Printf("Тест id: %v\n", test.id)
+		req := httptest.NewRequest("POST", "/register", strings.NewReader(test.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resRecorder := httptest.NewRecorder()
+		handler := http.HandlerFunc(Register(serviceMock))
+		handler.ServeHTTP(resRecorder, req)
+
+		require.Equal(t, test.wantStatusCode, resRecorder.Code, "статус код не соответствует ожидаемому")
+		if test.wantStatusCode == 202 {
+			assert.Empty(t, resRecorder.Body.String(), "тело ответа должно быть пустым")
+			continue
+		}
+		var body oauthError
+		require.NoError(t, json.NewDecoder(resRecorder.Body).Decode(&body))
+		assert.Equal(t, test.wantError, body.Error, "код ошибки не соответствует ожидаемому")
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	tests := []struct {
+		id             int
+		token          string
+		wantStatusCode int
+	}{
+		{
+			id:             1,
+			token:          "valid",
+			wantStatusCode: 204,
+		},
+		{
+			id:             2,
+			token:          "used",
+			wantStatusCode: 400,
+		},
+		{
+			id:             3,
+			token:          "",
+			wantStatusCode: 400,
+		},
+	}
+	serviceMock := new(MockService)
+	serviceMock.On("VerifyEmail", "valid").Return(nil)
+	serviceMock.On("VerifyEmail", "used").Return(database.ErrTokenInvalid)
+
+	for _, test := range tests {
+		fmt.Printf("Тест id: %v\n", test.id)
+		req := httptest.NewRequest("GET", "/verify-email?token="+test.token, nil)
+		resRecorder := httptest.NewRecorder()
+		handler := http.HandlerFunc(VerifyEmail(serviceMock))
+		handler.ServeHTTP(resRecorder, req)
+
+		require.Equal(t, test.wantStatusCode, resRecorder.Code, "статус код не соответствует ожидаемому")
+	}
+}
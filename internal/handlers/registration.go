@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/service"
+	logger "github.com/sirupsen/logrus"
+)
+
+// RegisterClient is the dynamic client registration endpoint of RFC 7591,
+// the bearer token is the initial access token
+func RegisterClient(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logger.Info("client registration request")
+		client, ok := readClientMetadata(res, req)
+		if !ok {
+			return
+		}
+		registration, err := s.RegisterClient(bearerToken(req), client)
+		if err != nil {
+			if writeRegistrationError(res, err) {
+				return
+			}
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(res, http.StatusCreated, registration)
+		logger.Info("client " + registration.ID + " has been registered")
+	}
+}
+
+// GetClientRegistration reads the registration with the registration access token, RFC 7592
+func GetClientRegistration(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		clientID := chi.URLParam(req, "clientID")
+		logger.Info("reading registration of client " + clientID)
+		registration, err := s.GetClientRegistration(clientID, bearerToken(req))
+		if err != nil {
+			if writeRegistrationError(res, err) {
+				return
+			}
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(res, http.StatusOK, registration)
+	}
+}
+
+// UpdateClientRegistration replaces the metadata of the client, RFC 7592
+func UpdateClientRegistration(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		clientID := chi.URLParam(req, "clientID")
+		logger.Info("updating registration of client " + clientID)
+		client, ok := readClientMetadata(res, req)
+		if !ok {
+			return
+		}
+		registration, err := s.UpdateClientRegistration(clientID, bearerToken(req), client)
+		if err != nil {
+			if writeRegistrationError(res, err) {
+				return
+			}
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(res, http.StatusOK, registration)
+		logger.Info("registration of client " + clientID + " has been updated")
+	}
+}
+
+// DeleteClientRegistration deletes the client, RFC 7592
+func DeleteClientRegistration(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		clientID := chi.URLParam(req, "clientID")
+		logger.Info("deleting registration of client " + clientID)
+		err := s.DeleteClientRegistration(clientID, bearerToken(req))
+		if err != nil {
+			if writeRegistrationError(res, err) {
+				return
+			}
+			logger.Error(err)
+			http.Error(res, "", http.StatusInternalServerError)
+			return
+		}
+		res.WriteHeader(http.StatusNoContent)
+		logger.Info("client " + clientID + " has been deleted")
+	}
+}
+
+func readClientMetadata(res http.ResponseWriter, req *http.Request) (models.Client, bool) {
+	var client models.Client
+	err := json.NewDecoder(req.Body).Decode(&client)
+	if err != nil {
+		logger.Error(err)
+		writeJSON(res, http.StatusBadRequest, oauthError{Error: "invalid_client_metadata",
+			ErrorDescription: "malformed JSON"})
+		return client, false
+	}
+	return client, true
+}
+
+func bearerToken(req *http.Request) string {
+	token, _ := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return token
+}
+
+// writeRegistrationError writes the RFC 7591 section 3.2.2 error response, it returns false for other errors
+func writeRegistrationError(res http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, service.ErrInvalidInitialToken), errors.Is(err, service.ErrInvalidRegistrationToken):
+		logger.Error(err)
+		res.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeJSON(res, http.StatusUnauthorized, oauthError{Error: "invalid_token"})
+	case errors.Is(err, service.ErrRegistrationDisabled):
+		logger.Error(err)
+		writeJSON(res, http.StatusForbidden, oauthError{Error: "access_denied", ErrorDescription: err.Error()})
+	case errors.Is(err, service.ErrInvalidClientRedirectURI):
+		logger.Error(err)
+		writeJSON(res, http.StatusBadRequest, oauthError{Error: "invalid_redirect_uri", ErrorDescription: err.Error()})
+	case errors.Is(err, service.ErrInvalidClientMetadata):
+		logger.Error(err)
+		writeJSON(res, http.StatusBadRequest, oauthError{Error: "invalid_client_metadata", ErrorDescription: err.Error()})
+	default:
+		return false
+	}
+	return true
+}
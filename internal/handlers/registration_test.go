@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientRegistration(t *testing.T) {
+	tests := []struct {
+		id             int
+		method         string
+		path           string
+		token          string
+		body           string
+		wantStatusCode int
+		wantError      string
+	}{
+		{
+			id:             1,
+			method:         "POST",
+			path:           "/register/client",
+			token:          "initial",
+			body:           `{"redirect_uris":["https://app.example.com/cb"]}`,
+			wantStatusCode: 201,
+		},
+		{
+			id:             2,
+			method:         "POST",
+			path:           "/register/client",
+			token:          "wrong",
+			body:           `{"redirect_uris":["https://app.example.com/cb"]}`,
+			wantStatusCode: 401,
+			wantError:      "invalid_token",
+		},
+		{
+			id:             3,
+			method:         "POST",
+			path:           "/register/client",
+			token:          "initial",
+			body:           `{"redirect_uris":`,
+			wantStatusCode: 400,
+			wantError:      "invalid_client_metadata",
+		},
+		{
+			id:             4,
+			method:         "POST",
+			path:           "/register/client",
+			token:          "initial",
+			body:           `{"redirect_uris":["/cb"]}`,
+			wantStatusCode: 400,
+			wantError:      "invalid_redirect_uri",
+		},
+		{
+			id:             5,
+			method:         "GET",
+			path:           "/register/client/app",
+			token:          "registration",
+			wantStatusCode: 200,
+		},
+		{
+			id:             6,
+			method:         "GET",
+			path:           "/register/client/app",
+			token:          "initial",
+			wantStatusCode: 401,
+			wantError:      "invalid_token",
+		},
+		{
+			id:             7,
+			method:         "PUT",
+			path:           "/register/client/app",
+			token:          "registration",
+			body:           `{"client_id":"app","scope":"admin"}`,
+			wantStatusCode: 400,
+			wantError:      "invalid_client_metadata",
+		},
+		{
+			id:             8,
+			method:         "DELETE",
+			path:           "/register/client/app",
+			token:          "registration",
+			wantStatusCode: 204,
+		},
+	}
+	registration := models.ClientRegistration{Client: models.Client{ID: "app"},
+		RegistrationAccessToken: "registration", RegistrationClientURI: "http://localhost/register/client/app"}
+	serviceMock := new(MockService)
+	serviceMock.On("RegisterClient", "initial", models.Client{RedirectURIs: []string{"https://app.example.com/cb"}}).
+		Return(registration, nil)
+	serviceMock.On("RegisterClient", "wrong", models.Client{RedirectURIs: []string{"https://app.example.com/cb"}}).
+		Return(models.ClientRegistration{}, service.ErrInvalidInitialToken)
+	serviceMock.On("RegisterClient", "initial", models.Client{RedirectURIs: []string{"/cb"}}).
+		Return(models.ClientRegistration{}, fmt.Errorf("%w: %w /cb", service.ErrInvalidClientMetadata,
+			service.ErrInvalidClientRedirectURI))
+	serviceMock.On("GetClientRegistration", "app", "registration").Return(registration, nil)
+	serviceMock.On("GetClientRegistration", "app", "initial").Return(models.ClientRegistration{},
+		service.ErrInvalidRegistrationToken)
+	serviceMock.On("UpdateClientRegistration", "app", "registration", models.Client{ID: "app", Scope: "admin"}).
+		Return(models.ClientRegistration{}, service.ErrInvalidClientMetadata)
+	serviceMock.On("DeleteClientRegistration", "app", "registration").Return(nil)
+
+	r := chi.NewRouter()
+	r.Post("/register/client", RegisterClient(serviceMock))
+	r.Get("/register/client/{clientID}", GetClientRegistration(serviceMock))
+	r.Put("/register/client/{clientID}", UpdateClientRegistration(serviceMock))
+	r.Delete("/register/client/{clientID}", DeleteClientRegistration(serviceMock))
+
+	for _, test := range tests {
+		fmt.Printf("Тест id: %v\n", test.id)
+		req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+test.token)
+		resRecorder := httptest.NewRecorder()
+		r.ServeHTTP(resRecorder, req)
+
+		require.Equal(t, test.wantStatusCode, resRecorder.Code, "статус код не соответствует ожидаемому")
+		if test.wantStatusCode == 401 {
+			assert.Contains(t, resRecorder.Header().Get("WWW-Authenticate"), "invalid_token")
+		}
+		if test.wantStatusCode == 204 {
+			continue
+		}
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(resRecorder.Body).Decode(&body))
+		if test.wantError != "" {
+			assert.Equal(t, test.wantError, body["error"], "код ошибки не соответствует ожидаемому")
+			continue
+		}
+		assert.Equal(t, "app", body["client_id"])
+		assert.Equal(t, "http://localhost/register/client/app", body["registration_client_uri"])
+	}
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/service"
+	logger "github.com/sirupsen/logrus"
+)
+
+// the ceremony state stays on the server, the browser keeps only its id
+const webAuthnCookie = "webauthn"
+
+func WebAuthnRegisterBegin(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logger.Info("starting passkey registration")
+		guid, err := recentUser(req)
+		if err != nil {
+			writeRecentUserError(res, err)
+			return
+		}
+		options, sessionID, err := s.BeginWebAuthnRegistration(guid)
+		if err != nil {
+			writeWebAuthnError(res, err)
+			return
+		}
+
+		setWebAuthnCookie(res, sessionID)
+		writeJSON(res, http.StatusOK, options)
+	}
+}
+
+func WebAuthnRegisterFinish(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logger.Info("finishing passkey registration")
+		guid, err := recentUser(req)
+		if err != nil {
+			writeRecentUserError(res, err)
+			return
+		}
+		sessionCookie, err := req.Cookie(webAuthnCookie)
+		if err != nil {
+			logger.Error(err)
+			writeJSON(res, http.StatusBadRequest, oauthError{Error: "invalid_session"})
+			return
+		}
+		err = s.FinishWebAuthnRegistration(guid, sessionCookie.Value, req.Body)
+		if err != nil {
+			writeWebAuthnError(res, err)
+			return
+		}
+
+		clearWebAuthnCookie(res)
+		res.WriteHeader(http.StatusCreated)
+		logger.Info("passkey has been registered")
+	}
+}
+
+func WebAuthnLoginBegin(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logger.Info("starting passkey login")
+		options, sessionID, err := s.BeginWebAuthnLogin(req.PostFormValue("login"))
+		if err != nil {
+			writeWebAuthnError(res, err)
+			return
+		}
+
+		setWebAuthnCookie(res, sessionID)
+		writeJSON(res, http.StatusOK, options)
+	}
+}
+
+func WebAuthnLoginFinish(s service.ServiceInterface) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		logger.Info("finishing passkey login")
+		sessionCookie, err := req.Cookie(webAuthnCookie)
+		if err != nil {
+			logger.Error(err)
+			writeJSON(res, http.StatusBadRequest, oauthError{Error: "invalid_session"})
+			return
+		}
+		guid, err := s.FinishWebAuthnLogin(sessionCookie.Value, req.Body)
+		if err != nil {
+			writeWebAuthnError(res, err)
+			return
+		}
+
+		clearWebAuthnCookie(res)
+		if !issueTokens(res, req, s, guid, models.AMRPasskey) {
+			return
+		}
+		logger.Info("tokens have been sent")
+	}
+}
+
+func writeWebAuthnError(res http.ResponseWriter, err error) {
+	logger.Error(err)
+	switch {
+	case errors.Is(err, database.ErrTokenInvalid):
+		writeJSON(res, http.StatusBadRequest, oauthError{Error: "invalid_session"})
+	case errors.Is(err, database.ErrCredentialExists):
+		writeJSON(res, http.StatusConflict, oauthError{Error: "credential_exists"})
+	case errors.Is(err, service.ErrWebAuthnFailed), errors.Is(err, service.ErrWebAuthnCloned),
+		errors.Is(err, service.ErrInvalidCredentials):
+		writeJSON(res, http.StatusUnauthorized, oauthError{Error: "webauthn_failed"})
+	case errors.Is(err, service.ErrWebAuthnDisabled):
+		writeJSON(res, http.StatusNotFound, oauthError{Error: "webauthn_disabled"})
+	default:
+		if !writeUserError(res, err) {
+			http.Error(res, "", http.StatusInternalServerError)
+		}
+	}
+}
+
+func setWebAuthnCookie(res http.ResponseWriter, sessionID string) {
+	http.SetCookie(res, &http.Cookie{
+		Name:     webAuthnCookie,
+		Value:    sessionID,
+		Path:     "/webauthn",
+		MaxAge:   300,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func clearWebAuthnCookie(res http.ResponseWriter) {
+	http.SetCookie(res, &http.Cookie{
+		Name:     webAuthnCookie,
+		Value:    "",
+		Path:     "/webauthn",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/service"
+	"github.com/sater-151/tt-auth/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebAuthnLogin(t *testing.T) {
+	serviceMock := new(MockService)
+	serviceMock.On("BeginWebAuthnLogin", "user@example.com").Return(&protocol.CredentialAssertion{}, "ceremony", nil)
+	serviceMock.On("FinishWebAuthnLogin", "ceremony").Return("passkey", nil)
+	serviceMock.On("FinishWebAuthnLogin", "cloned").Return("", service.ErrWebAuthnCloned)
+	serviceMock.On("FinishWebAuthnLogin", "expired").Return("", database.ErrTokenInvalid)
+	serviceMock.On("CreateSession", sessionOf("passkey")).Return(nil)
+
+	req := httptest.NewRequest("POST", "/webauthn/login/begin", strings.NewReader("login=user%40example.com"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resRecorder := httptest.NewRecorder()
+	http.HandlerFunc(WebAuthnLoginBegin(serviceMock)).ServeHTTP(resRecorder, req)
+	require.Equal(t, 200, resRecorder.Code, "статус код не соответствует ожидаемому")
+	cookies := resRecorder.Result().Cookies()
+	require.Equal(t, 1, len(cookies))
+	assert.Equal(t, webAuthnCookie, cookies[0].Name, "название куки не соответствует")
+	assert.Equal(t, "ceremony", cookies[0].Value, "идентификатор церемонии не соответствует")
+
+	tests := []struct {
+		id             int
+		session        string
+		wantStatusCode int
+	}{
+		{
+			id:             1,
+			session:        "ceremony",
+			wantStatusCode: 200,
+		},
+		{
+			id:             2,
+			session:        "cloned",
+			wantStatusCode: 401,
+		},
+		{
+			id:             3,
+			session:        "expired",
+			wantStatusCode: 400,
+		},
+		{
+			id:             4,
+			wantStatusCode: 400,
+		},
+	}
+	for _, test := range tests {
+		fmt.Printf("Тест id: %v\n", test.id)
+		req := httptest.NewRequest("POST", "/webauthn/login/finish", strings.NewReader("{}"))
+		if test.session != "" {
+			req.AddCookie(&http.Cookie{Name: webAuthnCookie, Value: test.session})
+		}
+		resRecorder := httptest.NewRecorder()
+		http.HandlerFunc(WebAuthnLoginFinish(serviceMock)).ServeHTTP(resRecorder, req)
+
+		require.Equal(t, test.wantStatusCode, resRecorder.Code, "статус код не соответствует ожидаемому")
+		if test.wantStatusCode == 200 {
+			names := []string{}
+			for _, cookie := range resRecorder.Result().Cookies() {
+				names = append(names, cookie.Name)
+			}
+			assert.Equal(t, []string{webAuthnCookie, "at", "rt"}, names, "куки не соответствуют")
+		}
+	}
+}
+
+func TestWebAuthnRegisterBegin(t *testing.T) {
+	token := func(claims models.Claims) string {
+		claims.Subject = "user"
+		aToken, err := utils.NewAccessToken(&claims, 0)
+		require.NoError(t, err)
+		return aToken
+	}
+	fresh := token(models.Claims{AuthTime: jwt.NewNumericDate(time.Now()), AMR: []string{models.AMRPassword}})
+	clientToken := token(models.Claims{ClientID: "app", AuthTime: jwt.NewNumericDate(time.Now()),
+		AMR: []string{models.AMRPassword}})
+
+	tests := []struct {
+		id             int
+		cookie         string
+		authorization  string
+		wantStatusCode int
+		wantError      string
+	}{
+		{
+			id:             1,
+			cookie:         fresh,
+			wantStatusCode: 200,
+		},
+		{
+			id:             2,
+			authorization:  "Bearer " + clientToken,
+			wantStatusCode: 401,
+			wantError:      "invalid_token",
+		},
+		{
+			id:             3,
+			authorization:  "Bearer " + fresh,
+			wantStatusCode: 401,
+			wantError:      "invalid_token",
+		},
+		{
+			id:             4,
+			cookie:         clientToken,
+			wantStatusCode: 401,
+			wantError:      "invalid_token",
+		},
+		{
+			id:             5,
+			cookie:         token(models.Claims{}),
+			wantStatusCode: 401,
+			wantError:      "login_required",
+		},
+		{
+			id: 6,
+			cookie: token(models.Claims{AuthTime: jwt.NewNumericDate(time.Now().Add(-time.Hour)),
+				AMR: []string{models.AMRPassword}}),
+			wantStatusCode: 401,
+			wantError:      "login_required",
+		},
+		{
+			id:             7,
+			cookie:         token(models.Claims{AuthTime: jwt.NewNumericDate(time.Now()), AMR: []string{models.AMREmail}}),
+			wantStatusCode: 401,
+			wantError:      "login_required",
+		},
+	}
+	serviceMock := new(MockService)
+	serviceMock.On("BeginWebAuthnRegistration", "user").Return(&protocol.CredentialCreation{}, "ceremony", nil)
+
+	for _, test := range tests {
+		fmt.Printf("Тест id: %v\n", test.id)
+		req := httptest.NewRequest("POST", "/webauthn/register/begin", nil)
+		if test.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "at", Value: test.cookie})
+		}
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+		resRecorder := httptest.NewRecorder()
+		http.HandlerFunc(WebAuthnRegisterBegin(serviceMock)).ServeHTTP(resRecorder, req)
+
+		require.Equal(t, test.wantStatusCode, resRecorder.Code, "статус код не соответствует ожидаемому")
+		if test.wantError != "" {
+			var body map[string]interface{}
+			require.NoError(t, json.NewDecoder(resRecorder.Body).Decode(&body))
+			assert.Equal(t, test.wantError, body["error"], "код ошибки не соответствует ожидаемому")
+		}
+	}
+	serviceMock.AssertNumberOfCalls(t, "BeginWebAuthnRegistration", 1)
+}
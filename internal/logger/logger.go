@@ -1,23 +1,23 @@
-package logger
-
-import (
-	"os"
-
-	logger "github.com/sirupsen/logrus"
-)
-
-func Init() {
-	logger.SetFormatter(&logger.TextFormatter{FullTimestamp: true})
-	lvl, ok := os.LookupEnv("LOG_LEVEL")
-
-	if !ok {
-		lvl = "debug"
-	}
-
-	ll, err := logger.ParseLevel(lvl)
-	if err != nil {
-		ll = logger.DebugLevel
-	}
-
-	logger.SetLevel(ll)
-}
+package logger
+
+import (
+	"os"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+func Init() {
+	logger.SetFormatter(&logger.TextFormatter{FullTimestamp: true})
+	lvl, ok := os.LookupEnv("LOG_LEVEL")
+
+	if !ok {
+		lvl = "debug"
+	}
+
+	ll, err := logger.ParseLevel(lvl)
+	if err != nil {
+		ll = logger.DebugLevel
+	}
+
+	logger.SetLevel(ll)
+}
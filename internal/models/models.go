@@ -1,27 +1,413 @@
-package models
-
-import (
-	"time"
-)
-
-type ServerConfig struct {
-	Port string
-}
-
-type DBConfig struct {
-	User    string
-	Pass    string
-	Dbname  string
-	Sslmode string
-	Port    string
-	Host    string
-}
-
-type Claims struct {
-	Host string
-	Exp  time.Time
-}
-
-type RTStruct struct {
-	rt string
-}
+package models
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type ServerConfig struct {
+	Port           string
+	TrustedProxies string
+}
+
+type DBConfig struct {
+	User    string
+	Pass    string
+	Dbname  string
+	Sslmode string
+	Port    string
+	Host    string
+}
+
+type MailConfig struct {
+	// smtp or outbox
+	Notifier string
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+	// starttls, tls or none
+	TLS        string
+	Timeout    time.Duration
+	OutboxFile string
+}
+
+type AccountConfig struct {
+	PublicURL            string
+	RequireEmailVerified bool
+	VerifyEmailTTL       time.Duration
+	ResetPasswordTTL     time.Duration
+	ResetPasswordLimit   int
+	ResetPasswordWindow  time.Duration
+	MFAIssuer            string
+	MFAChallengeTTL      time.Duration
+	MFAMaxAttempts       int
+	MagicLinkTTL         time.Duration
+	MagicLinkLimit       int
+	MagicLinkWindow      time.Duration
+}
+
+type WebAuthnConfig struct {
+	RPID          string
+	RPDisplayName string
+	Origins       []string
+}
+
+type LockoutConfig struct {
+	Threshold    int
+	IPThreshold  int
+	FreeAttempts int
+	DelayBase    time.Duration
+	DelayMax     time.Duration
+	Cooldown     time.Duration
+	Window       time.Duration
+}
+
+// RateLimit allows Requests per Period, all of them may come at once
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+type RateLimitConfig struct {
+	// memory or postgres
+	Backend string
+	// limits by request path
+	Routes map[string]RateLimit
+}
+
+type OAuthConfig struct {
+	CodeTTL time.Duration
+	// initial access token of dynamic client registration, empty turns registration off
+	RegistrationToken string
+	// scopes registered clients may ask for, also the default scope of a registration
+	RegistrationScope string
+}
+
+type OutboxConfig struct {
+	Workers      int
+	MaxAttempts  int
+	RetryBase    time.Duration
+	RetryMax     time.Duration
+	PollInterval time.Duration
+	// how long a claimed notification is hidden from other workers
+	Lease time.Duration
+}
+
+type KeyConfig struct {
+	Alg            string
+	PrivateKeyFile string
+	// zero disables scheduled rotation
+	RotationPeriod time.Duration
+	PublishAhead   time.Duration
+	// how long a retired key keeps verifying tokens
+	VerifyFor      time.Duration
+	ReloadInterval time.Duration
+}
+
+type Claims struct {
+	jwt.RegisteredClaims
+	ClientIP  string `json:"ip"`
+	SessionID string `json:"sid,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	// set only on tokens issued right after the user has authenticated, not on refreshed ones
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	AMR      []string         `json:"amr,omitempty"`
+}
+
+// authentication methods of the amr claim, RFC 8176
+const (
+	AMRPassword = "pwd"
+	AMROTP      = "otp"
+	AMRMFA      = "mfa"
+	AMRPasskey  = "hwk"
+	AMREmail    = "email"
+)
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+type SigningKey struct {
+	KID         string
+	Alg         string
+	PrivateKey  string
+	CreatedAt   time.Time
+	ActivatesAt time.Time
+	RetiresAt   time.Time
+	ExpiresAt   time.Time
+}
+
+type Client struct {
+	ID string `json:"client_id"`
+	// plain secret, only known when the client is created or the secret is rotated
+	Secret     string `json:"client_secret,omitempty"`
+	SecretHash string `json:"-"`
+	Name       string `json:"client_name,omitempty"`
+	// public clients can not keep a secret and authenticate with none
+	Type       string `json:"client_type"`
+	AuthMethod string `json:"token_endpoint_auth_method"`
+	// grants the client may use at the token endpoint, none means it only introspects tokens
+	GrantTypes []string `json:"grant_types,omitempty"`
+	// space separated scopes the client may ask for
+	Scope string `json:"scope,omitempty"`
+	// compared exactly with the redirect_uri of authorization requests
+	RedirectURIs []string `json:"redirect_uris,omitempty"`
+	// public keys of private_key_jwt assertions
+	JWKS *JWKSet `json:"jwks,omitempty"`
+	// seconds, zero keeps ATEXPIRES and RTEXPIRES, the access token can not live longer than ATEXPIRES
+	AccessTokenTTL  int       `json:"access_token_ttl,omitempty"`
+	RefreshTokenTTL int       `json:"refresh_token_ttl,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	// clients of the operator get codes without asking the user, only an administrator can set it
+	FirstParty bool `json:"first_party,omitempty"`
+	// lets the client manage its own registration, RFC 7592
+	RegistrationTokenHash string `json:"-"`
+}
+
+const (
+	ClientPublic       = "public"
+	ClientConfidential = "confidential"
+)
+
+// token endpoint authentication methods, RFC 7591 section 2
+const (
+	AuthNone              = "none"
+	AuthClientSecretBasic = "client_secret_basic"
+	AuthClientSecretPost  = "client_secret_post"
+	AuthPrivateKeyJWT     = "private_key_jwt"
+)
+
+// ClientRegistration is the client information response of RFC 7591 section 3.2.1
+type ClientRegistration struct {
+	Client
+	ClientIDIssuedAt int64 `json:"client_id_issued_at"`
+	// zero means the secret does not expire
+	ClientSecretExpiresAt   int64  `json:"client_secret_expires_at"`
+	RegistrationAccessToken string `json:"registration_access_token,omitempty"`
+	RegistrationClientURI   string `json:"registration_client_uri"`
+}
+
+// ClientCredentials is what the client has sent to authenticate itself
+type ClientCredentials struct {
+	ID        string
+	Secret    string
+	Method    string
+	Assertion string
+	// path of the endpoint, a client assertion may be issued for it
+	Endpoint string
+}
+
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+)
+
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	RefreshToken string
+	CodeVerifier string
+	Scope        string
+	ClientIP     string
+	UserAgent    string
+}
+
+type AuthorizationRequest struct {
+	ResponseType string
+	ClientID     string
+	RedirectURI  string
+	// whether redirect_uri was in the request or the only registered one has been taken
+	RedirectURISent     bool
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// TokenResponse is the successful response of the token endpoint, RFC 6749 section 5.1
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+type AuthorizationCode struct {
+	Hash        string
+	ClientID    string
+	UserID      string
+	RedirectURI string
+	Scope       string
+	ExpiresAt   time.Time
+	// RFC 6749 section 4.1.3, the token request must repeat redirect_uri only when it was sent
+	RedirectURISent bool
+	// S256 of the PKCE code verifier
+	CodeChallenge string
+	// the session started with the code, it is revoked when the code is replayed
+	SessionID string
+}
+
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+}
+
+type RTStruct struct {
+	rt string
+}
+
+const (
+	UserActive   = "active"
+	UserDisabled = "disabled"
+	UserLocked   = "locked"
+)
+
+type User struct {
+	ID            string
+	Email         string
+	Username      string
+	EmailVerified bool
+	DisplayName   string
+	Status        string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+	TokenMFAChallenge  = "mfa_challenge"
+	TokenMagicLink     = "magic_link"
+)
+
+// UserToken is a single use token sent to the user by email, only its hash is stored
+type UserToken struct {
+	UserID    string
+	Purpose   string
+	Hash      string
+	ExpiresAt time.Time
+	Attempts  int
+	// BindingHash ties the token to the browser which asked for it
+	BindingHash string
+}
+
+// TOTP holds the authenticator secret encrypted with MFA_ENCRYPTION_KEY
+type TOTP struct {
+	UserID       string
+	Secret       string
+	Confirmed    bool
+	LastUsedStep int64
+}
+
+// WebAuthnCredential keeps the passkey as JSON, sign count is a column to check it atomically
+type WebAuthnCredential struct {
+	UserID       string
+	CredentialID []byte
+	SignCount    uint32
+	Credential   []byte
+}
+
+// Attempts counts failed authentications of an account or of an IP address
+type Attempts struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
+
+type AccountLock struct {
+	Until    time.Time
+	ClientIP string
+}
+
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type EmailLink struct {
+	Link      string
+	ExpiresAt time.Time
+}
+
+type Session struct {
+	ID         string
+	UserID     string
+	RT         string
+	RTSelector string
+	RTHash     string
+	UserAgent  string
+	ClientIP   string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+	// zero when sliding idle timeout is disabled
+	IdleExpiresAt time.Time
+	// empty for sessions started with cookies
+	ClientID string
+	Scope    string
+}
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+const (
+	NotificationPending = "pending"
+	NotificationSent    = "sent"
+	NotificationDead    = "dead"
+)
+
+type Notification struct {
+	ID            string
+	Template      string
+	Message       Message
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+}
+
+type LoginWarning struct {
+	Reason    string
+	OldIP     string
+	NewIP     string
+	UserAgent string
+	Time      time.Time
+}
+
+type SecurityEvent struct {
+	UserID    string
+	SessionID string
+	Event     string
+}
@@ -0,0 +1,56 @@
+package notifier
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/models"
+	logger "github.com/sirupsen/logrus"
+)
+
+var ErrUnknownNotifier = errors.New("unknown notifier")
+
+type Notifier interface {
+	Send(msg models.Message) error
+}
+
+func New(config models.MailConfig) (Notifier, error) {
+	switch config.Notifier {
+	case "smtp":
+		return NewSMTP(config), nil
+	case "outbox":
+		return NewOutbox(config.OutboxFile), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownNotifier, config.Notifier)
+}
+
+// Outbox is a development notifier which appends messages to a file
+// or writes them to the log when no file is set
+type Outbox struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewOutbox(path string) *Outbox {
+	return &Outbox{path: path}
+}
+
+func (o *Outbox) Send(msg models.Message) error {
+	if o.path == "" {
+		logger.WithField("to", msg.To).Info(msg.Subject + "\n" + msg.Body)
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	file, err := os.OpenFile(o.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = fmt.Fprintf(file, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC1123Z),
+		msg.To, msg.Subject, msg.Body)
+	return err
+}
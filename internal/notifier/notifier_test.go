@@ -0,0 +1,59 @@
+package notifier
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderLoginWarning(t *testing.T) {
+	msg, err := Render(TemplateLoginWarning, "user@example.com", models.LoginWarning{
+		OldIP:     "192.0.2.1",
+		NewIP:     "198.51.100.1",
+		UserAgent: "curl/8.0",
+		Time:      time.Date(2024, 12, 7, 15, 4, 5, 0, time.UTC),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "user@example.com", msg.To)
+	assert.Equal(t, "Suspicious activity in your account", msg.Subject)
+	for _, want := range []string{"192.0.2.1", "198.51.100.1", "curl/8.0", "2024-12-07 15:04:05 UTC"} {
+		assert.Contains(t, msg.Body, want, "в письме нет данных входа")
+	}
+
+	_, err = Render("unknown", "user@example.com", nil)
+	assert.Equal(t, ErrUnknownTemplate, err)
+}
+
+func TestRenderVerifyEmail(t *testing.T) {
+	msg, err := Render(TemplateVerifyEmail, "user@example.com", models.EmailLink{
+		Link:      "http://localhost:8080/verify-email?token=abc",
+		ExpiresAt: time.Date(2024, 12, 8, 15, 4, 5, 0, time.UTC),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Confirm your email address", msg.Subject)
+	assert.Contains(t, msg.Body, "http://localhost:8080/verify-email?token=abc", "в письме нет ссылки")
+	assert.Contains(t, msg.Body, "2024-12-08 15:04:05 UTC", "в письме нет срока действия ссылки")
+}
+
+func TestOutbox(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.txt")
+	outbox := NewOutbox(path)
+	require.NoError(t, outbox.Send(models.Message{To: "a@example.com", Subject: "first", Body: "body"}))
+	require.NoError(t, outbox.Send(models.Message{To: "b@example.com", Subject: "second", Body: "body"}))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "To: a@example.com"))
+	assert.Equal(t, 1, strings.Count(string(data), "Subject: second"))
+}
+
+func TestComposeHeaderInjection(t *testing.T) {
+	msg := compose("auth@example.com", models.Message{To: "a@example.com\r\nBcc: evil@example.com", Subject: "s", Body: "b"})
+	assert.NotContains(t, string(msg), "\r\nBcc:")
+}
@@ -0,0 +1,79 @@
+package notifier
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"text/template"
+
+	"github.com/sater-151/tt-auth/internal/models"
+)
+
+var ErrUnknownTemplate = errors.New("unknown message template")
+
+const TemplateLoginWarning = "login_warning"
+const TemplateVerifyEmail = "verify_email"
+const TemplateResetPassword = "reset_password"
+const TemplateMagicLink = "magic_link"
+const TemplateAccountLocked = "account_locked"
+const TemplateAlreadyRegistered = "already_registered"
+
+// the first line of a template is the subject
+var templates = template.Must(template.New("").Parse(`
+{{define "login_warning"}}Suspicious activity in your account
+{{if eq .Reason "refresh_token_reuse"}}An already used refresh token of your session was presented again, so the session has been closed.
+{{else}}Your session has been used from another IP address.
+{{end}}
+Time: {{.Time.Format "2006-01-02 15:04:05 MST"}}
+{{with .OldIP}}Previous IP: {{.}}
+{{end}}{{with .NewIP}}New IP: {{.}}
+{{end}}{{with .UserAgent}}User agent: {{.}}
+{{end}}
+If it was not you, log out from all sessions.
+{{end}}
+{{define "verify_email"}}Confirm your email address
+Follow the link to confirm your email address:
+{{.Link}}
+
+The link can be used once and expires at {{.ExpiresAt.Format "2006-01-02 15:04:05 MST"}}.
+If you did not register, ignore this message.
+{{end}}
+{{define "reset_password"}}Reset your password
+Follow the link to set a new password:
+{{.Link}}
+
+The link can be used once and expires at {{.ExpiresAt.Format "2006-01-02 15:04:05 MST"}}.
+All your sessions will be closed after the password is changed.
+If you did not ask to reset the password, ignore this message.
+{{end}}
+{{define "magic_link"}}Your login link
+Follow the link to log in:
+{{.Link}}
+
+The link works once, only in the browser where you asked for it, and expires at {{.ExpiresAt.Format "2006-01-02 15:04:05 MST"}}.
+If you did not try to log in, ignore this message.
+{{end}}
+{{define "account_locked"}}Your account has been locked
+There were too many failed attempts to log in to your account, so it is locked until {{.Until.Format "2006-01-02 15:04:05 MST"}}.
+{{with .ClientIP}}Last attempt came from IP: {{.}}
+{{end}}
+If it was not you, change your password once the account is unlocked.
+{{end}}
+{{define "already_registered"}}You already have an account
+Someone tried to register a new account with this email address, but it is already registered.
+If it was you, log in with your password or a login link, or reset the password if you forgot it.
+If it was not you, ignore this message.
+{{end}}`))
+
+func Render(name, to string, data interface{}) (models.Message, error) {
+	if templates.Lookup(name) == nil {
+		return models.Message{}, ErrUnknownTemplate
+	}
+	var buf bytes.Buffer
+	err := templates.ExecuteTemplate(&buf, name, data)
+	if err != nil {
+		return models.Message{}, err
+	}
+	subject, body, _ := strings.Cut(buf.String(), "\n")
+	return models.Message{To: to, Subject: subject, Body: strings.TrimSpace(body)}, nil
+}
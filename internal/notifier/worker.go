@@ -0,0 +1,93 @@
+package notifier
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/models"
+	logger "github.com/sirupsen/logrus"
+)
+
+type Store interface {
+	ClaimNotifications(limit int, lease time.Duration) ([]models.Notification, error)
+	MarkNotificationSent(id string) error
+	MarkNotificationFailed(id, lastError string, retryAt time.Time, dead bool) error
+}
+
+// Worker drains the notification outbox with a pool of senders
+type Worker struct {
+	store    Store
+	notifier Notifier
+	config   models.OutboxConfig
+}
+
+func NewWorker(store Store, notifier Notifier, config models.OutboxConfig) *Worker {
+	if config.Workers <= 0 {
+		config.Workers = 1
+	}
+	return &Worker{store: store, notifier: notifier, config: config}
+}
+
+func (w *Worker) Run() {
+	ticker := time.NewTicker(w.config.PollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		// keep draining while there are due notifications
+		for w.Drain() == w.config.Workers {
+		}
+	}
+}
+
+// Drain sends one batch of due notifications and returns its size
+func (w *Worker) Drain() int {
+	notifications, err := w.store.ClaimNotifications(w.config.Workers, w.config.Lease)
+	if err != nil {
+		logger.Error(err)
+		return 0
+	}
+	var wg sync.WaitGroup
+	for _, notification := range notifications {
+		wg.Add(1)
+		go func(notification models.Notification) {
+			defer wg.Done()
+			w.deliver(notification)
+		}(notification)
+	}
+	wg.Wait()
+	return len(notifications)
+}
+
+func (w *Worker) deliver(notification models.Notification) {
+	err := w.notifier.Send(notification.Message)
+	if err == nil {
+		err = w.store.MarkNotificationSent(notification.ID)
+		if err != nil {
+			logger.Error(err)
+		}
+		return
+	}
+
+	dead := notification.Attempts >= w.config.MaxAttempts
+	if dead {
+		logger.Error("notification " + notification.ID + " is dead: " + err.Error())
+	} else {
+		logger.Warn("notification " + notification.ID + " failed: " + err.Error())
+	}
+	err = w.store.MarkNotificationFailed(notification.ID, err.Error(), time.Now().Add(w.backoff(notification.Attempts)), dead)
+	if err != nil {
+		logger.Error(err)
+	}
+}
+
+// backoff doubles the delay with every attempt and adds up to 20% of jitter
+func (w *Worker) backoff(attempts int) time.Duration {
+	delay := w.config.RetryBase
+	for i := 1; i < attempts && delay < w.config.RetryMax; i++ {
+		delay *= 2
+	}
+	if delay > w.config.RetryMax {
+		delay = w.config.RetryMax
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
@@ -0,0 +1,108 @@
+package notifier
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryStore struct {
+	mu            sync.Mutex
+	notifications map[string]*models.Notification
+}
+
+func (m *memoryStore) ClaimNotifications(limit int, lease time.Duration) ([]models.Notification, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var claimed []models.Notification
+	for _, n := range m.notifications {
+		if len(claimed) == limit || n.Status != models.NotificationPending || n.NextAttemptAt.After(time.Now()) {
+			continue
+		}
+		n.Attempts++
+		n.NextAttemptAt = time.Now().Add(lease)
+		claimed = append(claimed, *n)
+	}
+	return claimed, nil
+}
+
+func (m *memoryStore) MarkNotificationSent(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notifications[id].Status = models.NotificationSent
+	return nil
+}
+
+func (m *memoryStore) MarkNotificationFailed(id, lastError string, retryAt time.Time, dead bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := m.notifications[id]
+	n.LastError = lastError
+	n.NextAttemptAt = retryAt
+	if dead {
+		n.Status = models.NotificationDead
+	}
+	return nil
+}
+
+type failingNotifier struct {
+	failTo string
+}
+
+func (f *failingNotifier) Send(msg models.Message) error {
+	if msg.To == f.failTo {
+		return errors.New("mailbox unavailable")
+	}
+	return nil
+}
+
+func TestWorker(t *testing.T) {
+	store := &memoryStore{notifications: map[string]*models.Notification{
+		"ok":   {ID: "ok", Status: models.NotificationPending, Message: models.Message{To: "ok@example.com"}},
+		"fail": {ID: "fail", Status: models.NotificationPending, Message: models.Message{To: "fail@example.com"}},
+	}}
+	worker := NewWorker(store, &failingNotifier{failTo: "fail@example.com"}, models.OutboxConfig{
+		Workers:     2,
+		MaxAttempts: 3,
+		RetryBase:   time.Second,
+		RetryMax:    time.Minute,
+		Lease:       time.Minute,
+	})
+
+	require.Equal(t, 2, worker.Drain())
+	assert.Equal(t, models.NotificationSent, store.notifications["ok"].Status)
+	failed := store.notifications["fail"]
+	assert.Equal(t, models.NotificationPending, failed.Status, "уведомление не будет повторено")
+	assert.Equal(t, "mailbox unavailable", failed.LastError)
+	assert.True(t, failed.NextAttemptAt.After(time.Now()), "повтор без задержки")
+
+	for i := 0; i < 2; i++ {
+		failed.NextAttemptAt = time.Now()
+		require.Equal(t, 1, worker.Drain())
+	}
+	assert.Equal(t, models.NotificationDead, failed.Status, "уведомление не попало в dead letter")
+	assert.Equal(t, 0, worker.Drain())
+}
+
+func TestBackoff(t *testing.T) {
+	worker := NewWorker(nil, nil, models.OutboxConfig{RetryBase: time.Second, RetryMax: 10 * time.Second})
+	tests := []struct {
+		attempts int
+		minDelay time.Duration
+	}{
+		{attempts: 1, minDelay: time.Second},
+		{attempts: 2, minDelay: 2 * time.Second},
+		{attempts: 3, minDelay: 4 * time.Second},
+		{attempts: 10, minDelay: 10 * time.Second},
+	}
+	for _, test := range tests {
+		delay := worker.backoff(test.attempts)
+		assert.GreaterOrEqual(t, delay, test.minDelay)
+		assert.LessOrEqual(t, delay, test.minDelay+test.minDelay/5)
+	}
+}
@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/models"
+)
+
+const pruneInterval = time.Minute
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	// a full bucket is the same as no bucket
+	fullAt time.Time
+}
+
+// Memory keeps buckets in the process, so every replica has its own limits
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]*bucket), lastPrune: time.Now()}
+}
+
+func (m *Memory) Take(keys []string, limit models.RateLimit) (Result, error) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Sub(m.lastPrune) > pruneInterval {
+		m.prune(now)
+	}
+
+	buckets := make([]*bucket, len(keys))
+	allowed := true
+	for i, key := range keys {
+		b, ok := m.buckets[key]
+		if !ok {
+			b = &bucket{tokens: float64(limit.Requests)}
+			m.buckets[key] = b
+		} else {
+			b.tokens += now.Sub(b.updatedAt).Seconds() * rate(limit)
+			if b.tokens > float64(limit.Requests) {
+				b.tokens = float64(limit.Requests)
+			}
+		}
+		b.updatedAt = now
+		if b.tokens < 1 {
+			allowed = false
+		}
+		buckets[i] = b
+	}
+
+	results := make([]Result, len(buckets))
+	for i, b := range buckets {
+		if allowed {
+			b.tokens--
+		}
+		results[i] = newResult(limit, b.tokens, allowed || b.tokens >= 1)
+		b.fullAt = now.Add(results[i].Reset)
+	}
+	return tightest(results), nil
+}
+
+func (m *Memory) prune(now time.Time) {
+	for key, b := range m.buckets {
+		if !b.fullAt.After(now) {
+			delete(m.buckets, key)
+		}
+	}
+	m.lastPrune = now
+}
@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/utils"
+	logger "github.com/sirupsen/logrus"
+)
+
+// Middleware limits requests to the configured paths separately by client address, guid and client id.
+// A request takes a token from every bucket it belongs to and is refused without taking any
+// if one of them is empty, so refused requests do not drain the other buckets.
+func Middleware(limiter Limiter, routes map[string]models.RateLimit) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			route := req.URL.Path
+			limit, ok := routes[route]
+			if !ok || limit.Requests <= 0 || limit.Period <= 0 {
+				next.ServeHTTP(res, req)
+				return
+			}
+
+			tightest, err := limiter.Take(requestKeys(req, route), limit)
+			if err != nil {
+				// a broken limiter must not take the service down
+				logger.Error(err)
+				next.ServeHTTP(res, req)
+				return
+			}
+
+			res.Header().Set("RateLimit-Limit", strconv.Itoa(tightest.Limit))
+			res.Header().Set("RateLimit-Remaining", strconv.Itoa(tightest.Remaining))
+			res.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(tightest.Reset)))
+			if !tightest.Allowed {
+				logger.Warn("rate limit exceeded on " + route + " by " + utils.ClientIP(req))
+				res.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(tightest.RetryAfter)))
+				res.Header().Set("Content-Type", "application/json")
+				res.WriteHeader(http.StatusTooManyRequests)
+				json.NewEncoder(res).Encode(map[string]string{"error": "too_many_requests"})
+				return
+			}
+			next.ServeHTTP(res, req)
+		})
+	}
+}
+
+func requestKeys(req *http.Request, route string) []string {
+	keys := []string{"ip:" + route + ":" + utils.ClientIP(req)}
+	if guid := req.FormValue("guid"); guid != "" {
+		keys = append(keys, "guid:"+route+":"+guid)
+	}
+	if clientID := requestClientID(req); clientID != "" {
+		keys = append(keys, "client:"+route+":"+clientID)
+	}
+	return keys
+}
+
+func requestClientID(req *http.Request) string {
+	if id, _, ok := req.BasicAuth(); ok {
+		if decodedID, err := url.QueryUnescape(id); err == nil {
+			return decodedID
+		}
+		return id
+	}
+	return req.FormValue("client_id")
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
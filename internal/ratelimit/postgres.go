@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/models"
+	logger "github.com/sirupsen/logrus"
+)
+
+type Store interface {
+	TakeRateTokens(keys []string, rate float64, burst int) ([]float64, bool, error)
+	PruneRateLimits(idle time.Duration) (int64, error)
+}
+
+// Postgres shares buckets between replicas through the database
+type Postgres struct {
+	store Store
+	// buckets unused for longer are full and can be deleted
+	idle      time.Duration
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+func NewPostgres(store Store, idle time.Duration) *Postgres {
+	if idle < pruneInterval {
+		idle = pruneInterval
+	}
+	return &Postgres{store: store, idle: idle, lastPrune: time.Now()}
+}
+
+func (p *Postgres) Take(keys []string, limit models.RateLimit) (Result, error) {
+	p.mu.Lock()
+	if time.Since(p.lastPrune) > p.idle {
+		p.lastPrune = time.Now()
+		go p.prune()
+	}
+	p.mu.Unlock()
+
+	tokens, allowed, err := p.store.TakeRateTokens(keys, rate(limit), limit.Requests)
+	if err != nil {
+		return Result{}, err
+	}
+	results := make([]Result, len(tokens))
+	for i, t := range tokens {
+		results[i] = newResult(limit, t, allowed || t >= 1)
+	}
+	return tightest(results), nil
+}
+
+func (p *Postgres) prune() {
+	pruned, err := p.store.PruneRateLimits(p.idle)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	logger.Debug(fmt.Sprintf("%d idle rate limits deleted", pruned))
+}
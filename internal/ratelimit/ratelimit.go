@@ -0,0 +1,89 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/models"
+)
+
+var ErrUnknownLimiter = errors.New("unknown rate limiter")
+
+// Limiter keeps a token bucket per key.
+// Take takes a token from every bucket of the keys only if none of them is empty
+// and returns the result of the tightest one.
+type Limiter interface {
+	Take(keys []string, limit models.RateLimit) (Result, error)
+}
+
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// how long to wait for the next token
+	RetryAfter time.Duration
+	// how long until the bucket is full again
+	Reset time.Duration
+}
+
+func New(config models.RateLimitConfig, store Store) (Limiter, error) {
+	switch config.Backend {
+	case "memory":
+		return NewMemory(), nil
+	case "postgres":
+		var idle time.Duration
+		for _, limit := range config.Routes {
+			if limit.Period > idle {
+				idle = limit.Period
+			}
+		}
+		return NewPostgres(store, idle), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownLimiter, config.Backend)
+}
+
+// rate is the number of tokens added per second
+func rate(limit models.RateLimit) float64 {
+	return float64(limit.Requests) / limit.Period.Seconds()
+}
+
+func newResult(limit models.RateLimit, tokens float64, allowed bool) Result {
+	result := Result{
+		Allowed:   allowed,
+		Limit:     limit.Requests,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     seconds((float64(limit.Requests) - tokens) / rate(limit)),
+	}
+	if !allowed {
+		result.RetryAfter = seconds((1 - tokens) / rate(limit))
+	}
+	return result
+}
+
+// tightest picks the result the client should be told about
+func tightest(results []Result) Result {
+	var result Result
+	for i, r := range results {
+		if i == 0 || tighter(r, result) {
+			result = r
+		}
+	}
+	return result
+}
+
+// tighter reports whether a is the result the client should be told about instead of b
+func tighter(a, b Result) bool {
+	if a.Allowed != b.Allowed {
+		return !a.Allowed
+	}
+	if !a.Allowed {
+		return a.RetryAfter > b.RetryAfter
+	}
+	return a.Remaining < b.Remaining
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	limiter := NewMemory()
+	limit := models.RateLimit{Requests: 2, Period: time.Minute}
+
+	result, err := limiter.Take([]string{"key"}, limit)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 1, result.Remaining)
+	result, err = limiter.Take([]string{"key"}, limit)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+
+	result, err = limiter.Take([]string{"key"}, limit)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed, "запрос сверх лимита пропущен")
+	assert.InDelta(t, 30, result.RetryAfter.Seconds(), 1, "неверное время ожидания токена")
+	assert.InDelta(t, 60, result.Reset.Seconds(), 1, "неверное время восстановления лимита")
+
+	result, err = limiter.Take([]string{"other"}, limit)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed, "ключи делят общий лимит")
+
+	// half a period refills one token
+	limiter.buckets["key"].updatedAt = limiter.buckets["key"].updatedAt.Add(-30 * time.Second)
+	result, err = limiter.Take([]string{"key"}, limit)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed, "токен не восстановился со временем")
+
+	// a refused request does not take tokens from the other buckets
+	result, err = limiter.Take([]string{"third", "key"}, limit)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed, "запрос с пустым бакетом пропущен")
+	assert.Equal(t, float64(limit.Requests), limiter.buckets["third"].tokens, "отклоненный запрос израсходовал токен")
+}
+
+func TestMiddleware(t *testing.T) {
+	routes := map[string]models.RateLimit{"/login": {Requests: 1, Period: time.Minute}}
+	handler := Middleware(NewMemory(), routes)(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		id             int
+		url            string
+		remoteAddr     string
+		wantStatusCode int
+	}{
+		{
+			id:             1,
+			url:            "/login?guid=a",
+			remoteAddr:     "192.0.2.1:1234",
+			wantStatusCode: 200,
+		},
+		{
+			id:             2,
+			url:            "/login?guid=b",
+			remoteAddr:     "192.0.2.1:1234",
+			wantStatusCode: 429,
+		},
+		{
+			id:             3,
+			url:            "/login?guid=a",
+			remoteAddr:     "192.0.2.2:1234",
+			wantStatusCode: 429,
+		},
+		{
+			id:             4,
+			url:            "/login?guid=c",
+			remoteAddr:     "192.0.2.3:1234",
+			wantStatusCode: 200,
+		},
+		{
+			id:             5,
+			url:            "/refresh?guid=a",
+			remoteAddr:     "192.0.2.1:1234",
+			wantStatusCode: 200,
+		},
+	}
+	for _, test := range tests {
+		fmt.Printf("Тест id: %v\n", test.id)
+		req := httptest.NewRequest("GET", test.url, nil)
+		req.RemoteAddr = test.remoteAddr
+		resRecorder := httptest.NewRecorder()
+		handler.ServeHTTP(resRecorder, req)
+
+		require.Equal(t, test.wantStatusCode, resRecorder.Code, "статус код не соответствует ожидаемому")
+		switch test.wantStatusCode {
+		case 429:
+			assert.Equal(t, "60", resRecorder.Header().Get("Retry-After"), "заголовок Retry-After не соответствует")
+			assert.Equal(t, "0", resRecorder.Header().Get("RateLimit-Remaining"))
+		case 200:
+			if test.url != "/refresh?guid=a" {
+				assert.Equal(t, "1", resRecorder.Header().Get("RateLimit-Limit"))
+				assert.Equal(t, "0", resRecorder.Header().Get("RateLimit-Remaining"))
+			} else {
+				assert.Empty(t, resRecorder.Header().Get("RateLimit-Limit"), "ограничен маршрут без лимита")
+			}
+		}
+	}
+}
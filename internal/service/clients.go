@@ -0,0 +1,272 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/utils"
+	logger "github.com/sirupsen/logrus"
+)
+
+var ErrInvalidClientMetadata = errors.New("invalid client metadata")
+var ErrInvalidClientRedirectURI = errors.New("invalid redirect_uri")
+
+// CreateClient registers the client, a secret is generated for confidential clients unless one is given.
+// The returned client holds the plain secret, only its hash is stored.
+func (s *ServiceStruct) CreateClient(client models.Client) (models.Client, error) {
+	err := normalizeClient(&client)
+	if err != nil {
+		return models.Client{}, err
+	}
+	if client.ID == "" {
+		client.ID, err = utils.NewUUID()
+		if err != nil {
+			return models.Client{}, err
+		}
+	}
+	client.SecretHash = ""
+	if usesSecret(client.AuthMethod) {
+		if client.Secret == "" {
+			client.Secret, err = utils.CreateLink()
+			if err != nil {
+				return models.Client{}, err
+			}
+		}
+		client.SecretHash = utils.HashToken(client.Secret)
+	} else {
+		client.Secret = ""
+	}
+	err = s.DB.CreateClient(client)
+	if err != nil {
+		return models.Client{}, err
+	}
+	return client, nil
+}
+
+func (s *ServiceStruct) GetClient(id string) (models.Client, error) {
+	return s.DB.GetClient(id)
+}
+
+func (s *ServiceStruct) ListClients() ([]models.Client, error) {
+	return s.DB.ListClients()
+}
+
+// UpdateClient replaces the metadata of the client. A client switched to a secret
+// based method without having a secret gets one, it is returned like by CreateClient.
+func (s *ServiceStruct) UpdateClient(client models.Client) (models.Client, error) {
+	current, err := s.DB.GetClient(client.ID)
+	if err != nil {
+		return models.Client{}, err
+	}
+	err = normalizeClient(&client)
+	if err != nil {
+		return models.Client{}, err
+	}
+	client.CreatedAt = current.CreatedAt
+	client.Secret = ""
+	client.SecretHash = current.SecretHash
+	client.RegistrationTokenHash = current.RegistrationTokenHash
+	err = s.DB.UpdateClient(client)
+	if err != nil {
+		return models.Client{}, err
+	}
+	switch {
+	case usesSecret(client.AuthMethod) && current.SecretHash == "":
+		client.Secret, err = s.RotateClientSecret(client.ID)
+		if err != nil {
+			return models.Client{}, err
+		}
+	case !usesSecret(client.AuthMethod) && current.SecretHash != "":
+		err = s.DB.SetClientSecret(client.ID, "")
+		if err != nil {
+			return models.Client{}, err
+		}
+	}
+	client.SecretHash = ""
+	return client, nil
+}
+
+// RotateClientSecret replaces the secret at once, the old one stops working
+func (s *ServiceStruct) RotateClientSecret(id string) (string, error) {
+	client, err := s.DB.GetClient(id)
+	if err != nil {
+		return "", err
+	}
+	if !usesSecret(client.AuthMethod) {
+		return "", fmt.Errorf("%w: %s clients have no secret", ErrInvalidClientMetadata, client.AuthMethod)
+	}
+	secret, err := utils.CreateLink()
+	if err != nil {
+		return "", err
+	}
+	err = s.DB.SetClientSecret(id, utils.HashToken(secret))
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// DeleteClient removes the client and revokes the sessions issued to it
+func (s *ServiceStruct) DeleteClient(id string) error {
+	return s.DB.DeleteClient(id)
+}
+
+// FindClient looks the client up without authenticating it
+func (s *ServiceStruct) FindClient(id string) (models.Client, error) {
+	if id == "" {
+		return models.Client{}, ErrInvalidClient
+	}
+	client, err := s.DB.GetClient(id)
+	if errors.Is(err, database.ErrClientNotFound) {
+		return models.Client{}, fmt.Errorf("%w: %w", ErrInvalidClient, err)
+	}
+	return client, err
+}
+
+// AuthenticateClient accepts only the authentication method registered for the client
+func (s *ServiceStruct) AuthenticateClient(credentials models.ClientCredentials) (models.Client, error) {
+	if credentials.ID == "" && credentials.Method == models.AuthPrivateKeyJWT {
+		credentials.ID = utils.AssertionSubject(credentials.Assertion)
+	}
+	client, err := s.FindClient(credentials.ID)
+	if err != nil {
+		return models.Client{}, err
+	}
+	if credentials.Method != client.AuthMethod {
+		return models.Client{}, fmt.Errorf("%w: %s used instead of %s", ErrInvalidClient, credentials.Method,
+			client.AuthMethod)
+	}
+	switch client.AuthMethod {
+	case models.AuthNone:
+		return client, nil
+	case models.AuthClientSecretBasic, models.AuthClientSecretPost:
+		if client.SecretHash == "" || !utils.CompareTokenHash(credentials.Secret, client.SecretHash) {
+			return models.Client{}, ErrInvalidClient
+		}
+		return client, nil
+	case models.AuthPrivateKeyJWT:
+		err = s.checkClientAssertion(client, credentials)
+		if err != nil {
+			return models.Client{}, err
+		}
+		return client, nil
+	}
+	return models.Client{}, ErrInvalidClient
+}
+
+// checkClientAssertion validates the private_key_jwt assertion and accepts each jti once.
+// The audience may be the issuer, the public URL or the endpoint the assertion was sent to.
+func (s *ServiceStruct) checkClientAssertion(client models.Client, credentials models.ClientCredentials) error {
+	if client.JWKS == nil {
+		return ErrInvalidClient
+	}
+	audiences := []string{os.Getenv("JWT_ISSUER")}
+	if s.Accounts.PublicURL != "" {
+		audiences = append(audiences, s.Accounts.PublicURL, s.Accounts.PublicURL+credentials.Endpoint)
+	}
+	claims, err := utils.ValidateClientAssertion(credentials.Assertion, client.ID, *client.JWKS, audiences)
+	if err != nil {
+		logger.Debug(err)
+		return fmt.Errorf("%w: %w", ErrInvalidClient, err)
+	}
+	fresh, err := s.DB.UseClientAssertion(client.ID, claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return fmt.Errorf("%w: client assertion has been used already", ErrInvalidClient)
+	}
+	return nil
+}
+
+// normalizeClient fills the defaults of the authentication method and checks that the metadata fit together
+func normalizeClient(client *models.Client) error {
+	switch client.Type {
+	case "":
+		client.Type = models.ClientConfidential
+		if client.AuthMethod == models.AuthNone {
+			client.Type = models.ClientPublic
+		}
+	case models.ClientPublic, models.ClientConfidential:
+	default:
+		return fmt.Errorf("%w: client_type %s", ErrInvalidClientMetadata, client.Type)
+	}
+	if client.AuthMethod == "" {
+		client.AuthMethod = models.AuthClientSecretBasic
+		if client.Type == models.ClientPublic {
+			client.AuthMethod = models.AuthNone
+		}
+	}
+	switch client.AuthMethod {
+	case models.AuthNone:
+		if client.Type != models.ClientPublic {
+			return fmt.Errorf("%w: confidential clients must authenticate", ErrInvalidClientMetadata)
+		}
+	case models.AuthClientSecretBasic, models.AuthClientSecretPost, models.AuthPrivateKeyJWT:
+		if client.Type == models.ClientPublic {
+			return fmt.Errorf("%w: public clients authenticate with none", ErrInvalidClientMetadata)
+		}
+	default:
+		return fmt.Errorf("%w: token_endpoint_auth_method %s", ErrInvalidClientMetadata, client.AuthMethod)
+	}
+	if client.AuthMethod == models.AuthPrivateKeyJWT {
+		if client.JWKS == nil || len(client.JWKS.Keys) == 0 {
+			return fmt.Errorf("%w: private_key_jwt requires jwks", ErrInvalidClientMetadata)
+		}
+		for _, key := range client.JWKS.Keys {
+			_, err := utils.ParseJWK(key)
+			if err != nil {
+				return fmt.Errorf("%w: %w", ErrInvalidClientMetadata, err)
+			}
+		}
+	} else {
+		client.JWKS = nil
+	}
+
+	for _, grant := range client.GrantTypes {
+		switch grant {
+		case models.GrantAuthorizationCode, models.GrantRefreshToken:
+		case models.GrantClientCredentials:
+			if client.Type == models.ClientPublic {
+				return fmt.Errorf("%w: public clients can not use client_credentials", ErrInvalidClientMetadata)
+			}
+		default:
+			return fmt.Errorf("%w: grant type %s", ErrInvalidClientMetadata, grant)
+		}
+	}
+	if slices.Contains(client.GrantTypes, models.GrantAuthorizationCode) && len(client.RedirectURIs) == 0 {
+		return fmt.Errorf("%w: %w, authorization_code requires redirect_uris", ErrInvalidClientMetadata,
+			ErrInvalidClientRedirectURI)
+	}
+	for _, redirectURI := range client.RedirectURIs {
+		parsed, err := url.Parse(redirectURI)
+		if err != nil || !parsed.IsAbs() || parsed.Fragment != "" {
+			return fmt.Errorf("%w: %w %s, it must be absolute without fragment", ErrInvalidClientMetadata,
+				ErrInvalidClientRedirectURI, redirectURI)
+		}
+	}
+	if client.AccessTokenTTL < 0 || client.RefreshTokenTTL < 0 {
+		return fmt.Errorf("%w: token lifetimes can not be negative", ErrInvalidClientMetadata)
+	}
+	if client.AccessTokenTTL > 0 {
+		maxTTL, err := utils.AccessTokenTTL()
+		if err != nil {
+			return err
+		}
+		// retired signing keys are kept only as long as ATEXPIRES
+		if seconds(client.AccessTokenTTL) > maxTTL {
+			return fmt.Errorf("%w: access_token_ttl can not exceed %d seconds", ErrInvalidClientMetadata,
+				int(maxTTL.Seconds()))
+		}
+	}
+	return nil
+}
+
+func usesSecret(authMethod string) bool {
+	return authMethod == models.AuthClientSecretBasic || authMethod == models.AuthClientSecretPost
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type clientsDB struct {
+	database.DBInterface
+	clients    map[string]models.Client
+	assertions map[string]bool
+}
+
+func (db *clientsDB) CreateClient(client models.Client) error {
+	if _, ok := db.clients[client.ID]; ok {
+		return database.ErrClientExists
+	}
+	client.Secret = ""
+	db.clients[client.ID] = client
+	return nil
+}
+
+func (db *clientsDB) GetClient(id string) (models.Client, error) {
+	client, ok := db.clients[id]
+	if !ok {
+		return models.Client{}, database.ErrClientNotFound
+	}
+	return client, nil
+}
+
+func (db *clientsDB) UpdateClient(client models.Client) error {
+	if _, ok := db.clients[client.ID]; !ok {
+		return database.ErrClientNotFound
+	}
+	db.clients[client.ID] = client
+	return nil
+}
+
+func (db *clientsDB) DeleteClient(id string) error {
+	delete(db.clients, id)
+	return nil
+}
+
+func (db *clientsDB) UseClientAssertion(clientID, jti string, expiresAt time.Time) (bool, error) {
+	if db.assertions[clientID+jti] {
+		return false, nil
+	}
+	db.assertions[clientID+jti] = true
+	return true, nil
+}
+
+func TestCreateClient(t *testing.T) {
+	t.Setenv("ATEXPIRES", "60")
+	s := New(&clientsDB{clients: map[string]models.Client{}}, nil)
+
+	_, err := s.CreateClient(models.Client{Type: models.ClientPublic, GrantTypes: []string{models.GrantClientCredentials}})
+	assert.ErrorIs(t, err, ErrInvalidClientMetadata, "публичному клиенту разрешен client_credentials")
+	_, err = s.CreateClient(models.Client{Type: models.ClientConfidential, AuthMethod: models.AuthNone})
+	assert.ErrorIs(t, err, ErrInvalidClientMetadata, "конфиденциальный клиент без аутентификации")
+	_, err = s.CreateClient(models.Client{AuthMethod: models.AuthPrivateKeyJWT})
+	assert.ErrorIs(t, err, ErrInvalidClientMetadata, "private_key_jwt без ключей")
+	_, err = s.CreateClient(models.Client{GrantTypes: []string{models.GrantAuthorizationCode},
+		RedirectURIs: []string{"/cb"}})
+	assert.ErrorIs(t, err, ErrInvalidClientMetadata, "принят относительный redirect_uri")
+	_, err = s.CreateClient(models.Client{AccessTokenTTL: 120})
+	assert.ErrorIs(t, err, ErrInvalidClientMetadata, "токен живет дольше ключа подписи")
+
+	client, err := s.CreateClient(models.Client{Type: models.ClientPublic})
+	require.NoError(t, err)
+	assert.Equal(t, models.AuthNone, client.AuthMethod)
+	assert.Empty(t, client.Secret)
+
+	client, err = s.CreateClient(models.Client{ID: "app"})
+	require.NoError(t, err)
+	assert.Equal(t, models.ClientConfidential, client.Type)
+	assert.Equal(t, models.AuthClientSecretBasic, client.AuthMethod)
+	assert.NotEmpty(t, client.Secret)
+	assert.NotEqual(t, client.Secret, client.SecretHash, "секрет хранится в открытом виде")
+}
+
+func TestAuthenticateClient(t *testing.T) {
+	t.Setenv("JWT_ISSUER", "tt-auth")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signer, err := utils.NewKeySigner(jwt.SigningMethodES256, key)
+	require.NoError(t, err)
+	jwk, _ := signer.PublicJWK()
+
+	db := &clientsDB{clients: map[string]models.Client{}, assertions: map[string]bool{}}
+	s := New(db, nil)
+	s.Accounts.PublicURL = "https://auth.example.com"
+	app, err := s.CreateClient(models.Client{ID: "app", AuthMethod: models.AuthClientSecretPost})
+	require.NoError(t, err)
+	_, err = s.CreateClient(models.Client{ID: "service", AuthMethod: models.AuthPrivateKeyJWT,
+		JWKS: &models.JWKSet{Keys: []models.JWK{jwk}}})
+	require.NoError(t, err)
+
+	_, err = s.AuthenticateClient(models.ClientCredentials{ID: "app", Secret: app.Secret,
+		Method: models.AuthClientSecretPost})
+	assert.NoError(t, err)
+	_, err = s.AuthenticateClient(models.ClientCredentials{ID: "app", Secret: app.Secret,
+		Method: models.AuthClientSecretBasic})
+	assert.ErrorIs(t, err, ErrInvalidClient, "принят незарегистрированный метод")
+	_, err = s.AuthenticateClient(models.ClientCredentials{ID: "app", Secret: "wrong", Method: models.AuthClientSecretPost})
+	assert.ErrorIs(t, err, ErrInvalidClient)
+
+	assertion := func(audience string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+			Issuer:    "service",
+			Subject:   "service",
+			Audience:  jwt.ClaimStrings{audience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			ID:        audience + time.Now().String(),
+		})
+		token.Header["kid"] = signer.KeyID()
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		return signed
+	}
+	credentials := models.ClientCredentials{Method: models.AuthPrivateKeyJWT, Endpoint: "/oauth/token",
+		Assertion: assertion("https://auth.example.com/oauth/token")}
+	client, err := s.AuthenticateClient(credentials)
+	require.NoError(t, err)
+	assert.Equal(t, "service", client.ID)
+	_, err = s.AuthenticateClient(credentials)
+	assert.ErrorIs(t, err, ErrInvalidClient, "утверждение принято повторно")
+
+	credentials.Assertion = assertion("https://other.example.com")
+	_, err = s.AuthenticateClient(credentials)
+	assert.ErrorIs(t, err, ErrInvalidClient, "принят чужой aud")
+}
+
+func TestRegisterClient(t *testing.T) {
+	db := &clientsDB{clients: map[string]models.Client{}}
+	s := New(db, nil)
+	s.Accounts.PublicURL = "https://auth.example.com"
+	metadata := models.Client{ID: "chosen", Secret: "chosen", RedirectURIs: []string{"https://app.example.com/cb"},
+		AccessTokenTTL: 86400, FirstParty: true}
+
+	_, err := s.RegisterClient("initial", metadata)
+	assert.ErrorIs(t, err, ErrRegistrationDisabled)
+	s.OAuth.RegistrationToken = "initial"
+	s.OAuth.RegistrationScope = "read write"
+	_, err = s.RegisterClient("wrong", metadata)
+	assert.ErrorIs(t, err, ErrInvalidInitialToken)
+	_, err = s.RegisterClient("initial", models.Client{RedirectURIs: metadata.RedirectURIs, Scope: "admin"})
+	assert.ErrorIs(t, err, ErrInvalidClientMetadata, "зарегистрирована недоступная область")
+
+	registration, err := s.RegisterClient("initial", metadata)
+	require.NoError(t, err)
+	assert.NotEqual(t, "chosen", registration.ID, "клиент выбрал свой client_id")
+	assert.NotEqual(t, "chosen", registration.Secret, "клиент выбрал свой секрет")
+	assert.NotEmpty(t, registration.RegistrationAccessToken)
+	assert.Equal(t, "https://auth.example.com/register/client/"+registration.ID, registration.RegistrationClientURI)
+	assert.Equal(t, []string{models.GrantAuthorizationCode}, registration.GrantTypes)
+	assert.Equal(t, "read write", registration.Scope)
+	assert.Zero(t, db.clients[registration.ID].AccessTokenTTL, "клиент изменил срок жизни токенов")
+	assert.False(t, db.clients[registration.ID].FirstParty, "клиент объявил себя первой стороной")
+
+	_, err = s.GetClientRegistration(registration.ID, "initial")
+	assert.ErrorIs(t, err, ErrInvalidRegistrationToken)
+	_, err = s.GetClientRegistration("unknown", registration.RegistrationAccessToken)
+	assert.ErrorIs(t, err, ErrInvalidRegistrationToken)
+	read, err := s.GetClientRegistration(registration.ID, registration.RegistrationAccessToken)
+	require.NoError(t, err)
+	assert.Empty(t, read.Secret)
+	assert.Empty(t, read.RegistrationAccessToken)
+
+	update := read.Client
+	update.Name = "app"
+	update.Secret = "wrong"
+	_, err = s.UpdateClientRegistration(registration.ID, registration.RegistrationAccessToken, update)
+	assert.ErrorIs(t, err, ErrInvalidClientMetadata, "секрет изменен клиентом")
+	update.Secret = registration.Secret
+	updated, err := s.UpdateClientRegistration(registration.ID, registration.RegistrationAccessToken, update)
+	require.NoError(t, err)
+	assert.Equal(t, "app", updated.Name)
+	_, err = s.AuthenticateClient(models.ClientCredentials{ID: registration.ID, Secret: registration.Secret,
+		Method: models.AuthClientSecretBasic})
+	assert.NoError(t, err, "секрет потерян при обновлении")
+
+	require.NoError(t, s.DeleteClientRegistration(registration.ID, registration.RegistrationAccessToken))
+	assert.Empty(t, db.clients)
+}
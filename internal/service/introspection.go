@@ -0,0 +1,101 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/utils"
+)
+
+var ErrInvalidClient = errors.New("invalid client")
+
+const (
+	TokenTypeAccess  = "access_token"
+	TokenTypeRefresh = "refresh_token"
+)
+
+// Introspect describes the token as in RFC 7662. Tokens which can not be
+// validated or belong to revoked sessions are reported as inactive.
+func (s *ServiceStruct) Introspect(token, tokenTypeHint string) (models.Introspection, error) {
+	if tokenTypeHint == TokenTypeRefresh {
+		if introspection, err := s.introspectRT(token); err != nil || introspection.Active {
+			return introspection, err
+		}
+		return s.introspectAT(token)
+	}
+	if introspection, err := s.introspectAT(token); err != nil || introspection.Active {
+		return introspection, err
+	}
+	return s.introspectRT(token)
+}
+
+func (s *ServiceStruct) introspectAT(token string) (models.Introspection, error) {
+	claims, err := utils.ValidateAccessToken(token)
+	if err != nil {
+		return models.Introspection{}, nil
+	}
+	if claims.SessionID != "" {
+		_, err = s.DB.GetSessionByID(claims.SessionID)
+		if errors.Is(err, database.ErrUnauthorized) {
+			return models.Introspection{}, nil
+		}
+		if err != nil {
+			return models.Introspection{}, err
+		}
+	}
+	introspection := models.Introspection{
+		Active:    true,
+		TokenType: TokenTypeAccess,
+		Sub:       claims.Subject,
+		Iss:       claims.Issuer,
+		Jti:       claims.ID,
+		SessionID: claims.SessionID,
+		ClientID:  claims.ClientID,
+		Scope:     claims.Scope,
+	}
+	if claims.ExpiresAt != nil {
+		introspection.Exp = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		introspection.Iat = claims.IssuedAt.Unix()
+	}
+	return introspection, nil
+}
+
+func (s *ServiceStruct) introspectRT(token string) (models.Introspection, error) {
+	selector, verifier, err := utils.SplitRefreshToken(token)
+	if err != nil {
+		return models.Introspection{}, nil
+	}
+	session, err := s.DB.GetSession(selector)
+	if errors.Is(err, database.ErrUnauthorized) {
+		return models.Introspection{}, nil
+	}
+	if err != nil {
+		return models.Introspection{}, err
+	}
+	if !utils.CompareTokenHash(verifier, session.RTHash) || sessionExpired(session) {
+		return models.Introspection{}, nil
+	}
+	exp := session.ExpiresAt
+	if !session.IdleExpiresAt.IsZero() && session.IdleExpiresAt.Before(exp) {
+		exp = session.IdleExpiresAt
+	}
+	return models.Introspection{
+		Active:    true,
+		TokenType: TokenTypeRefresh,
+		Sub:       session.UserID,
+		Exp:       exp.Unix(),
+		Iat:       session.LastUsedAt.Unix(),
+		SessionID: session.ID,
+		ClientID:  session.ClientID,
+		Scope:     session.Scope,
+	}, nil
+}
+
+func sessionExpired(session models.Session) bool {
+	now := time.Now()
+	return now.After(session.ExpiresAt) || (!session.IdleExpiresAt.IsZero() && now.After(session.IdleExpiresAt))
+}
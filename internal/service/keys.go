@@ -0,0 +1,192 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/utils"
+	logger "github.com/sirupsen/logrus"
+)
+
+var ErrEmptyJWTSecret = errors.New("JWT_SECRET is empty")
+
+func isSymmetric(alg string) bool {
+	_, ok := jwt.GetSigningMethod(alg).(*jwt.SigningMethodHMAC)
+	return ok
+}
+
+// InitKeyRing loads the signing keys from the database. The first key is JWT_SECRET for HMAC,
+// for other algorithms it is taken from JWT_PRIVATE_KEY_FILE or generated.
+// Keys are stored encrypted with SIGNING_KEY_ENCRYPTION_KEY and are rotated alike.
+func (s *ServiceStruct) InitKeyRing(config models.KeyConfig) (*utils.KeyRing, error) {
+	keys, err := s.DB.ListSigningKeys()
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		var key models.SigningKey
+		if isSymmetric(config.Alg) {
+			// tokens signed with JWT_SECRET before keys were stored carry JWT_KID
+			logger.Info("importing signing key from JWT_SECRET")
+			secret := os.Getenv("JWT_SECRET")
+			if secret == "" {
+				return nil, ErrEmptyJWTSecret
+			}
+			key, err = utils.NewHMACSigningKey(config.Alg, []byte(secret), os.Getenv("JWT_KID"))
+			if err != nil {
+				return nil, err
+			}
+		} else if config.PrivateKeyFile != "" {
+			logger.Info("importing signing key from " + config.PrivateKeyFile)
+			keyPEM, err := os.ReadFile(config.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			privateKey, err := utils.ParsePrivateKeyPEM(keyPEM)
+			if err != nil {
+				return nil, err
+			}
+			key, err = utils.NewSigningKey(config.Alg, privateKey)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			logger.Info("generating signing key")
+			key, err = utils.GenerateSigningKey(config.Alg)
+			if err != nil {
+				return nil, err
+			}
+		}
+		key, err = sealSigningKey(key)
+		if err != nil {
+			return nil, err
+		}
+		err = s.DB.AddInitialSigningKey(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ring := utils.NewKeyRing()
+	err = s.ReloadKeyRing(ring)
+	if err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
+
+func (s *ServiceStruct) ReloadKeyRing(ring *utils.KeyRing) error {
+	keys, err := s.DB.ListSigningKeys()
+	if err != nil {
+		return err
+	}
+	for i := range keys {
+		keys[i], err = openSigningKey(keys[i])
+		if err != nil {
+			return err
+		}
+	}
+	ringKeys, err := utils.RingKeys(keys)
+	if err != nil {
+		return err
+	}
+	ring.Replace(ringKeys)
+	return nil
+}
+
+// RotateSigningKey generates a new key which is published right away
+// and starts signing after config.PublishAhead
+func (s *ServiceStruct) RotateSigningKey(config models.KeyConfig) (models.SigningKey, error) {
+	key, err := utils.GenerateSigningKey(config.Alg)
+	if err != nil {
+		return key, err
+	}
+	key.ActivatesAt = time.Now().Add(config.PublishAhead)
+	key, err = sealSigningKey(key)
+	if err != nil {
+		return key, err
+	}
+	err = s.DB.RotateSigningKey(key, config.VerifyFor)
+	if err != nil {
+		return key, err
+	}
+	logger.Info("signing key " + key.KID + " will be active at " + key.ActivatesAt.Format(time.RFC3339))
+	return key, nil
+}
+
+func (s *ServiceStruct) PruneSigningKeys() (int64, error) {
+	return s.DB.PruneSigningKeys()
+}
+
+// RunKeyRotation reloads the key ring periodically so that every replica
+// sees keys rotated by the others, and rotates the key when it is due.
+func (s *ServiceStruct) RunKeyRotation(ring *utils.KeyRing, config models.KeyConfig) {
+	if config.ReloadInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(config.ReloadInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if config.RotationPeriod > 0 {
+			err := s.rotateIfDue(config)
+			if err != nil {
+				logger.Error(err)
+			}
+		}
+		err := s.ReloadKeyRing(ring)
+		if err != nil {
+			logger.Error(err)
+		}
+	}
+}
+
+func (s *ServiceStruct) rotateIfDue(config models.KeyConfig) error {
+	keys, err := s.DB.ListSigningKeys()
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	// keys are ordered by activation, the last one is the active or the pending key
+	latest := keys[len(keys)-1]
+	if time.Now().Add(config.PublishAhead).Before(latest.ActivatesAt.Add(config.RotationPeriod)) {
+		return nil
+	}
+	_, err = s.RotateSigningKey(config)
+	if errors.Is(err, database.ErrKeyRotationPending) {
+		return nil
+	}
+	return err
+}
+
+// sealSigningKey encrypts the private key before it is stored
+func sealSigningKey(key models.SigningKey) (models.SigningKey, error) {
+	encrypted, err := utils.EncryptSigningKey(key.PrivateKey)
+	if err != nil {
+		return key, err
+	}
+	key.PrivateKey = encrypted
+	return key, nil
+}
+
+// openSigningKey decrypts a stored private key. Keys stored in PEM before they were
+// encrypted are still used until they are rotated out.
+func openSigningKey(key models.SigningKey) (models.SigningKey, error) {
+	if strings.HasPrefix(key.PrivateKey, "-----BEGIN") {
+		logger.Warn("signing key " + key.KID + " is stored unencrypted, rotate the keys to replace it")
+		return key, nil
+	}
+	privateKey, err := utils.DecryptSigningKey(key.PrivateKey)
+	if err != nil {
+		return key, fmt.Errorf("signing key %s: %w", key.KID, err)
+	}
+	key.PrivateKey = privateKey
+	return key, nil
+}
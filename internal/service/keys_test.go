@@ -0,0 +1,95 @@
+package service
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type keysDB struct {
+	database.DBInterface
+	keys []models.SigningKey
+}
+
+func (db *keysDB) ListSigningKeys() ([]models.SigningKey, error) {
+	return append([]models.SigningKey(nil), db.keys...), nil
+}
+
+func (db *keysDB) AddInitialSigningKey(key models.SigningKey) error {
+	db.keys = append(db.keys, key)
+	return nil
+}
+
+func (db *keysDB) RotateSigningKey(key models.SigningKey, verifyFor time.Duration) error {
+	db.keys = append(db.keys, key)
+	return nil
+}
+
+func TestSigningKeyEncryption(t *testing.T) {
+	t.Setenv("SIGNING_KEY_ENCRYPTION_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	config := models.KeyConfig{Alg: "ES256", PublishAhead: time.Minute}
+
+	db := &keysDB{}
+	s := New(db, nil)
+	ring, err := s.InitKeyRing(config)
+	require.NoError(t, err)
+	require.Len(t, db.keys, 1)
+	assert.NotContains(t, db.keys[0].PrivateKey, "PRIVATE KEY", "ключ хранится в открытом виде")
+	active, err := ring.Active()
+	require.NoError(t, err)
+	assert.Equal(t, db.keys[0].KID, active.KeyID())
+
+	_, err = s.RotateSigningKey(config)
+	require.NoError(t, err)
+	assert.NotContains(t, db.keys[1].PrivateKey, "PRIVATE KEY", "ключ хранится в открытом виде")
+
+	// keys stored before the encryption are still loaded
+	plain, err := utils.GenerateSigningKey("ES256")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(plain.PrivateKey, "-----BEGIN"))
+	db.keys = append(db.keys, plain)
+	assert.NoError(t, s.ReloadKeyRing(ring))
+
+	t.Setenv("SIGNING_KEY_ENCRYPTION_KEY", "")
+	assert.ErrorIs(t, s.ReloadKeyRing(ring), utils.ErrSigningEncryptionKey)
+}
+
+func TestHMACKeyRotation(t *testing.T) {
+	t.Setenv("SIGNING_KEY_ENCRYPTION_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	t.Setenv("JWT_SECRET", "jwt_secret")
+	t.Setenv("JWT_KID", "legacy")
+	t.Setenv("ATEXPIRES", "60")
+	config := models.KeyConfig{Alg: "HS512"}
+
+	db := &keysDB{}
+	s := New(db, nil)
+	ring, err := s.InitKeyRing(config)
+	require.NoError(t, err)
+	require.Len(t, db.keys, 1)
+	assert.Equal(t, "legacy", db.keys[0].KID, "JWT_SECRET импортирован не под JWT_KID")
+	assert.NotContains(t, db.keys[0].PrivateKey, base64.StdEncoding.EncodeToString([]byte("jwt_secret")),
+		"секрет хранится в открытом виде")
+	utils.SetKeyRing(ring)
+	defer utils.SetKeyRing(nil)
+
+	aToken, err := utils.NewAccessToken(&models.Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "guid"}}, 0)
+	require.NoError(t, err)
+
+	_, err = s.RotateSigningKey(config)
+	require.NoError(t, err)
+	require.NoError(t, s.ReloadKeyRing(ring))
+	active, err := ring.Active()
+	require.NoError(t, err)
+	assert.NotEqual(t, "legacy", active.KeyID(), "новый ключ не подписывает токены")
+
+	_, err = utils.ValidateAccessToken(aToken)
+	assert.NoError(t, err, "токен прежнего ключа не проверился после ротации")
+}
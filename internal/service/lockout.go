@@ -0,0 +1,244 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/notifier"
+	logger "github.com/sirupsen/logrus"
+)
+
+var ErrTooManyAttempts = errors.New("too many failed attempts, retry later")
+var ErrTemporarilyLocked = errors.New("temporarily locked after too many failed attempts")
+
+// AttemptError carries how long the client has to wait before the next attempt
+type AttemptError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *AttemptError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *AttemptError) Unwrap() error {
+	return e.Err
+}
+
+var defaultLockout = models.LockoutConfig{
+	Threshold:    10,
+	IPThreshold:  50,
+	FreeAttempts: 3,
+	DelayBase:    time.Second,
+	DelayMax:     time.Minute,
+	Cooldown:     15 * time.Minute,
+	Window:       time.Hour,
+}
+
+func (s *ServiceStruct) lockout() models.LockoutConfig {
+	config := s.Lockout
+	if config.Threshold <= 0 {
+		config.Threshold = defaultLockout.Threshold
+	}
+	if config.IPThreshold <= 0 {
+		config.IPThreshold = defaultLockout.IPThreshold
+	}
+	if config.FreeAttempts < 0 {
+		config.FreeAttempts = defaultLockout.FreeAttempts
+	}
+	if config.DelayBase <= 0 {
+		config.DelayBase = defaultLockout.DelayBase
+	}
+	if config.DelayMax <= 0 {
+		config.DelayMax = defaultLockout.DelayMax
+	}
+	if config.Cooldown <= 0 {
+		config.Cooldown = defaultLockout.Cooldown
+	}
+	if config.Window <= 0 {
+		config.Window = defaultLockout.Window
+	}
+	return config
+}
+
+func accountKey(guid string) string {
+	return "user:" + guid
+}
+
+func ipKey(clientIP string) string {
+	return "ip:" + clientIP
+}
+
+func attemptKeys(guid, clientIP string) []string {
+	var keys []string
+	if guid != "" {
+		keys = append(keys, accountKey(guid))
+	}
+	if clientIP != "" {
+		keys = append(keys, ipKey(clientIP))
+	}
+	return keys
+}
+
+// CheckAttempts refuses the attempt while the account or the address is locked
+// or has to wait after its last failure. Empty guid or clientIP is not checked.
+func (s *ServiceStruct) CheckAttempts(guid, clientIP string) error {
+	keys := attemptKeys(guid, clientIP)
+	if len(keys) == 0 {
+		return nil
+	}
+	attempts, err := s.DB.GetAttempts(keys)
+	if err != nil {
+		return err
+	}
+	config := s.lockout()
+	now := time.Now()
+	var retryErr *AttemptError
+	for _, a := range attempts {
+		if a.LockedUntil.After(now) {
+			return &AttemptError{Err: ErrTemporarilyLocked, RetryAfter: a.LockedUntil.Sub(now)}
+		}
+		if a.LastFailureAt.Before(now.Add(-config.Window)) || !a.LockedUntil.IsZero() {
+			// the counter has expired or the lock is over, it starts from zero on the next failure
+			continue
+		}
+		next := a.LastFailureAt.Add(attemptDelay(config, a.Failures))
+		if next.After(now) && (retryErr == nil || next.Sub(now) > retryErr.RetryAfter) {
+			retryErr = &AttemptError{Err: ErrTooManyAttempts, RetryAfter: next.Sub(now)}
+		}
+	}
+	if retryErr != nil {
+		return retryErr
+	}
+	return nil
+}
+
+// attemptDelay doubles the wait with every failure after the free ones
+func attemptDelay(config models.LockoutConfig, failures int) time.Duration {
+	extra := failures - config.FreeAttempts
+	if extra <= 0 {
+		return 0
+	}
+	delay := config.DelayBase
+	for i := 1; i < extra && delay < config.DelayMax; i++ {
+		delay *= 2
+	}
+	if delay > config.DelayMax {
+		delay = config.DelayMax
+	}
+	return delay
+}
+
+// FailedAttempt counts the failure for the account and the address and locks them
+// on reaching the threshold. The user is told about the lock by email.
+func (s *ServiceStruct) FailedAttempt(guid, clientIP string) error {
+	config := s.lockout()
+	if clientIP != "" {
+		a, err := s.DB.AddFailure(ipKey(clientIP), config.Window)
+		if err != nil {
+			return err
+		}
+		if a.Failures == config.IPThreshold {
+			logger.Warn("locking ip " + clientIP)
+			err = s.DB.LockKey(a.Key, time.Now().Add(config.Cooldown), nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if guid == "" {
+		return nil
+	}
+
+	a, err := s.DB.AddFailure(accountKey(guid), config.Window)
+	if err != nil {
+		return err
+	}
+	if a.Failures != config.Threshold {
+		return nil
+	}
+	logger.Warn("locking account " + guid)
+	lock := models.AccountLock{Until: time.Now().Add(config.Cooldown), ClientIP: clientIP}
+	var notification *models.Notification
+	mail, err := s.DB.SelectMail(guid)
+	if err == nil {
+		var msg models.Message
+		msg, err = notifier.Render(notifier.TemplateAccountLocked, mail, lock)
+		notification = &models.Notification{Template: notifier.TemplateAccountLocked, Message: msg}
+	}
+	if err != nil {
+		// the lock matters more than the email about it
+		logger.Error(err)
+		notification = nil
+	}
+	return s.DB.LockKey(a.Key, lock.Until, notification)
+}
+
+// failedAttempt records the failure for callers which report the original error anyway
+func (s *ServiceStruct) failedAttempt(guid, clientIP string) {
+	err := s.FailedAttempt(guid, clientIP)
+	if err != nil {
+		logger.Error(err)
+	}
+}
+
+// TakeAttempt lets the address make an attempt it can not prove to be its own beforehand,
+// like presenting a refresh token. The attempt counts as failed until FinishAttempt says otherwise.
+func (s *ServiceStruct) TakeAttempt(clientIP string) error {
+	a, taken, err := s.DB.TakeAttempt(ipKey(clientIP), s.lockout())
+	if err != nil {
+		return err
+	}
+	if taken {
+		return nil
+	}
+	now := time.Now()
+	if a.LockedUntil.After(now) {
+		return &AttemptError{Err: ErrTemporarilyLocked, RetryAfter: a.LockedUntil.Sub(now)}
+	}
+	return &AttemptError{Err: ErrTooManyAttempts, RetryAfter: a.LastFailureAt.Add(attemptDelay(s.lockout(), a.Failures)).Sub(now)}
+}
+
+// FinishAttempt settles an attempt taken with TakeAttempt, the address is locked on reaching the threshold
+func (s *ServiceStruct) FinishAttempt(clientIP string, failed bool) error {
+	key := ipKey(clientIP)
+	if !failed {
+		return s.DB.ForgiveAttempt(key)
+	}
+	a, err := s.DB.FailAttempt(key)
+	if err != nil {
+		return err
+	}
+	config := s.lockout()
+	if a.Failures < config.IPThreshold || a.LockedUntil.After(time.Now()) {
+		return nil
+	}
+	logger.Warn("locking ip " + clientIP)
+	return s.DB.LockKey(key, time.Now().Add(config.Cooldown), nil)
+}
+
+// SucceededAttempt forgets failures of the account, failures of the address stay
+func (s *ServiceStruct) SucceededAttempt(guid string) error {
+	return s.DB.ResetFailures(accountKey(guid))
+}
+
+// UnlockUser lifts both the temporary lock and the lock set by an administrator
+func (s *ServiceStruct) UnlockUser(guid string) error {
+	err := s.DB.ResetFailures(accountKey(guid))
+	if err != nil {
+		return err
+	}
+	user, err := s.DB.GetUser(guid)
+	if err != nil {
+		return err
+	}
+	if user.Status == models.UserLocked {
+		return s.DB.SetUserStatus(guid, models.UserActive)
+	}
+	return nil
+}
+
+func (s *ServiceStruct) UnlockIP(clientIP string) error {
+	return s.DB.ResetFailures(ipKey(clientIP))
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lockoutDB keeps the counters in memory
+type lockoutDB struct {
+	database.DBInterface
+	attempts      map[string]models.Attempts
+	notifications []models.Notification
+}
+
+func (db *lockoutDB) GetAttempts(keys []string) ([]models.Attempts, error) {
+	var attempts []models.Attempts
+	for _, key := range keys {
+		if a, ok := db.attempts[key]; ok {
+			attempts = append(attempts, a)
+		}
+	}
+	return attempts, nil
+}
+
+func (db *lockoutDB) AddFailure(key string, window time.Duration) (models.Attempts, error) {
+	a := db.attempts[key]
+	if a.LastFailureAt.Before(time.Now().Add(-window)) || (!a.LockedUntil.IsZero() && a.LockedUntil.Before(time.Now())) {
+		a = models.Attempts{}
+	}
+	a.Key = key
+	a.Failures++
+	a.LastFailureAt = time.Now()
+	db.attempts[key] = a
+	return a, nil
+}
+
+func (db *lockoutDB) LockKey(key string, until time.Time, notification *models.Notification) error {
+	a := db.attempts[key]
+	a.LockedUntil = until
+	db.attempts[key] = a
+	if notification != nil {
+		db.notifications = append(db.notifications, *notification)
+	}
+	return nil
+}
+
+func (db *lockoutDB) ResetFailures(key string) error {
+	delete(db.attempts, key)
+	return nil
+}
+
+// TakeAttempt follows the statement of the database
+func (db *lockoutDB) TakeAttempt(key string, config models.LockoutConfig) (models.Attempts, bool, error) {
+	now := time.Now()
+	a, ok := db.attempts[key]
+	if !ok || a.LastFailureAt.Before(now.Add(-config.Window)) || (!a.LockedUntil.IsZero() && !a.LockedUntil.After(now)) {
+		a = models.Attempts{Key: key, Failures: 1, LastFailureAt: now}
+		db.attempts[key] = a
+		return a, true, nil
+	}
+	if a.LockedUntil.After(now) || a.LastFailureAt.Add(attemptDelay(config, a.Failures)).After(now) {
+		return a, false, nil
+	}
+	a.Failures++
+	db.attempts[key] = a
+	return a, true, nil
+}
+
+func (db *lockoutDB) FailAttempt(key string) (models.Attempts, error) {
+	a := db.attempts[key]
+	a.LastFailureAt = time.Now()
+	db.attempts[key] = a
+	return a, nil
+}
+
+func (db *lockoutDB) ForgiveAttempt(key string) error {
+	a := db.attempts[key]
+	if a.Failures > 0 {
+		a.Failures--
+	}
+	db.attempts[key] = a
+	return nil
+}
+
+func (db *lockoutDB) SelectMail(guid string) (string, error) {
+	return "user@example.com", nil
+}
+
+func TestAttemptDelay(t *testing.T) {
+	config := models.LockoutConfig{FreeAttempts: 3, DelayBase: time.Second, DelayMax: 10 * time.Second}
+	for failures, want := range []time.Duration{0, 0, 0, 0, time.Second, 2 * time.Second, 4 * time.Second,
+		8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		assert.Equal(t, want, attemptDelay(config, failures), "неверная задержка после %d ошибок", failures)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	db := &lockoutDB{attempts: map[string]models.Attempts{}}
+	s := New(db, nil)
+	s.Lockout = models.LockoutConfig{Threshold: 3, IPThreshold: 100, FreeAttempts: 1, DelayBase: time.Hour,
+		DelayMax: time.Hour, Cooldown: time.Minute, Window: time.Hour}
+
+	require.NoError(t, s.FailedAttempt("user", "192.0.2.1"))
+	require.NoError(t, s.CheckAttempts("user", "192.0.2.1"), "первая ошибка не должна задерживать")
+
+	require.NoError(t, s.FailedAttempt("user", "192.0.2.1"))
+	err := s.CheckAttempts("user", "")
+	var attemptErr *AttemptError
+	require.True(t, errors.As(err, &attemptErr), "нет задержки после второй ошибки")
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+	assert.InDelta(t, time.Hour.Seconds(), attemptErr.RetryAfter.Seconds(), 5)
+
+	require.NoError(t, s.FailedAttempt("user", "192.0.2.1"))
+	err = s.CheckAttempts("user", "")
+	assert.ErrorIs(t, err, ErrTemporarilyLocked, "учетная запись не заблокирована")
+	require.Len(t, db.notifications, 1, "пользователь не уведомлен о блокировке")
+	assert.Equal(t, "user@example.com", db.notifications[0].Message.To)
+
+	// the lock is over after the cooldown
+	a := db.attempts[accountKey("user")]
+	a.LockedUntil = time.Now().Add(-time.Second)
+	db.attempts[accountKey("user")] = a
+	assert.NoError(t, s.CheckAttempts("user", ""), "блокировка не снята после ожидания")
+
+	require.NoError(t, s.SucceededAttempt("user"))
+	assert.NotContains(t, db.attempts, accountKey("user"), "счетчик не сброшен после входа")
+	assert.Contains(t, db.attempts, ipKey("192.0.2.1"), "счетчик адреса сброшен после входа")
+}
+
+func TestTakeAttempt(t *testing.T) {
+	db := &lockoutDB{attempts: map[string]models.Attempts{}}
+	s := New(db, nil)
+	s.Lockout = models.LockoutConfig{Threshold: 3, IPThreshold: 3, FreeAttempts: 1, DelayBase: time.Hour,
+		DelayMax: time.Hour, Cooldown: time.Minute, Window: time.Hour}
+
+	// attempts in flight count as failures, so concurrent ones can not all pass
+	require.NoError(t, s.TakeAttempt("192.0.2.1"))
+	require.NoError(t, s.TakeAttempt("192.0.2.1"))
+	err := s.TakeAttempt("192.0.2.1")
+	assert.ErrorIs(t, err, ErrTooManyAttempts, "одновременные попытки обошли задержку")
+
+	require.NoError(t, s.FinishAttempt("192.0.2.1", false))
+	require.NoError(t, s.FinishAttempt("192.0.2.1", true))
+	assert.Equal(t, 1, db.attempts[ipKey("192.0.2.1")].Failures, "успешная попытка не прощена")
+	assert.NotContains(t, db.attempts, accountKey("user"), "неудачи учтены для учетной записи")
+
+	a := db.attempts[ipKey("192.0.2.1")]
+	a.Failures = 3
+	db.attempts[ipKey("192.0.2.1")] = a
+	require.NoError(t, s.FinishAttempt("192.0.2.1", true))
+	err = s.TakeAttempt("192.0.2.1")
+	assert.ErrorIs(t, err, ErrTemporarilyLocked, "адрес не заблокирован")
+}
+
+// magicLockoutDB accepts only the link "link" opened with the nonce "nonce"
+type magicLockoutDB struct {
+	*lockoutDB
+}
+
+func (db magicLockoutDB) ConsumeBoundUserToken(purpose, hash, bindingHash string) (string, error) {
+	if hash != utils.HashToken("link") || bindingHash != utils.HashToken("nonce") {
+		return "", database.ErrTokenInvalid
+	}
+	return "user", nil
+}
+
+func TestMagicLinkLockout(t *testing.T) {
+	db := &lockoutDB{attempts: map[string]models.Attempts{}}
+	s := New(magicLockoutDB{db}, nil)
+	s.Lockout = models.LockoutConfig{Threshold: 3, IPThreshold: 100, FreeAttempts: 1, DelayBase: time.Hour,
+		DelayMax: time.Hour, Cooldown: time.Minute, Window: time.Hour}
+
+	_, err := s.MagicLinkLogin("wrong", "nonce", "192.0.2.1")
+	assert.ErrorIs(t, err, database.ErrTokenInvalid)
+	assert.Equal(t, 1, db.attempts[ipKey("192.0.2.1")].Failures, "неверная ссылка не учтена")
+
+	guid, err := s.MagicLinkLogin("link", "nonce", "192.0.2.2")
+	require.NoError(t, err)
+	assert.Equal(t, "user", guid)
+
+	db.attempts[accountKey("user")] = models.Attempts{Key: accountKey("user"), Failures: 3,
+		LastFailureAt: time.Now(), LockedUntil: time.Now().Add(time.Minute)}
+	_, err = s.MagicLinkLogin("link", "nonce", "192.0.2.2")
+	assert.ErrorIs(t, err, ErrTemporarilyLocked, "ссылка обходит блокировку учетной записи")
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/utils"
+	logger "github.com/sirupsen/logrus"
+)
+
+const defaultMagicLinkTTL = 10 * time.Minute
+const defaultMagicLinkLimit = 3
+const defaultMagicLinkWindow = 15 * time.Minute
+
+// MagicLink emails a one time login link and returns the nonce the browser has to keep in a cookie.
+// The nonce is returned for unknown emails too, so the response does not tell whether the user exists.
+func (s *ServiceStruct) MagicLink(email string) (string, error) {
+	nonce, err := utils.CreateLink()
+	if err != nil {
+		return "", err
+	}
+	user, err := s.DB.GetUserByEmail(strings.TrimSpace(email))
+	if errors.Is(err, database.ErrUserNotFound) {
+		logger.Debug("magic link for unknown email")
+		return nonce, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if user.Status != models.UserActive {
+		logger.Debug("magic link for inactive user")
+		return nonce, nil
+	}
+
+	token, notification, err := s.emailToken(models.TokenMagicLink, user.Email, "/login/magic/callback",
+		s.Accounts.MagicLinkTTL, defaultMagicLinkTTL)
+	if err != nil {
+		return "", err
+	}
+	token.UserID = user.ID
+	token.BindingHash = utils.HashToken(nonce)
+
+	limit := s.Accounts.MagicLinkLimit
+	if limit <= 0 {
+		limit = defaultMagicLinkLimit
+	}
+	window := s.Accounts.MagicLinkWindow
+	if window <= 0 {
+		window = defaultMagicLinkWindow
+	}
+	created, err := s.DB.CreateLimitedUserToken(token, notification, limit, window)
+	if err != nil {
+		return "", err
+	}
+	if !created {
+		logger.Warn("magic link rate limit reached")
+	}
+	return nonce, nil
+}
+
+// MagicLinkLogin consumes the link opened in the browser holding the nonce and returns the user.
+// The link does not get around the lockout of the address or the account.
+func (s *ServiceStruct) MagicLinkLogin(token, nonce, clientIP string) (string, error) {
+	err := s.CheckAttempts("", clientIP)
+	if err != nil {
+		return "", err
+	}
+	guid, err := s.DB.ConsumeBoundUserToken(models.TokenMagicLink, utils.HashToken(token), utils.HashToken(nonce))
+	if err != nil {
+		if errors.Is(err, database.ErrTokenInvalid) {
+			s.failedAttempt("", clientIP)
+		}
+		return "", err
+	}
+	err = s.CheckAttempts(guid, "")
+	if err != nil {
+		return "", err
+	}
+	return guid, nil
+}
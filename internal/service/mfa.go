@@ -0,0 +1,191 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/utils"
+	logger "github.com/sirupsen/logrus"
+)
+
+const RecoveryCodeCount = 10
+
+const defaultMFAChallengeTTL = 5 * time.Minute
+const defaultMFAMaxAttempts = 5
+
+var ErrInvalidMFACode = errors.New("invalid mfa code")
+
+func (s *ServiceStruct) EnrollTOTP(guid string) (models.TOTPEnrollment, error) {
+	user, err := s.DB.GetUser(guid)
+	if err != nil {
+		return models.TOTPEnrollment{}, err
+	}
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return models.TOTPEnrollment{}, err
+	}
+	encrypted, err := utils.EncryptSecret(secret)
+	if err != nil {
+		return models.TOTPEnrollment{}, err
+	}
+	err = s.DB.SaveTOTP(guid, encrypted)
+	if err != nil {
+		return models.TOTPEnrollment{}, err
+	}
+
+	account := user.Email
+	if account == "" {
+		account = user.Username
+	}
+	if account == "" {
+		account = user.ID
+	}
+	return models.TOTPEnrollment{Secret: secret, URI: utils.TOTPURI(s.Accounts.MFAIssuer, account, secret)}, nil
+}
+
+// ConfirmTOTP turns the authenticator on once the user proves it works
+// and returns the recovery codes, they are shown only this time
+func (s *ServiceStruct) ConfirmTOTP(guid, code string) ([]string, error) {
+	totp, err := s.DB.GetTOTP(guid)
+	if err != nil {
+		return nil, err
+	}
+	if totp.Confirmed {
+		return nil, database.ErrMFAAlreadyEnrolled
+	}
+	secret, err := utils.DecryptSecret(totp.Secret)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := utils.ValidateTOTP(secret, strings.TrimSpace(code), time.Now(), totp.LastUsedStep)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, err := utils.GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = utils.HashToken(code)
+	}
+	err = s.DB.ConfirmTOTP(guid, step, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// MFAChallenge returns a challenge token when the user has to enter the second factor
+// before getting tokens, and an empty string when the user has not enrolled
+func (s *ServiceStruct) MFAChallenge(guid string) (string, error) {
+	err := s.checkUser(guid)
+	if err != nil {
+		return "", err
+	}
+	totp, err := s.DB.GetTOTP(guid)
+	if errors.Is(err, database.ErrMFANotEnrolled) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if !totp.Confirmed {
+		return "", nil
+	}
+
+	ttl := s.Accounts.MFAChallengeTTL
+	if ttl <= 0 {
+		ttl = defaultMFAChallengeTTL
+	}
+	challenge, err := utils.CreateLink()
+	if err != nil {
+		return "", err
+	}
+	err = s.DB.CreateUserToken(models.UserToken{
+		UserID:    guid,
+		Purpose:   models.TokenMFAChallenge,
+		Hash:      utils.HashToken(challenge),
+		ExpiresAt: time.Now().Add(ttl),
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	return challenge, nil
+}
+
+// VerifyMFA completes the challenge with a TOTP code or a recovery code and returns the user.
+// The challenge is burnt after too many wrong codes.
+func (s *ServiceStruct) VerifyMFA(challenge, code, clientIP string) (string, error) {
+	err := s.CheckAttempts("", clientIP)
+	if err != nil {
+		return "", err
+	}
+	challengeHash := utils.HashToken(challenge)
+	token, err := s.DB.GetUserToken(models.TokenMFAChallenge, challengeHash)
+	if err != nil {
+		s.failedAttempt("", clientIP)
+		return "", err
+	}
+	err = s.CheckAttempts(token.UserID, "")
+	if err != nil {
+		return "", err
+	}
+	ok, err := s.checkMFACode(token.UserID, strings.TrimSpace(code))
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		s.failedAttempt(token.UserID, clientIP)
+		maxAttempts := s.Accounts.MFAMaxAttempts
+		if maxAttempts <= 0 {
+			maxAttempts = defaultMFAMaxAttempts
+		}
+		err = s.DB.FailUserToken(models.TokenMFAChallenge, challengeHash, maxAttempts)
+		if err != nil {
+			logger.Error(err)
+		}
+		return "", ErrInvalidMFACode
+	}
+	err = s.SucceededAttempt(token.UserID)
+	if err != nil {
+		logger.Error(err)
+	}
+	return s.DB.ConsumeUserToken(models.TokenMFAChallenge, challengeHash)
+}
+
+func (s *ServiceStruct) checkMFACode(guid, code string) (bool, error) {
+	if !isTOTPCode(code) {
+		return s.DB.UseRecoveryCode(guid, utils.HashToken(utils.NormalizeRecoveryCode(code)))
+	}
+	totp, err := s.DB.GetTOTP(guid)
+	if err != nil {
+		return false, err
+	}
+	secret, err := utils.DecryptSecret(totp.Secret)
+	if err != nil {
+		return false, err
+	}
+	step, ok := utils.ValidateTOTP(secret, code, time.Now(), totp.LastUsedStep)
+	if !ok {
+		return false, nil
+	}
+	// the step is checked again in the database, so two requests can not share a code
+	return s.DB.UseTOTPStep(guid, step)
+}
+
+func isTOTPCode(code string) bool {
+	if len(code) != 6 {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
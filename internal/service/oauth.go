@@ -0,0 +1,442 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/utils"
+	logger "github.com/sirupsen/logrus"
+)
+
+var ErrInvalidRequest = errors.New("invalid request")
+var ErrInvalidGrant = errors.New("invalid grant")
+var ErrUnauthorizedClient = errors.New("client is not allowed to use the grant")
+var ErrUnsupportedGrantType = errors.New("unsupported grant type")
+var ErrInvalidScope = errors.New("invalid scope")
+var ErrUnsupportedResponseType = errors.New("unsupported response type")
+var ErrInvalidRedirectURI = errors.New("redirect_uri is not registered for the client")
+var ErrConsentRequired = errors.New("user has not consented to the client")
+var ErrTokenNotOwned = errors.New("token has been issued to another client")
+
+const (
+	TokenTypeBearer  = "Bearer"
+	ResponseTypeCode = "code"
+	PKCEMethodS256   = "S256"
+	EventCodeReuse   = "authorization_code_reuse"
+)
+
+// AuthorizationClient checks the client and the redirect URI of an authorization request.
+// The redirect URI may be omitted when the client has registered only one.
+// Errors of this check must not be sent to the redirect URI.
+func (s *ServiceStruct) AuthorizationClient(clientID, redirectURI string) (models.Client, string, error) {
+	client, err := s.FindClient(clientID)
+	if err != nil {
+		return models.Client{}, "", err
+	}
+	if redirectURI == "" {
+		if len(client.RedirectURIs) != 1 {
+			return models.Client{}, "", ErrInvalidRedirectURI
+		}
+		return client, client.RedirectURIs[0], nil
+	}
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		return models.Client{}, "", ErrInvalidRedirectURI
+	}
+	return client, redirectURI, nil
+}
+
+// Authorize issues a single use authorization code of the user for the client, only PKCE with S256
+// is accepted. Unless the client is first-party the user must have granted it the scope before.
+func (s *ServiceStruct) Authorize(guid string, client models.Client, request models.AuthorizationRequest) (string, error) {
+	if request.ResponseType != ResponseTypeCode {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedResponseType, request.ResponseType)
+	}
+	if !slices.Contains(client.GrantTypes, models.GrantAuthorizationCode) {
+		return "", fmt.Errorf("%w: %s", ErrUnauthorizedClient, models.GrantAuthorizationCode)
+	}
+	if request.CodeChallengeMethod != PKCEMethodS256 || !validPKCE(request.CodeChallenge) {
+		return "", fmt.Errorf("%w: code_challenge with code_challenge_method S256 required", ErrInvalidRequest)
+	}
+	scope := request.Scope
+	if scope == "" {
+		scope = client.Scope
+	} else if !scopeAllowed(client.Scope, scope) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+	}
+	err := s.checkUser(guid)
+	if err != nil {
+		return "", err
+	}
+	if !client.FirstParty {
+		granted, err := s.DB.GetConsent(guid, client.ID)
+		if errors.Is(err, database.ErrConsentNotFound) || err == nil && !scopeAllowed(granted, scope) {
+			return "", fmt.Errorf("%w: %s", ErrConsentRequired, client.ID)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	code, err := utils.CreateLink()
+	if err != nil {
+		return "", err
+	}
+	ttl := s.OAuth.CodeTTL
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+	err = s.DB.CreateAuthorizationCode(models.AuthorizationCode{
+		Hash:            utils.HashToken(code),
+		ClientID:        client.ID,
+		UserID:          guid,
+		RedirectURI:     request.RedirectURI,
+		RedirectURISent: request.RedirectURISent,
+		Scope:           scope,
+		ExpiresAt:       time.Now().Add(ttl),
+		CodeChallenge:   request.CodeChallenge,
+	})
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// GrantConsent lets the client get codes of the user for the scope, the client scope when none is given.
+// Scopes granted before are kept.
+func (s *ServiceStruct) GrantConsent(guid, clientID, scope string) error {
+	client, err := s.FindClient(clientID)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(client.GrantTypes, models.GrantAuthorizationCode) {
+		return fmt.Errorf("%w: %s", ErrUnauthorizedClient, models.GrantAuthorizationCode)
+	}
+	if scope == "" {
+		scope = client.Scope
+	} else if !scopeAllowed(client.Scope, scope) {
+		return fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+	}
+	err = s.checkUser(guid)
+	if err != nil {
+		return err
+	}
+	granted, err := s.DB.GetConsent(guid, client.ID)
+	if err != nil && !errors.Is(err, database.ErrConsentNotFound) {
+		return err
+	}
+	scopes := strings.Fields(granted)
+	for _, requested := range strings.Fields(scope) {
+		if !slices.Contains(scopes, requested) {
+			scopes = append(scopes, requested)
+		}
+	}
+	return s.DB.SaveConsent(guid, client.ID, strings.Join(scopes, " "))
+}
+
+// Token implements the grants of the RFC 6749 token endpoint for an authenticated client
+func (s *ServiceStruct) Token(client models.Client, request models.TokenRequest) (models.TokenResponse, error) {
+	switch request.GrantType {
+	case models.GrantAuthorizationCode, models.GrantRefreshToken, models.GrantClientCredentials:
+	case "":
+		return models.TokenResponse{}, fmt.Errorf("%w: grant_type required", ErrInvalidRequest)
+	default:
+		return models.TokenResponse{}, fmt.Errorf("%w: %s", ErrUnsupportedGrantType, request.GrantType)
+	}
+	if !slices.Contains(client.GrantTypes, request.GrantType) {
+		return models.TokenResponse{}, fmt.Errorf("%w: %s", ErrUnauthorizedClient, request.GrantType)
+	}
+
+	switch request.GrantType {
+	case models.GrantAuthorizationCode:
+		return s.authorizationCodeGrant(client, request)
+	case models.GrantRefreshToken:
+		return s.refreshTokenGrant(client, request)
+	default:
+		return s.clientCredentialsGrant(client, request)
+	}
+}
+
+func (s *ServiceStruct) authorizationCodeGrant(client models.Client, request models.TokenRequest) (models.TokenResponse, error) {
+	if request.Code == "" || request.CodeVerifier == "" {
+		return models.TokenResponse{}, fmt.Errorf("%w: code and code_verifier required", ErrInvalidRequest)
+	}
+	err := s.CheckAttempts("", request.ClientIP)
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+	// the code is used up only by the exchange that passes every check
+	codeHash := utils.HashToken(request.Code)
+	code, err := s.DB.GetAuthorizationCode(codeHash)
+	if err != nil {
+		return models.TokenResponse{}, s.codeError(code, request, err)
+	}
+	if code.RedirectURISent && request.RedirectURI == "" {
+		s.failedAttempt("", request.ClientIP)
+		return models.TokenResponse{}, fmt.Errorf("%w: redirect_uri required, it was sent with the authorization request",
+			ErrInvalidGrant)
+	}
+	if code.ClientID != client.ID || request.RedirectURI != "" && code.RedirectURI != request.RedirectURI {
+		s.failedAttempt("", request.ClientIP)
+		return models.TokenResponse{}, fmt.Errorf("%w: code was issued to another client or redirect_uri", ErrInvalidGrant)
+	}
+	if !validPKCE(request.CodeVerifier) || subtle.ConstantTimeCompare([]byte(pkceChallenge(request.CodeVerifier)),
+		[]byte(code.CodeChallenge)) != 1 {
+		s.failedAttempt("", request.ClientIP)
+		return models.TokenResponse{}, fmt.Errorf("%w: code_verifier does not match code_challenge", ErrInvalidGrant)
+	}
+	return s.startClientSession(client, code, request)
+}
+
+// codeError turns an unknown, expired or replayed code into invalid_grant,
+// a replay also ends the session the code has started
+func (s *ServiceStruct) codeError(code models.AuthorizationCode, request models.TokenRequest, err error) error {
+	if errors.Is(err, database.ErrCodeReused) {
+		s.failedAttempt("", request.ClientIP)
+		s.revokeCodeSession(code)
+		return fmt.Errorf("%w: %w", ErrInvalidGrant, err)
+	}
+	if errors.Is(err, database.ErrCodeInvalid) {
+		s.failedAttempt("", request.ClientIP)
+		return fmt.Errorf("%w: %w", ErrInvalidGrant, err)
+	}
+	return err
+}
+
+// revokeCodeSession ends the session started with a replayed code, the tokens could have been
+// issued to whoever intercepted the code
+func (s *ServiceStruct) revokeCodeSession(code models.AuthorizationCode) {
+	logger.Warn(database.ErrCodeReused)
+	if code.SessionID == "" {
+		return
+	}
+	err := s.DB.RevokeSession(code.SessionID)
+	if err == sql.ErrNoRows {
+		// revoked already or not started
+		return
+	}
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	err = s.DB.AddSecurityEvent(models.SecurityEvent{
+		UserID:    code.UserID,
+		SessionID: code.SessionID,
+		Event:     EventCodeReuse,
+	})
+	if err != nil {
+		logger.Error(err)
+	}
+}
+
+// startClientSession opens a session of the user owned by the client and issues its first tokens.
+// The session is stored together with using up the code, so a replay of the code always ends it.
+func (s *ServiceStruct) startClientSession(client models.Client, code models.AuthorizationCode,
+	request models.TokenRequest) (models.TokenResponse, error) {
+	guid, scope := code.UserID, code.Scope
+	err := s.checkUser(guid)
+	if err != nil {
+		return models.TokenResponse{}, grantUserError(err)
+	}
+	sessionID, err := utils.NewUUID()
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+	claims := &models.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: guid},
+		ClientIP:         request.ClientIP,
+		SessionID:        sessionID,
+		ClientID:         client.ID,
+		Scope:            scope,
+	}
+	aToken, err := utils.NewAccessToken(claims, seconds(client.AccessTokenTTL))
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+	rToken, err := utils.NewRefreshToken()
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+	_, rtExp, err := utils.TokensExpiration()
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+	if client.RefreshTokenTTL > 0 {
+		rtExp = time.Now().Add(seconds(client.RefreshTokenTTL))
+	}
+	idleExp, err := utils.IdleExpiration()
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+
+	session := models.Session{
+		ID:            sessionID,
+		UserID:        guid,
+		RT:            rToken,
+		UserAgent:     request.UserAgent,
+		ClientIP:      request.ClientIP,
+		ExpiresAt:     rtExp,
+		IdleExpiresAt: idleExp,
+		ClientID:      client.ID,
+		Scope:         scope,
+	}
+	err = hashRT(&session)
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+	used, err := s.DB.ConsumeAuthorizationCode(code.Hash, session)
+	if err != nil {
+		return models.TokenResponse{}, s.codeError(used, request, err)
+	}
+	return tokenResponse(aToken, claims, rToken), nil
+}
+
+// refreshTokenGrant rotates the refresh token like RefreshTokens does for cookies,
+// a client can refresh only the sessions issued to it
+func (s *ServiceStruct) refreshTokenGrant(client models.Client, request models.TokenRequest) (models.TokenResponse, error) {
+	if request.RefreshToken == "" {
+		return models.TokenResponse{}, fmt.Errorf("%w: refresh_token required", ErrInvalidRequest)
+	}
+	err := s.CheckAttempts("", request.ClientIP)
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+	session, err := s.compareRT(request.RefreshToken, func(session models.Session) bool {
+		return session.ClientID == client.ID
+	})
+	if err != nil {
+		if errors.Is(err, database.ErrUnauthorized) || errors.Is(err, ErrRTReused) {
+			s.failedAttempt("", request.ClientIP)
+		}
+		if errors.Is(err, database.ErrUnauthorized) || errors.Is(err, ErrRTReused) || errors.Is(err, ErrRTExpired) {
+			return models.TokenResponse{}, fmt.Errorf("%w: %w", ErrInvalidGrant, err)
+		}
+		return models.TokenResponse{}, grantUserError(err)
+	}
+
+	// the scope may be narrowed for the new access token, the session keeps the granted one
+	scope := session.Scope
+	if request.Scope != "" {
+		if !scopeAllowed(session.Scope, request.Scope) {
+			return models.TokenResponse{}, fmt.Errorf("%w: %s", ErrInvalidScope, request.Scope)
+		}
+		scope = request.Scope
+	}
+	claims := &models.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: session.UserID},
+		ClientIP:         request.ClientIP,
+		SessionID:        session.ID,
+		ClientID:         client.ID,
+		Scope:            scope,
+	}
+	aToken, err := utils.NewAccessToken(claims, seconds(client.AccessTokenTTL))
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+	rToken, err := utils.NewRefreshToken()
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+	idleExp, err := utils.IdleExpiration()
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+
+	session.RT = rToken
+	session.UserAgent = request.UserAgent
+	session.ClientIP = request.ClientIP
+	session.IdleExpiresAt = idleExp
+	err = s.RotateSession(session, nil)
+	if errors.Is(err, ErrRTReused) {
+		// revoked or rotated by another request while the token was being rotated
+		s.failedAttempt("", request.ClientIP)
+		return models.TokenResponse{}, fmt.Errorf("%w: %w", ErrInvalidGrant, err)
+	}
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+	return tokenResponse(aToken, claims, rToken), nil
+}
+
+// clientCredentialsGrant issues only an access token, its subject is the client itself
+func (s *ServiceStruct) clientCredentialsGrant(client models.Client, request models.TokenRequest) (models.TokenResponse, error) {
+	scope := request.Scope
+	if scope == "" {
+		scope = client.Scope
+	} else if !scopeAllowed(client.Scope, scope) {
+		return models.TokenResponse{}, fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+	}
+	claims := &models.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: client.ID},
+		ClientIP:         request.ClientIP,
+		ClientID:         client.ID,
+		Scope:            scope,
+	}
+	aToken, err := utils.NewAccessToken(claims, seconds(client.AccessTokenTTL))
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+	return tokenResponse(aToken, claims, ""), nil
+}
+
+// seconds turns the lifetime overrides of a client into a duration, zero keeps the default
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+func tokenResponse(aToken string, claims *models.Claims, rToken string) models.TokenResponse {
+	return models.TokenResponse{
+		AccessToken:  aToken,
+		TokenType:    TokenTypeBearer,
+		ExpiresIn:    int64(claims.ExpiresAt.Sub(claims.IssuedAt.Time).Seconds()),
+		RefreshToken: rToken,
+		Scope:        claims.Scope,
+	}
+}
+
+// grantUserError turns the reasons a user can not get tokens into invalid_grant
+func grantUserError(err error) error {
+	if errors.Is(err, database.ErrUserNotFound) || errors.Is(err, ErrUserDisabled) ||
+		errors.Is(err, ErrUserLocked) || errors.Is(err, ErrEmailNotVerified) {
+		return fmt.Errorf("%w: %w", ErrInvalidGrant, err)
+	}
+	return err
+}
+
+// scopeAllowed reports whether every requested scope is among the allowed ones
+func scopeAllowed(allowed, requested string) bool {
+	allowedScopes := strings.Fields(allowed)
+	for _, scope := range strings.Fields(requested) {
+		if !slices.Contains(allowedScopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// validPKCE checks the length and the characters of a code verifier or challenge, RFC 7636 section 4.1
+func validPKCE(value string) bool {
+	if len(value) < 43 || len(value) > 128 {
+		return false
+	}
+	for _, c := range value {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.ContainsRune("-._~", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,298 @@
+package service
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// oauthDB holds one session owned by the client "app"
+type oauthDB struct {
+	database.DBInterface
+	session  models.Session
+	rotated  int
+	codes    map[string]*oauthCode
+	revoked  []string
+	client   models.Client
+	consents map[string]string
+	// what a concurrent request has read before the session was rotated
+	stale *models.Session
+}
+
+func (db *oauthDB) GetClient(id string) (models.Client, error) {
+	if id != db.client.ID {
+		return models.Client{}, database.ErrClientNotFound
+	}
+	return db.client, nil
+}
+
+type oauthCode struct {
+	models.AuthorizationCode
+	used bool
+}
+
+func (db *oauthDB) CreateAuthorizationCode(code models.AuthorizationCode) error {
+	db.codes[code.Hash] = &oauthCode{AuthorizationCode: code}
+	return nil
+}
+
+func (db *oauthDB) GetAuthorizationCode(codeHash string) (models.AuthorizationCode, error) {
+	code, ok := db.codes[codeHash]
+	if !ok {
+		return models.AuthorizationCode{}, database.ErrCodeInvalid
+	}
+	if code.used {
+		return code.AuthorizationCode, database.ErrCodeReused
+	}
+	return code.AuthorizationCode, nil
+}
+
+func (db *oauthDB) ConsumeAuthorizationCode(codeHash string, session models.Session) (models.AuthorizationCode, error) {
+	current, err := db.GetAuthorizationCode(codeHash)
+	if err != nil {
+		return current, err
+	}
+	code := db.codes[codeHash]
+	code.used = true
+	code.SessionID = session.ID
+	db.session = session
+	return code.AuthorizationCode, nil
+}
+
+func (db *oauthDB) GetConsent(guid, clientID string) (string, error) {
+	scope, ok := db.consents[guid+clientID]
+	if !ok {
+		return "", database.ErrConsentNotFound
+	}
+	return scope, nil
+}
+
+func (db *oauthDB) SaveConsent(guid, clientID, scope string) error {
+	db.consents[guid+clientID] = scope
+	return nil
+}
+
+func (db *oauthDB) CreateSession(session models.Session) (string, error) {
+	db.session = session
+	return session.ID, nil
+}
+
+func (db *oauthDB) RevokeSession(id string) error {
+	db.revoked = append(db.revoked, id)
+	return nil
+}
+
+func (db *oauthDB) AddSecurityEvent(event models.SecurityEvent) error {
+	return nil
+}
+
+func (db *oauthDB) GetSession(selector string) (models.Session, error) {
+	if db.stale != nil && selector == db.stale.RTSelector {
+		return *db.stale, nil
+	}
+	if selector != db.session.RTSelector {
+		return models.Session{}, database.ErrUnauthorized
+	}
+	return db.session, nil
+}
+
+func (db *oauthDB) GetRotatedSession(selector string) (models.Session, error) {
+	return models.Session{}, database.ErrUnauthorized
+}
+
+func (db *oauthDB) GetUser(guid string) (models.User, error) {
+	if guid != "user" {
+		return models.User{}, database.ErrUserNotFound
+	}
+	return models.User{ID: guid, Status: models.UserActive}, nil
+}
+
+func (db *oauthDB) SelectMail(guid string) (string, error) {
+	return "", database.ErrEmailNotFound
+}
+
+func (db *oauthDB) RotateSession(presentedSelector string, session models.Session, notification *models.Notification) error {
+	if session.ID != db.session.ID || presentedSelector != db.session.RTSelector {
+		return sql.ErrNoRows
+	}
+	db.session = session
+	db.rotated++
+	return nil
+}
+
+func (db *oauthDB) GetAttempts(keys []string) ([]models.Attempts, error) {
+	return nil, nil
+}
+
+func (db *oauthDB) AddFailure(key string, window time.Duration) (models.Attempts, error) {
+	return models.Attempts{Key: key, Failures: 1}, nil
+}
+
+func TestTokenGrants(t *testing.T) {
+	t.Setenv("ATEXPIRES", "60")
+	t.Setenv("JWT_SECRET", "jwt_secret")
+
+	rt, err := utils.NewRefreshToken()
+	require.NoError(t, err)
+	session := models.Session{ID: "session", UserID: "user", RT: rt, ClientID: "app", Scope: "read write"}
+	require.NoError(t, hashRT(&session))
+	session.ExpiresAt = time.Now().Add(time.Hour)
+	db := &oauthDB{session: session}
+	s := New(db, nil)
+
+	app := models.Client{ID: "app", GrantTypes: []string{models.GrantRefreshToken, models.GrantClientCredentials},
+		Scope: "read"}
+	other := models.Client{ID: "other", GrantTypes: []string{models.GrantRefreshToken}}
+
+	_, err = s.Token(other, models.TokenRequest{GrantType: models.GrantRefreshToken, RefreshToken: rt})
+	assert.ErrorIs(t, err, ErrInvalidGrant, "refresh токен принят от чужого клиента")
+	_, err = s.Token(other, models.TokenRequest{GrantType: models.GrantClientCredentials})
+	assert.ErrorIs(t, err, ErrUnauthorizedClient)
+
+	_, err = s.Token(app, models.TokenRequest{GrantType: models.GrantRefreshToken, RefreshToken: rt, Scope: "admin"})
+	assert.ErrorIs(t, err, ErrInvalidScope, "расширена область доступа")
+
+	tokens, err := s.Token(app, models.TokenRequest{GrantType: models.GrantRefreshToken, RefreshToken: rt, Scope: "read"})
+	require.NoError(t, err)
+	assert.Equal(t, TokenTypeBearer, tokens.TokenType)
+	assert.Equal(t, int64(60), tokens.ExpiresIn)
+	assert.Equal(t, "read", tokens.Scope)
+	assert.NotEqual(t, rt, tokens.RefreshToken, "refresh токен не обновлен")
+	assert.Equal(t, 1, db.rotated)
+	assert.Equal(t, "read write", db.session.Scope, "сессия потеряла выданную область доступа")
+	claims, err := utils.ValidateAccessToken(tokens.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, "user", claims.Subject)
+	assert.Equal(t, "app", claims.ClientID)
+
+	tokens, err = s.Token(app, models.TokenRequest{GrantType: models.GrantClientCredentials})
+	require.NoError(t, err)
+	assert.Empty(t, tokens.RefreshToken)
+	assert.Equal(t, "read", tokens.Scope)
+	_, err = s.Token(app, models.TokenRequest{GrantType: models.GrantClientCredentials, Scope: "write"})
+	assert.ErrorIs(t, err, ErrInvalidScope)
+}
+
+func TestRevokeClientRT(t *testing.T) {
+	rt, err := utils.NewRefreshToken()
+	require.NoError(t, err)
+	session := models.Session{ID: "session", UserID: "user", RT: rt, ClientID: "app"}
+	require.NoError(t, hashRT(&session))
+	db := &oauthDB{session: session}
+	s := New(db, nil)
+
+	err = s.RevokeClientRT(models.Client{ID: "other"}, rt)
+	assert.ErrorIs(t, err, ErrTokenNotOwned)
+	assert.Empty(t, db.revoked, "токен отозван чужим клиентом")
+	require.NoError(t, s.RevokeClientRT(models.Client{ID: "app"}, rt))
+	assert.Equal(t, []string{"session"}, db.revoked)
+}
+
+func TestRefreshTokenRace(t *testing.T) {
+	t.Setenv("ATEXPIRES", "60")
+	t.Setenv("JWT_SECRET", "jwt_secret")
+
+	rt, err := utils.NewRefreshToken()
+	require.NoError(t, err)
+	session := models.Session{ID: "session", UserID: "user", RT: rt, ClientID: "app"}
+	require.NoError(t, hashRT(&session))
+	session.ExpiresAt = time.Now().Add(time.Hour)
+	db := &oauthDB{session: session}
+	s := New(db, nil)
+	app := models.Client{ID: "app", GrantTypes: []string{models.GrantRefreshToken}}
+
+	// both requests have found the session, the first one rotates the token
+	_, err = s.Token(app, models.TokenRequest{GrantType: models.GrantRefreshToken, RefreshToken: rt})
+	require.NoError(t, err)
+	db.stale = &session
+	_, err = s.Token(app, models.TokenRequest{GrantType: models.GrantRefreshToken, RefreshToken: rt})
+	assert.ErrorIs(t, err, ErrInvalidGrant, "токен обновлен дважды")
+	assert.ErrorIs(t, err, ErrRTReused)
+	assert.Equal(t, 1, db.rotated, "вторая ротация перезаписала первую")
+	assert.Equal(t, []string{"session"}, db.revoked, "сессия повторно использованного токена не отозвана")
+}
+
+func TestAuthorizationCodeGrant(t *testing.T) {
+	t.Setenv("ATEXPIRES", "60")
+	t.Setenv("RTEXPIRES", "3600")
+	t.Setenv("JWT_SECRET", "jwt_secret")
+
+	db := &oauthDB{codes: map[string]*oauthCode{}, consents: map[string]string{}}
+	s := New(db, nil)
+	app := models.Client{ID: "app", GrantTypes: []string{models.GrantAuthorizationCode}, Scope: "read write",
+		RedirectURIs: []string{"https://app.example.com/cb"}, AccessTokenTTL: 30, RefreshTokenTTL: 120}
+	db.client = app
+
+	_, redirectURI, err := s.AuthorizationClient("app", "")
+	require.NoError(t, err)
+	assert.Equal(t, "https://app.example.com/cb", redirectURI, "не выбран единственный адрес клиента")
+	_, _, err = s.AuthorizationClient("app", "https://app.example.com/cb/../evil")
+	assert.ErrorIs(t, err, ErrInvalidRedirectURI, "адрес сравнивается не точно")
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	request := models.AuthorizationRequest{ResponseType: ResponseTypeCode, ClientID: "app", RedirectURI: redirectURI,
+		RedirectURISent: true, Scope: "read", CodeChallenge: "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		CodeChallengeMethod: PKCEMethodS256}
+	_, err = s.Authorize("user", app, models.AuthorizationRequest{ResponseType: ResponseTypeCode,
+		RedirectURI: redirectURI, CodeChallenge: verifier, CodeChallengeMethod: "plain"})
+	assert.ErrorIs(t, err, ErrInvalidRequest, "принят метод plain")
+	_, err = s.Authorize("user", app, request)
+	assert.ErrorIs(t, err, ErrConsentRequired, "код выдан без согласия пользователя")
+	require.NoError(t, s.GrantConsent("user", "app", "read"))
+	_, err = s.Authorize("user", app, models.AuthorizationRequest{ResponseType: ResponseTypeCode,
+		RedirectURI: redirectURI, Scope: "write", CodeChallenge: request.CodeChallenge,
+		CodeChallengeMethod: PKCEMethodS256})
+	assert.ErrorIs(t, err, ErrConsentRequired, "код выдан на область без согласия")
+	code, err := s.Authorize("user", app, request)
+	require.NoError(t, err)
+
+	exchange := models.TokenRequest{GrantType: models.GrantAuthorizationCode, Code: code, RedirectURI: redirectURI,
+		CodeVerifier: strings.Repeat("a", 43)}
+	_, err = s.Token(app, exchange)
+	assert.ErrorIs(t, err, ErrInvalidGrant, "принят неверный code_verifier")
+	other := app
+	other.ID = "other"
+	_, err = s.Token(other, exchange)
+	assert.ErrorIs(t, err, ErrInvalidGrant, "код принят от чужого клиента")
+
+	exchange.CodeVerifier = verifier
+	exchange.RedirectURI = ""
+	_, err = s.Token(app, exchange)
+	assert.ErrorIs(t, err, ErrInvalidGrant, "не повторен отправленный redirect_uri")
+
+	// failed exchanges leave the code to the client it has been issued to
+	exchange.RedirectURI = redirectURI
+	tokens, err := s.Token(app, exchange)
+	require.NoError(t, err)
+	assert.NotEmpty(t, tokens.RefreshToken)
+	assert.Equal(t, "read", tokens.Scope)
+	assert.Equal(t, "app", db.session.ClientID)
+	assert.Equal(t, db.session.ID, db.codes[utils.HashToken(code)].SessionID, "сессия не сохранена вместе с кодом")
+	assert.Equal(t, int64(30), tokens.ExpiresIn, "не применен срок жизни клиента")
+	assert.WithinDuration(t, time.Now().Add(2*time.Minute), db.session.ExpiresAt, time.Second)
+
+	_, err = s.Token(app, exchange)
+	assert.ErrorIs(t, err, ErrInvalidGrant, "код принят повторно")
+	assert.Equal(t, []string{db.session.ID}, db.revoked, "сессия повторно использованного кода не отозвана")
+
+	// redirect_uri may be left out when the authorization request has not sent it either
+	request.RedirectURISent = false
+	code, err = s.Authorize("user", app, request)
+	require.NoError(t, err)
+	_, err = s.Token(app, models.TokenRequest{GrantType: models.GrantAuthorizationCode, Code: code,
+		CodeVerifier: verifier})
+	assert.NoError(t, err)
+
+	first := app
+	first.ID = "first"
+	first.FirstParty = true
+	_, err = s.Authorize("user", first, request)
+	assert.NoError(t, err, "первой стороне нужно согласие")
+}
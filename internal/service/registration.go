@@ -0,0 +1,142 @@
+package service
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/utils"
+)
+
+var ErrRegistrationDisabled = errors.New("dynamic client registration is disabled")
+var ErrInvalidInitialToken = errors.New("invalid initial access token")
+var ErrInvalidRegistrationToken = errors.New("invalid registration access token")
+
+const RegistrationPath = "/register/client"
+
+// RegisterClient registers a client on its own behalf, RFC 7591. The client id and the secret are
+// always generated, the token lifetimes and first_party can only be set by an administrator.
+func (s *ServiceStruct) RegisterClient(initialToken string, client models.Client) (models.ClientRegistration, error) {
+	if s.OAuth.RegistrationToken == "" {
+		return models.ClientRegistration{}, ErrRegistrationDisabled
+	}
+	if subtle.ConstantTimeCompare([]byte(initialToken), []byte(s.OAuth.RegistrationToken)) != 1 {
+		return models.ClientRegistration{}, ErrInvalidInitialToken
+	}
+	err := s.registrationMetadata(&client)
+	if err != nil {
+		return models.ClientRegistration{}, err
+	}
+	client.ID = ""
+	client.Secret = ""
+	client.AccessTokenTTL = 0
+	client.RefreshTokenTTL = 0
+	client.FirstParty = false
+
+	registrationToken, err := utils.CreateLink()
+	if err != nil {
+		return models.ClientRegistration{}, err
+	}
+	client.RegistrationTokenHash = utils.HashToken(registrationToken)
+	client, err = s.CreateClient(client)
+	if err != nil {
+		return models.ClientRegistration{}, err
+	}
+	// read back for the time of registration
+	registered, err := s.DB.GetClient(client.ID)
+	if err != nil {
+		return models.ClientRegistration{}, err
+	}
+	client.CreatedAt = registered.CreatedAt
+	return s.clientRegistration(client, registrationToken), nil
+}
+
+// GetClientRegistration reads the registration, RFC 7592 section 2.1. The secret is not returned,
+// only its hash is stored.
+func (s *ServiceStruct) GetClientRegistration(clientID, registrationToken string) (models.ClientRegistration, error) {
+	client, err := s.registeredClient(clientID, registrationToken)
+	if err != nil {
+		return models.ClientRegistration{}, err
+	}
+	return s.clientRegistration(client, ""), nil
+}
+
+// UpdateClientRegistration replaces the metadata, RFC 7592 section 2.2. The request names the client
+// and may repeat its secret, the secret itself can not be changed.
+func (s *ServiceStruct) UpdateClientRegistration(clientID, registrationToken string,
+	client models.Client) (models.ClientRegistration, error) {
+	current, err := s.registeredClient(clientID, registrationToken)
+	if err != nil {
+		return models.ClientRegistration{}, err
+	}
+	if client.ID != clientID {
+		return models.ClientRegistration{}, fmt.Errorf("%w: client_id does not match the registration",
+			ErrInvalidClientMetadata)
+	}
+	if client.Secret != "" && !utils.CompareTokenHash(client.Secret, current.SecretHash) {
+		return models.ClientRegistration{}, fmt.Errorf("%w: client_secret can not be changed", ErrInvalidClientMetadata)
+	}
+	err = s.registrationMetadata(&client)
+	if err != nil {
+		return models.ClientRegistration{}, err
+	}
+	client.AccessTokenTTL = current.AccessTokenTTL
+	client.RefreshTokenTTL = current.RefreshTokenTTL
+	client.FirstParty = current.FirstParty
+	client, err = s.UpdateClient(client)
+	if err != nil {
+		return models.ClientRegistration{}, err
+	}
+	return s.clientRegistration(client, ""), nil
+}
+
+// DeleteClientRegistration deletes the client, RFC 7592 section 2.3
+func (s *ServiceStruct) DeleteClientRegistration(clientID, registrationToken string) error {
+	_, err := s.registeredClient(clientID, registrationToken)
+	if err != nil {
+		return err
+	}
+	return s.DB.DeleteClient(clientID)
+}
+
+// registeredClient checks the registration access token. An unknown client gets the same error,
+// so the token can not be used to find out which clients exist.
+func (s *ServiceStruct) registeredClient(clientID, registrationToken string) (models.Client, error) {
+	client, err := s.DB.GetClient(clientID)
+	if errors.Is(err, database.ErrClientNotFound) {
+		return models.Client{}, ErrInvalidRegistrationToken
+	}
+	if err != nil {
+		return models.Client{}, err
+	}
+	if client.RegistrationTokenHash == "" || !utils.CompareTokenHash(registrationToken, client.RegistrationTokenHash) {
+		return models.Client{}, ErrInvalidRegistrationToken
+	}
+	return client, nil
+}
+
+// registrationMetadata applies the defaults of RFC 7591 section 2 and keeps the scope within OAUTH_REGISTRATION_SCOPE
+func (s *ServiceStruct) registrationMetadata(client *models.Client) error {
+	if len(client.GrantTypes) == 0 {
+		client.GrantTypes = []string{models.GrantAuthorizationCode}
+	}
+	if client.Scope == "" {
+		client.Scope = s.OAuth.RegistrationScope
+	} else if !scopeAllowed(s.OAuth.RegistrationScope, client.Scope) {
+		return fmt.Errorf("%w: scope %s is not allowed", ErrInvalidClientMetadata, client.Scope)
+	}
+	return nil
+}
+
+func (s *ServiceStruct) clientRegistration(client models.Client, registrationToken string) models.ClientRegistration {
+	client.SecretHash = ""
+	client.RegistrationTokenHash = ""
+	return models.ClientRegistration{
+		Client:                  client,
+		ClientIDIssuedAt:        client.CreatedAt.Unix(),
+		RegistrationAccessToken: registrationToken,
+		RegistrationClientURI:   s.Accounts.PublicURL + RegistrationPath + "/" + client.ID,
+	}
+}
@@ -1,52 +1,299 @@
-package service
-
-import (
-	"github.com/sater-151/tt-auth/internal/database"
-	"github.com/sater-151/tt-auth/internal/utils"
-)
-
-type ServiceInterface interface {
-	EmailWarning(guid string) error
-	InsertRT(guid, rToken string) error
-	CompareRT(rtb, guid string) (bool, error)
-}
-
-type ServiceStruct struct {
-	DB database.DBInterface
-}
-
-func New(db database.DBInterface) *ServiceStruct {
-	service := &ServiceStruct{DB: db}
-	return service
-}
-func (s *ServiceStruct) EmailWarning(guid string) error {
-	mail, err := s.DB.SelectMail(guid)
-	if err != nil {
-		return err
-	}
-	err = utils.SendMasseg(mail)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (s *ServiceStruct) InsertRT(guid, rToken string) error {
-	err := s.DB.UpdateRT(guid, rToken)
-	return err
-}
-
-func (s *ServiceStruct) CompareRT(rtB64, guid string) (bool, error) {
-	rTokenBcryptNew, err := s.DB.GetBcrypt(rtB64)
-	if err != nil {
-		return false, err
-	}
-	rTokenOld, err := s.DB.GetToken(guid)
-	if err != nil {
-		return false, err
-	}
-	if rTokenBcryptNew != rTokenOld {
-		return false, nil
-	}
-	return true, nil
-}
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/notifier"
+	"github.com/sater-151/tt-auth/internal/utils"
+	logger "github.com/sirupsen/logrus"
+)
+
+var ErrRTReused = errors.New("refresh token reuse detected")
+var ErrRTExpired = errors.New("refresh token expired")
+var ErrUserDisabled = errors.New("user is disabled")
+var ErrUserLocked = errors.New("user is locked")
+var ErrEmailNotVerified = errors.New("email is not verified")
+
+const EventRTReuse = "refresh_token_reuse"
+
+type ServiceInterface interface {
+	EmailWarning(guid string, warning models.LoginWarning) error
+	CreateSession(session models.Session) error
+	CompareRT(rt, guid string) (models.Session, error)
+	RotateSession(session models.Session, warning *models.LoginWarning) error
+	RevokeRT(rt string) error
+	RevokeClientRT(client models.Client, rt string) error
+	RevokeAllSessions(guid string) error
+	AuthenticateClient(credentials models.ClientCredentials) (models.Client, error)
+	Login(login, password, clientIP string) (models.User, error)
+	Register(email, password, displayName string) error
+	VerifyEmail(token string) error
+	ForgotPassword(email string) error
+	ResetPassword(token, password string) error
+	EnrollTOTP(guid string) (models.TOTPEnrollment, error)
+	ConfirmTOTP(guid, code string) ([]string, error)
+	MFAChallenge(guid string) (string, error)
+	VerifyMFA(challenge, code, clientIP string) (string, error)
+	CheckAttempts(guid, clientIP string) error
+	FailedAttempt(guid, clientIP string) error
+	TakeAttempt(clientIP string) error
+	FinishAttempt(clientIP string, failed bool) error
+	BeginWebAuthnRegistration(guid string) (*protocol.CredentialCreation, string, error)
+	FinishWebAuthnRegistration(guid, sessionID string, body io.Reader) error
+	BeginWebAuthnLogin(login string) (*protocol.CredentialAssertion, string, error)
+	FinishWebAuthnLogin(sessionID string, body io.Reader) (string, error)
+	MagicLink(email string) (string, error)
+	MagicLinkLogin(token, nonce, clientIP string) (string, error)
+	Introspect(token, tokenTypeHint string) (models.Introspection, error)
+	Token(client models.Client, request models.TokenRequest) (models.TokenResponse, error)
+	AuthorizationClient(clientID, redirectURI string) (models.Client, string, error)
+	Authorize(guid string, client models.Client, request models.AuthorizationRequest) (string, error)
+	GrantConsent(guid, clientID, scope string) error
+	RegisterClient(initialToken string, client models.Client) (models.ClientRegistration, error)
+	GetClientRegistration(clientID, registrationToken string) (models.ClientRegistration, error)
+	UpdateClientRegistration(clientID, registrationToken string, client models.Client) (models.ClientRegistration, error)
+	DeleteClientRegistration(clientID, registrationToken string) error
+}
+
+type ServiceStruct struct {
+	DB       database.DBInterface
+	Notifier notifier.Notifier
+	Accounts models.AccountConfig
+	WebAuthn *webauthn.WebAuthn
+	Lockout  models.LockoutConfig
+	OAuth    models.OAuthConfig
+}
+
+func New(db database.DBInterface, notifier notifier.Notifier) *ServiceStruct {
+	service := &ServiceStruct{DB: db, Notifier: notifier}
+	return service
+}
+func (s *ServiceStruct) EmailWarning(guid string, warning models.LoginWarning) error {
+	notification, err := s.warningNotification(guid, warning)
+	if err != nil {
+		return err
+	}
+	return s.DB.EnqueueNotification(notification)
+}
+
+func (s *ServiceStruct) warningNotification(guid string, warning models.LoginWarning) (models.Notification, error) {
+	mail, err := s.DB.SelectMail(guid)
+	if err != nil {
+		return models.Notification{}, err
+	}
+	if warning.Time.IsZero() {
+		warning.Time = time.Now()
+	}
+	msg, err := notifier.Render(notifier.TemplateLoginWarning, mail, warning)
+	if err != nil {
+		return models.Notification{}, err
+	}
+	return models.Notification{Template: notifier.TemplateLoginWarning, Message: msg}, nil
+}
+
+func (s *ServiceStruct) CreateSession(session models.Session) error {
+	err := s.checkUser(session.UserID)
+	if err != nil {
+		return err
+	}
+	err = hashRT(&session)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.CreateSession(session)
+	return err
+}
+
+// CompareRT checks a refresh token of a first-party session, tokens issued to OAuth clients
+// can only be refreshed by their client at the token endpoint
+func (s *ServiceStruct) CompareRT(rt, guid string) (models.Session, error) {
+	return s.compareRT(rt, func(session models.Session) bool {
+		return session.UserID == guid && session.ClientID == ""
+	})
+}
+
+// compareRT finds the live session of the refresh token, owns tells whether the caller
+// may use the session. A rotated token revokes its session when presented by the owner.
+func (s *ServiceStruct) compareRT(rt string, owns func(session models.Session) bool) (models.Session, error) {
+	selector, verifier, err := utils.SplitRefreshToken(rt)
+	if err != nil {
+		// tokens issued before selectors were introduced are not valid any more
+		return models.Session{}, database.ErrUnauthorized
+	}
+	session, err := s.DB.GetSession(selector)
+	if err == nil {
+		if !owns(session) || !utils.CompareTokenHash(verifier, session.RTHash) {
+			return models.Session{}, database.ErrUnauthorized
+		}
+		if sessionExpired(session) {
+			return models.Session{}, ErrRTExpired
+		}
+		err = s.checkUser(session.UserID)
+		if err != nil {
+			return models.Session{}, err
+		}
+		return session, nil
+	}
+	if !errors.Is(err, database.ErrUnauthorized) {
+		return models.Session{}, err
+	}
+
+	// the token may be an already rotated one of some session
+	reused, err := s.DB.GetRotatedSession(selector)
+	if err != nil {
+		return models.Session{}, err
+	}
+	if !owns(reused) || !utils.CompareTokenHash(verifier, reused.RTHash) {
+		return models.Session{}, database.ErrUnauthorized
+	}
+	return models.Session{}, s.rtReused(reused)
+}
+
+// rtReused revokes the session of a rotated refresh token and warns the user,
+// the token could have been stolen
+func (s *ServiceStruct) rtReused(reused models.Session) error {
+	logger.Warn(ErrRTReused)
+	err := s.DB.RevokeSession(reused.ID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	// the family could have been revoked by an earlier reuse
+	alreadyRevoked := err == sql.ErrNoRows
+	err = s.DB.AddSecurityEvent(models.SecurityEvent{
+		UserID:    reused.UserID,
+		SessionID: reused.ID,
+		Event:     EventRTReuse,
+	})
+	if err != nil {
+		return err
+	}
+	if !alreadyRevoked {
+		err = s.EmailWarning(reused.UserID, models.LoginWarning{Reason: EventRTReuse, OldIP: reused.ClientIP})
+		if err != nil {
+			logger.Error(err)
+		}
+	}
+	return ErrRTReused
+}
+
+// RotateSession replaces the refresh token of the session found by compareRT and enqueues the warning
+// together with the rotation. The token must still be the current one, when another request has
+// rotated it in the meantime it has been presented twice and is handled as reused.
+func (s *ServiceStruct) RotateSession(session models.Session, warning *models.LoginWarning) error {
+	presented := session
+	err := hashRT(&session)
+	if err != nil {
+		return err
+	}
+	var notification *models.Notification
+	if warning != nil {
+		warningNotification, err := s.warningNotification(session.UserID, *warning)
+		if err != nil {
+			// a user without email still can refresh tokens
+			logger.Error(err)
+		} else {
+			notification = &warningNotification
+		}
+	}
+	err = s.DB.RotateSession(presented.RTSelector, session, notification)
+	if err == sql.ErrNoRows {
+		return s.rtReused(presented)
+	}
+	return err
+}
+
+func (s *ServiceStruct) ListFailedNotifications(limit int) ([]models.Notification, error) {
+	return s.DB.ListFailedNotifications(limit)
+}
+
+func (s *ServiceStruct) RetryNotification(id string) error {
+	return s.DB.RetryNotification(id)
+}
+
+func (s *ServiceStruct) RevokeRT(rt string) error {
+	session, err := s.findRT(rt)
+	if err != nil {
+		return err
+	}
+	return s.revokeSession(session)
+}
+
+// RevokeClientRT revokes a refresh token on behalf of the client it has been issued to, RFC 7009 section 2.1
+func (s *ServiceStruct) RevokeClientRT(client models.Client, rt string) error {
+	session, err := s.findRT(rt)
+	if err != nil {
+		return err
+	}
+	if session.ClientID != client.ID {
+		return ErrTokenNotOwned
+	}
+	return s.revokeSession(session)
+}
+
+// findRT finds the live session of the refresh token without checking its owner
+func (s *ServiceStruct) findRT(rt string) (models.Session, error) {
+	selector, verifier, err := utils.SplitRefreshToken(rt)
+	if err != nil {
+		return models.Session{}, database.ErrUnauthorized
+	}
+	session, err := s.DB.GetSession(selector)
+	if err != nil {
+		return models.Session{}, err
+	}
+	if !utils.CompareTokenHash(verifier, session.RTHash) {
+		return models.Session{}, database.ErrUnauthorized
+	}
+	return session, nil
+}
+
+func (s *ServiceStruct) revokeSession(session models.Session) error {
+	err := s.DB.RevokeSession(session.ID)
+	if err == sql.ErrNoRows {
+		return database.ErrUnauthorized
+	}
+	return err
+}
+
+func (s *ServiceStruct) RevokeAllSessions(guid string) error {
+	return s.DB.RevokeUserSessions(guid)
+}
+
+func hashRT(session *models.Session) error {
+	selector, verifier, err := utils.SplitRefreshToken(session.RT)
+	if err != nil {
+		return err
+	}
+	session.RTSelector = selector
+	session.RTHash = utils.HashToken(verifier)
+	return nil
+}
+
+// checkUser refuses tokens to unknown users and to users who are not active
+func (s *ServiceStruct) checkUser(guid string) error {
+	user, err := s.DB.GetUser(guid)
+	if err != nil {
+		return err
+	}
+	return s.userAllowed(user)
+}
+
+func (s *ServiceStruct) userAllowed(user models.User) error {
+	switch user.Status {
+	case models.UserActive:
+		if s.Accounts.RequireEmailVerified && !user.EmailVerified {
+			return ErrEmailNotVerified
+		}
+		return nil
+	case models.UserLocked:
+		return ErrUserLocked
+	default:
+		return ErrUserDisabled
+	}
+}
@@ -0,0 +1,252 @@
+package service
+
+import (
+	"errors"
+	"net/mail"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/notifier"
+	"github.com/sater-151/tt-auth/internal/utils"
+	logger "github.com/sirupsen/logrus"
+)
+
+const MinPasswordLength = 8
+
+var ErrInvalidStatus = errors.New("invalid user status")
+var ErrInvalidCredentials = errors.New("invalid credentials")
+var ErrPasswordTooShort = errors.New("password is too short")
+var ErrInvalidEmail = errors.New("invalid email address")
+
+const defaultVerifyEmailTTL = 24 * time.Hour
+const defaultResetPasswordTTL = time.Hour
+const defaultResetPasswordLimit = 3
+const defaultResetPasswordWindow = 15 * time.Minute
+
+var dummyHash struct {
+	once sync.Once
+	hash string
+}
+
+func (s *ServiceStruct) CreateUser(email, displayName string) (models.User, error) {
+	return s.DB.CreateUser(models.User{
+		Email:       strings.TrimSpace(email),
+		DisplayName: displayName,
+		Status:      models.UserActive,
+	})
+}
+
+func (s *ServiceStruct) SetUserStatus(guid, status string) error {
+	switch status {
+	case models.UserActive, models.UserDisabled, models.UserLocked:
+	default:
+		return ErrInvalidStatus
+	}
+	return s.DB.SetUserStatus(guid, status)
+}
+
+func (s *ServiceStruct) SetPassword(guid, password string) error {
+	if len(password) < MinPasswordLength {
+		return ErrPasswordTooShort
+	}
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return s.DB.SetPasswordHash(guid, hash)
+}
+
+// Register creates an active user with unverified email
+// and queues the link confirming the address.
+// A taken address gets a notice instead and is not reported, so the caller answers the same either way.
+func (s *ServiceStruct) Register(email, password, displayName string) error {
+	email = strings.TrimSpace(email)
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return ErrInvalidEmail
+	}
+	if len(password) < MinPasswordLength {
+		return ErrPasswordTooShort
+	}
+	passwordHash, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	token, notification, err := s.emailToken(models.TokenVerifyEmail, email, "/verify-email",
+		s.Accounts.VerifyEmailTTL, defaultVerifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.RegisterUser(
+		models.User{Email: email, DisplayName: strings.TrimSpace(displayName), Status: models.UserActive},
+		passwordHash, token, notification)
+	if errors.Is(err, database.ErrEmailTaken) {
+		logger.Debug("registration with taken email")
+		msg, err := notifier.Render(notifier.TemplateAlreadyRegistered, email, nil)
+		if err != nil {
+			return err
+		}
+		return s.DB.EnqueueNotification(models.Notification{Template: notifier.TemplateAlreadyRegistered, Message: msg})
+	}
+	return err
+}
+
+func (s *ServiceStruct) VerifyEmail(token string) error {
+	_, err := s.DB.VerifyEmail(utils.HashToken(token))
+	return err
+}
+
+// ForgotPassword emails a reset link to an active user. Unknown addresses are not
+// reported, so the caller answers the same whether the account exists or not.
+func (s *ServiceStruct) ForgotPassword(email string) error {
+	user, err := s.DB.GetUserByEmail(strings.TrimSpace(email))
+	if errors.Is(err, database.ErrUserNotFound) {
+		logger.Debug("password reset for unknown email")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.Status != models.UserActive {
+		logger.Debug("password reset for inactive user")
+		return nil
+	}
+	token, notification, err := s.emailToken(models.TokenResetPassword, user.Email, "/password/reset",
+		s.Accounts.ResetPasswordTTL, defaultResetPasswordTTL)
+	if err != nil {
+		return err
+	}
+	token.UserID = user.ID
+
+	limit := s.Accounts.ResetPasswordLimit
+	if limit <= 0 {
+		limit = defaultResetPasswordLimit
+	}
+	window := s.Accounts.ResetPasswordWindow
+	if window <= 0 {
+		window = defaultResetPasswordWindow
+	}
+	created, err := s.DB.CreateLimitedUserToken(token, notification, limit, window)
+	if err != nil {
+		return err
+	}
+	if !created {
+		logger.Warn("password reset rate limit reached")
+	}
+	return nil
+}
+
+func (s *ServiceStruct) ResetPassword(token, password string) error {
+	if len(password) < MinPasswordLength {
+		return ErrPasswordTooShort
+	}
+	passwordHash, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.ResetPassword(utils.HashToken(token), passwordHash)
+	return err
+}
+
+// emailToken makes a single use token and the email with the link carrying it,
+// the token is hashed the same way as refresh tokens
+func (s *ServiceStruct) emailToken(purpose, email, path string, ttl, defaultTTL time.Duration) (models.UserToken,
+	models.Notification, error) {
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	token, err := utils.CreateLink()
+	if err != nil {
+		return models.UserToken{}, models.Notification{}, err
+	}
+	link := models.EmailLink{
+		Link:      s.Accounts.PublicURL + path + "?token=" + url.QueryEscape(token),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	// templates are named after the token purpose
+	msg, err := notifier.Render(purpose, email, link)
+	if err != nil {
+		return models.UserToken{}, models.Notification{}, err
+	}
+	return models.UserToken{Purpose: purpose, Hash: utils.HashToken(token), ExpiresAt: link.ExpiresAt},
+		models.Notification{Template: purpose, Message: msg}, nil
+}
+
+// Login checks the password of the user found by email or username.
+// Hashes made with outdated parameters or with bcrypt are replaced on success.
+// A locked account is refused before the password is checked, so the answer does not tell if it was right.
+func (s *ServiceStruct) Login(login, password, clientIP string) (models.User, error) {
+	err := s.CheckAttempts("", clientIP)
+	if err != nil {
+		return models.User{}, err
+	}
+	user, err := s.DB.GetUserByLogin(strings.TrimSpace(login))
+	if errors.Is(err, database.ErrUserNotFound) {
+		comparePasswordDummy(password)
+		s.failedAttempt("", clientIP)
+		return models.User{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+	err = s.CheckAttempts(user.ID, "")
+	if err != nil {
+		return models.User{}, err
+	}
+	hash, err := s.DB.GetPasswordHash(user.ID)
+	if err != nil {
+		return models.User{}, err
+	}
+	if hash == "" {
+		comparePasswordDummy(password)
+		s.failedAttempt(user.ID, clientIP)
+		return models.User{}, ErrInvalidCredentials
+	}
+	ok, rehash, err := utils.ComparePassword(password, hash)
+	if err != nil {
+		return models.User{}, err
+	}
+	if !ok {
+		s.failedAttempt(user.ID, clientIP)
+		return models.User{}, ErrInvalidCredentials
+	}
+	err = s.SucceededAttempt(user.ID)
+	if err != nil {
+		logger.Error(err)
+	}
+	err = s.userAllowed(user)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if rehash {
+		logger.Debug("rehashing password")
+		hash, err = utils.HashPassword(password)
+		if err == nil {
+			err = s.DB.SetPasswordHash(user.ID, hash)
+		}
+		if err != nil {
+			// the user is already authenticated, the old hash still works
+			logger.Error(err)
+		}
+	}
+	return user, nil
+}
+
+// comparePasswordDummy spends the same time as a real check,
+// so the response time does not tell whether the login exists
+func comparePasswordDummy(password string) {
+	dummyHash.once.Do(func() {
+		hash, err := utils.HashPassword("dummy password")
+		if err != nil {
+			logger.Error(err)
+		}
+		dummyHash.hash = hash
+	})
+	utils.ComparePassword(password, dummyHash.hash)
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/notifier"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// usersDB registers emails in memory and keeps what was queued for sending
+type usersDB struct {
+	database.DBInterface
+	emails        map[string]bool
+	notifications []models.Notification
+}
+
+func (db *usersDB) RegisterUser(user models.User, passwordHash string, token models.UserToken,
+	notification models.Notification) (models.User, error) {
+	if db.emails[strings.ToLower(user.Email)] {
+		return models.User{}, database.ErrEmailTaken
+	}
+	db.emails[strings.ToLower(user.Email)] = true
+	db.notifications = append(db.notifications, notification)
+	user.ID = "guid"
+	return user, nil
+}
+
+func (db *usersDB) EnqueueNotification(notification models.Notification) error {
+	db.notifications = append(db.notifications, notification)
+	return nil
+}
+
+func TestRegisterTakenEmail(t *testing.T) {
+	db := &usersDB{emails: map[string]bool{}}
+	s := New(db, nil)
+	s.Accounts.PublicURL = "https://auth.example.com"
+
+	require.NoError(t, s.Register("user@example.com", "password", "User"))
+	require.Len(t, db.notifications, 1)
+	assert.Equal(t, models.TokenVerifyEmail, db.notifications[0].Template)
+
+	err := s.Register("User@example.com", "password", "")
+	require.NoError(t, err, "занятый email не должен отличаться от нового")
+	require.Len(t, db.notifications, 2, "владелец адреса не уведомлен")
+	assert.Equal(t, notifier.TemplateAlreadyRegistered, db.notifications[1].Template)
+	assert.Equal(t, "User@example.com", db.notifications[1].Message.To)
+	assert.NotContains(t, db.notifications[1].Message.Body, "token=", "уведомление не должно содержать ссылку")
+}
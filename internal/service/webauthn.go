@@ -0,0 +1,280 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/sater-151/tt-auth/internal/utils"
+)
+
+const webAuthnSessionTTL = 5 * time.Minute
+
+const (
+	ceremonyRegistration = "registration"
+	ceremonyLogin        = "login"
+)
+
+var ErrWebAuthnDisabled = errors.New("webauthn is not configured")
+var ErrWebAuthnFailed = errors.New("webauthn ceremony failed")
+var ErrWebAuthnCloned = errors.New("authenticator sign count did not increase")
+
+func NewWebAuthn(config models.WebAuthnConfig) (*webauthn.WebAuthn, error) {
+	return webauthn.New(&webauthn.Config{
+		RPID:          config.RPID,
+		RPDisplayName: config.RPDisplayName,
+		RPOrigins:     config.Origins,
+	})
+}
+
+// webAuthnUser adapts the user to the webauthn library, the user handle is the guid
+type webAuthnUser struct {
+	user        models.User
+	credentials []webauthn.Credential
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return []byte(u.user.ID)
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	if u.user.Email != "" {
+		return u.user.Email
+	}
+	if u.user.Username != "" {
+		return u.user.Username
+	}
+	return u.user.ID
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	if u.user.DisplayName != "" {
+		return u.user.DisplayName
+	}
+	return u.WebAuthnName()
+}
+
+func (u *webAuthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+func (s *ServiceStruct) webAuthnUser(user models.User) (*webAuthnUser, error) {
+	stored, err := s.DB.ListWebAuthnCredentials(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	waUser := &webAuthnUser{user: user}
+	for _, credential := range stored {
+		var waCredential webauthn.Credential
+		err = json.Unmarshal(credential.Credential, &waCredential)
+		if err != nil {
+			return nil, err
+		}
+		waUser.credentials = append(waUser.credentials, waCredential)
+	}
+	return waUser, nil
+}
+
+func (s *ServiceStruct) BeginWebAuthnRegistration(guid string) (*protocol.CredentialCreation, string, error) {
+	if s.WebAuthn == nil {
+		return nil, "", ErrWebAuthnDisabled
+	}
+	user, err := s.DB.GetUser(guid)
+	if err != nil {
+		return nil, "", err
+	}
+	waUser, err := s.webAuthnUser(user)
+	if err != nil {
+		return nil, "", err
+	}
+	exclusions := make([]protocol.CredentialDescriptor, len(waUser.credentials))
+	for i, credential := range waUser.credentials {
+		exclusions[i] = credential.Descriptor()
+	}
+
+	options, session, err := s.WebAuthn.BeginRegistration(waUser,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred))
+	if err != nil {
+		return nil, "", err
+	}
+	sessionID, err := s.saveWebAuthnSession(ceremonyRegistration, session)
+	if err != nil {
+		return nil, "", err
+	}
+	return options, sessionID, nil
+}
+
+func (s *ServiceStruct) FinishWebAuthnRegistration(guid, sessionID string, body io.Reader) error {
+	if s.WebAuthn == nil {
+		return ErrWebAuthnDisabled
+	}
+	session, err := s.takeWebAuthnSession(ceremonyRegistration, sessionID)
+	if err != nil {
+		return err
+	}
+	user, err := s.DB.GetUser(guid)
+	if err != nil {
+		return err
+	}
+	waUser, err := s.webAuthnUser(user)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(body)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrWebAuthnFailed, err)
+	}
+	credential, err := s.WebAuthn.CreateCredential(waUser, session, parsed)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrWebAuthnFailed, err)
+	}
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+	return s.DB.AddWebAuthnCredential(models.WebAuthnCredential{
+		UserID:       guid,
+		CredentialID: credential.ID,
+		SignCount:    credential.Authenticator.SignCount,
+		Credential:   data,
+	})
+}
+
+// BeginWebAuthnLogin lists the passkeys of the user found by login,
+// without login the authenticator picks a discoverable credential itself
+func (s *ServiceStruct) BeginWebAuthnLogin(login string) (*protocol.CredentialAssertion, string, error) {
+	if s.WebAuthn == nil {
+		return nil, "", ErrWebAuthnDisabled
+	}
+	var options *protocol.CredentialAssertion
+	var session *webauthn.SessionData
+	if login == "" {
+		var err error
+		options, session, err = s.WebAuthn.BeginDiscoverableLogin()
+		if err != nil {
+			return nil, "", err
+		}
+	} else {
+		user, err := s.DB.GetUserByLogin(login)
+		if errors.Is(err, database.ErrUserNotFound) {
+			return nil, "", ErrInvalidCredentials
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		waUser, err := s.webAuthnUser(user)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(waUser.credentials) == 0 {
+			return nil, "", ErrInvalidCredentials
+		}
+		options, session, err = s.WebAuthn.BeginLogin(waUser)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	sessionID, err := s.saveWebAuthnSession(ceremonyLogin, session)
+	if err != nil {
+		return nil, "", err
+	}
+	return options, sessionID, nil
+}
+
+// FinishWebAuthnLogin checks the assertion and returns the user. The sign count
+// has to grow on every login, otherwise the credential may have been cloned.
+func (s *ServiceStruct) FinishWebAuthnLogin(sessionID string, body io.Reader) (string, error) {
+	if s.WebAuthn == nil {
+		return "", ErrWebAuthnDisabled
+	}
+	session, err := s.takeWebAuthnSession(ceremonyLogin, sessionID)
+	if err != nil {
+		return "", err
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(body)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrWebAuthnFailed, err)
+	}
+
+	var waUser *webAuthnUser
+	findUser := func(rawID, userHandle []byte) (webauthn.User, error) {
+		user, err := s.DB.GetUser(string(userHandle))
+		if err != nil {
+			return nil, err
+		}
+		waUser, err = s.webAuthnUser(user)
+		return waUser, err
+	}
+	var credential *webauthn.Credential
+	if len(session.UserID) == 0 {
+		credential, err = s.WebAuthn.ValidateDiscoverableLogin(findUser, session, parsed)
+	} else {
+		var user webauthn.User
+		user, err = findUser(nil, session.UserID)
+		if err == nil {
+			credential, err = s.WebAuthn.ValidateLogin(user, session, parsed)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrWebAuthnFailed, err)
+	}
+	if credential.Authenticator.CloneWarning {
+		return "", ErrWebAuthnCloned
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return "", err
+	}
+	updated, err := s.DB.UpdateWebAuthnCredential(models.WebAuthnCredential{
+		UserID:       waUser.user.ID,
+		CredentialID: credential.ID,
+		SignCount:    credential.Authenticator.SignCount,
+		Credential:   data,
+	})
+	if err != nil {
+		return "", err
+	}
+	if !updated {
+		return "", ErrWebAuthnCloned
+	}
+	return waUser.user.ID, nil
+}
+
+func (s *ServiceStruct) saveWebAuthnSession(ceremony string, session *webauthn.SessionData) (string, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+	sessionID, err := utils.CreateLink()
+	if err != nil {
+		return "", err
+	}
+	err = s.DB.SaveWebAuthnSession(utils.HashToken(sessionID), ceremony, data, time.Now().Add(webAuthnSessionTTL))
+	if err != nil {
+		return "", err
+	}
+	return sessionID, nil
+}
+
+func (s *ServiceStruct) takeWebAuthnSession(ceremony, sessionID string) (webauthn.SessionData, error) {
+	var session webauthn.SessionData
+	data, err := s.DB.TakeWebAuthnSession(utils.HashToken(sessionID), ceremony)
+	if err != nil {
+		return session, err
+	}
+	err = json.Unmarshal(data, &session)
+	return session, err
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/sater-151/tt-auth/internal/database"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testUserID = "5f0c7a4e-3a7b-4c1e-9d8e-2b6f1a9c0d11"
+
+// webAuthnDB keeps only what the passkey ceremonies touch, other calls panic
+type webAuthnDB struct {
+	database.DBInterface
+	sessions    map[string][]byte
+	credentials []models.WebAuthnCredential
+}
+
+func (db *webAuthnDB) GetUser(guid string) (models.User, error) {
+	if guid != testUserID {
+		return models.User{}, database.ErrUserNotFound
+	}
+	return models.User{ID: testUserID, Email: "user@example.com", Status: models.UserActive}, nil
+}
+
+func (db *webAuthnDB) GetUserByLogin(login string) (models.User, error) {
+	if login != "user@example.com" {
+		return models.User{}, database.ErrUserNotFound
+	}
+	return db.GetUser(testUserID)
+}
+
+func (db *webAuthnDB) SaveWebAuthnSession(sessionHash, ceremony string, data []byte, expiresAt time.Time) error {
+	db.sessions[ceremony+sessionHash] = data
+	return nil
+}
+
+func (db *webAuthnDB) TakeWebAuthnSession(sessionHash, ceremony string) ([]byte, error) {
+	data, ok := db.sessions[ceremony+sessionHash]
+	if !ok {
+		return nil, database.ErrTokenInvalid
+	}
+	delete(db.sessions, ceremony+sessionHash)
+	return data, nil
+}
+
+func (db *webAuthnDB) ListWebAuthnCredentials(guid string) ([]models.WebAuthnCredential, error) {
+	return db.credentials, nil
+}
+
+func (db *webAuthnDB) AddWebAuthnCredential(credential models.WebAuthnCredential) error {
+	db.credentials = append(db.credentials, credential)
+	return nil
+}
+
+func (db *webAuthnDB) UpdateWebAuthnCredential(credential models.WebAuthnCredential) (bool, error) {
+	for i, stored := range db.credentials {
+		if bytes.Equal(stored.CredentialID, credential.CredentialID) && stored.SignCount < credential.SignCount {
+			db.credentials[i] = credential
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// softAuthenticator plays the browser and a platform authenticator with a P-256 key
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	signCount    uint32
+}
+
+const testRPID = "auth.example.com"
+const testOrigin = "https://auth.example.com"
+
+func b64url(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (a *softAuthenticator) clientData(ceremony string, challenge []byte) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": b64url(challenge),
+		"origin":    testOrigin,
+	})
+	return data
+}
+
+func (a *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+func (a *softAuthenticator) create(t *testing.T, options *protocol.CredentialCreation) []byte {
+	coseKey, err := cbor.Marshal(map[int]interface{}{
+		1:  2,  // kty EC2
+		3:  -7, // alg ES256
+		-1: 1,  // crv P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	require.NoError(t, err)
+	attested := make([]byte, 16) // aaguid
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, coseKey...)
+
+	attestation, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(0x45, attested), // UP, UV, AT
+	})
+	require.NoError(t, err)
+	body, err := json.Marshal(map[string]interface{}{
+		"id":    b64url(a.credentialID),
+		"rawId": b64url(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64url(a.clientData("webauthn.create", options.Response.Challenge)),
+			"attestationObject": b64url(attestation),
+		},
+	})
+	require.NoError(t, err)
+	return body
+}
+
+func (a *softAuthenticator) get(t *testing.T, options *protocol.CredentialAssertion) []byte {
+	a.signCount++
+	authData := a.authData(0x05, nil) // UP, UV
+	clientData := a.clientData("webauthn.get", options.Response.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	require.NoError(t, err)
+
+	body, err := json.Marshal(map[string]interface{}{
+		"id":    b64url(a.credentialID),
+		"rawId": b64url(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64url(clientData),
+			"authenticatorData": b64url(authData),
+			"signature":         b64url(signature),
+			"userHandle":        b64url([]byte(testUserID)),
+		},
+	})
+	require.NoError(t, err)
+	return body
+}
+
+func TestWebAuthnCeremonies(t *testing.T) {
+	webAuthn, err := NewWebAuthn(models.WebAuthnConfig{RPID: testRPID, RPDisplayName: "tt-auth", Origins: []string{testOrigin}})
+	require.NoError(t, err)
+	db := &webAuthnDB{sessions: map[string][]byte{}}
+	s := &ServiceStruct{DB: db, WebAuthn: webAuthn}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	authenticator := &softAuthenticator{key: key, credentialID: []byte("software-credential")}
+
+	creation, sessionID, err := s.BeginWebAuthnRegistration(testUserID)
+	require.NoError(t, err)
+	err = s.FinishWebAuthnRegistration(testUserID, sessionID, bytes.NewReader(authenticator.create(t, creation)))
+	require.NoError(t, err, "регистрация ключа не прошла")
+	require.Len(t, db.credentials, 1)
+
+	// the session of a ceremony can not be answered twice
+	err = s.FinishWebAuthnRegistration(testUserID, sessionID, bytes.NewReader(authenticator.create(t, creation)))
+	assert.ErrorIs(t, err, database.ErrTokenInvalid)
+
+	assertion, sessionID, err := s.BeginWebAuthnLogin("user@example.com")
+	require.NoError(t, err)
+	body := authenticator.get(t, assertion)
+	guid, err := s.FinishWebAuthnLogin(sessionID, bytes.NewReader(body))
+	require.NoError(t, err, "вход по ключу не прошел")
+	assert.Equal(t, testUserID, guid)
+
+	// discoverable login finds the user by the user handle
+	assertion, sessionID, err = s.BeginWebAuthnLogin("")
+	require.NoError(t, err)
+	guid, err = s.FinishWebAuthnLogin(sessionID, bytes.NewReader(authenticator.get(t, assertion)))
+	require.NoError(t, err, "вход без указания пользователя не прошел")
+	assert.Equal(t, testUserID, guid)
+
+	// an authenticator which does not increase the sign count looks cloned
+	assertion, sessionID, err = s.BeginWebAuthnLogin("user@example.com")
+	require.NoError(t, err)
+	authenticator.signCount--
+	_, err = s.FinishWebAuthnLogin(sessionID, bytes.NewReader(authenticator.get(t, assertion)))
+	assert.ErrorIs(t, err, ErrWebAuthnCloned)
+
+	// a signature of another key is rejected
+	assertion, sessionID, err = s.BeginWebAuthnLogin("user@example.com")
+	require.NoError(t, err)
+	authenticator.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	authenticator.signCount += 10
+	_, err = s.FinishWebAuthnLogin(sessionID, bytes.NewReader(authenticator.get(t, assertion)))
+	assert.ErrorIs(t, err, ErrWebAuthnFailed)
+}
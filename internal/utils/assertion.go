@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sater-151/tt-auth/internal/models"
+)
+
+var ErrUnsupportedJWK = errors.New("unsupported jwk")
+var ErrInvalidAssertion = errors.New("invalid client assertion")
+
+// client assertions live only as long as it takes to send them, the jti is kept until then
+const MaxAssertionLifetime = 5 * time.Minute
+
+var assertionAlgs = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// ParseJWK returns the public key of a RSA, EC or Ed25519 JWK
+func ParseJWK(jwk models.JWK) (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("%w: malformed RSA key", ErrUnsupportedJWK)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("%w: curve %s", ErrUnsupportedJWK, jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("%w: point is not on the curve", ErrUnsupportedJWK)
+		}
+		return key, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("%w: curve %s", ErrUnsupportedJWK, jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: malformed Ed25519 key", ErrUnsupportedJWK)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("%w: kty %s", ErrUnsupportedJWK, jwk.Kty)
+}
+
+// ValidateClientAssertion checks a private_key_jwt assertion, RFC 7523 section 3. The client must be
+// its issuer and subject and one of the audiences must be named. The jti is returned to be
+// checked for replays.
+func ValidateClientAssertion(assertion, clientID string, keys models.JWKSet, audiences []string) (*jwt.RegisteredClaims, error) {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(assertionAlgs),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(clientID),
+		jwt.WithSubject(clientID),
+	}
+	if leeway := os.Getenv("JWT_LEEWAY"); leeway != "" {
+		seconds, err := strconv.Atoi(leeway)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, jwt.WithLeeway(time.Duration(seconds)*time.Second))
+	}
+
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(assertion, claims, func(token *jwt.Token) (interface{}, error) {
+		return assertionKey(token, keys)
+	}, options...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidAssertion, err)
+	}
+	if !slices.ContainsFunc(claims.Audience, func(audience string) bool {
+		return audience != "" && slices.Contains(audiences, audience)
+	}) {
+		return nil, fmt.Errorf("%w: audience %v", ErrInvalidAssertion, claims.Audience)
+	}
+	if claims.ID == "" {
+		return nil, fmt.Errorf("%w: jti required", ErrInvalidAssertion)
+	}
+	if claims.ExpiresAt.After(time.Now().Add(MaxAssertionLifetime)) {
+		return nil, fmt.Errorf("%w: expires too late", ErrInvalidAssertion)
+	}
+	return claims, nil
+}
+
+// assertionKey picks the key by kid, a token without kid is accepted only when the choice is clear
+func assertionKey(token *jwt.Token, keys models.JWKSet) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	var candidates []models.JWK
+	for _, key := range keys.Keys {
+		if kid != "" && key.Kid != kid {
+			continue
+		}
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		if key.Alg != "" && key.Alg != token.Method.Alg() {
+			continue
+		}
+		candidates = append(candidates, key)
+	}
+	if len(candidates) != 1 {
+		return nil, ErrUnknownKID
+	}
+	return ParseJWK(candidates[0])
+}
+
+// AssertionSubject reads the client id from an assertion sent without client_id, the signature is not checked
+func AssertionSubject(assertion string) string {
+	claims := &jwt.RegisteredClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(assertion, claims)
+	if err != nil {
+		return ""
+	}
+	return claims.Subject
+}
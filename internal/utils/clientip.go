@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var (
+	trustedProxiesMu sync.RWMutex
+	trustedProxies   []*net.IPNet
+)
+
+// ParseTrustedProxies parses a comma separated list of CIDRs or single addresses
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func SetTrustedProxies(nets []*net.IPNet) {
+	trustedProxiesMu.Lock()
+	defer trustedProxiesMu.Unlock()
+	trustedProxies = nets
+}
+
+// ClientIP returns the address of the client. Forwarding headers are taken into account
+// only when the request comes from a trusted proxy, and only up to the first untrusted hop.
+func ClientIP(req *http.Request) string {
+	trustedProxiesMu.RLock()
+	trusted := trustedProxies
+	trustedProxiesMu.RUnlock()
+
+	remote := stripPort(req.RemoteAddr)
+	if !isTrusted(remote, trusted) {
+		return remote
+	}
+
+	var chain []string
+	if forwarded := req.Header.Values("Forwarded"); len(forwarded) > 0 {
+		chain = parseForwarded(forwarded)
+	} else {
+		for _, header := range req.Header.Values("X-Forwarded-For") {
+			for _, addr := range strings.Split(header, ",") {
+				chain = append(chain, stripPort(strings.TrimSpace(addr)))
+			}
+		}
+	}
+	chain = append(chain, remote)
+
+	for i := len(chain) - 2; i >= 0; i-- {
+		if net.ParseIP(chain[i]) == nil {
+			// obfuscated or unknown hop, the last trusted proxy is the best guess
+			return chain[i+1]
+		}
+		if !isTrusted(chain[i], trusted) {
+			return chain[i]
+		}
+	}
+	return chain[0]
+}
+
+func parseForwarded(headers []string) []string {
+	var chain []string
+	for _, header := range headers {
+		for _, element := range strings.Split(header, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(key, "for") {
+					continue
+				}
+				chain = append(chain, stripPort(strings.Trim(value, `"`)))
+			}
+		}
+	}
+	return chain
+}
+
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.Trim(addr, "[]")
+}
+
+func isTrusted(addr string, trusted []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range trusted {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		header     string
+		value      string
+		wantIP     string
+	}{
+		{
+			remoteAddr: "203.0.113.7:5000",
+			wantIP:     "203.0.113.7",
+		},
+		{
+			remoteAddr: "203.0.113.7:5000",
+			header:     "X-Forwarded-For",
+			value:      "198.51.100.1",
+			wantIP:     "203.0.113.7",
+		},
+		{
+			remoteAddr: "10.0.0.2:5000",
+			header:     "X-Forwarded-For",
+			value:      "198.51.100.1, 10.0.0.3",
+			wantIP:     "198.51.100.1",
+		},
+		{
+			remoteAddr: "10.0.0.2:5000",
+			header:     "X-Forwarded-For",
+			value:      "192.0.2.66, 198.51.100.1",
+			wantIP:     "198.51.100.1",
+		},
+		{
+			remoteAddr: "10.0.0.2:5000",
+			header:     "Forwarded",
+			value:      `for="[2001:db8:cafe::17]:4711";proto=https, for=10.0.0.3`,
+			wantIP:     "2001:db8:cafe::17",
+		},
+		{
+			remoteAddr: "10.0.0.2:5000",
+			header:     "Forwarded",
+			value:      "for=_hidden, for=10.0.0.3",
+			wantIP:     "10.0.0.3",
+		},
+		{
+			remoteAddr: "10.0.0.2:5000",
+			wantIP:     "10.0.0.2",
+		},
+	}
+	nets, err := ParseTrustedProxies("10.0.0.0/8, ::1")
+	require.NoError(t, err)
+	SetTrustedProxies(nets)
+	defer SetTrustedProxies(nil)
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/auth", nil)
+		req.RemoteAddr = test.remoteAddr
+		if test.header != "" {
+			req.Header.Set(test.header, test.value)
+		}
+		assert.Equal(t, test.wantIP, ClientIP(req), "неверный адрес клиента")
+	}
+}
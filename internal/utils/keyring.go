@@ -0,0 +1,290 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sater-151/tt-auth/internal/models"
+)
+
+var ErrSigningEncryptionKey = errors.New("SIGNING_KEY_ENCRYPTION_KEY must be 32 bytes encoded in base64")
+
+type RingKey struct {
+	Signer
+	ActivatesAt time.Time
+	// zero while the key signs new tokens
+	RetiresAt time.Time
+	// zero while the key verifies tokens
+	ExpiresAt time.Time
+}
+
+// KeyRing holds the active signing key, keys published ahead of their activation
+// and retired keys which only verify tokens they have signed.
+type KeyRing struct {
+	mu   sync.RWMutex
+	keys []RingKey
+}
+
+var (
+	keyRingMu sync.RWMutex
+	keyRing   *KeyRing
+)
+
+func SetKeyRing(ring *KeyRing) {
+	keyRingMu.Lock()
+	defer keyRingMu.Unlock()
+	keyRing = ring
+}
+
+// currentKeyRing falls back to the signer from the environment when no key ring has been set
+func currentKeyRing() (*KeyRing, error) {
+	keyRingMu.RLock()
+	ring := keyRing
+	keyRingMu.RUnlock()
+	if ring != nil {
+		return ring, nil
+	}
+	s, err := LoadSigner()
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyRing(RingKey{Signer: s}), nil
+}
+
+func NewKeyRing(keys ...RingKey) *KeyRing {
+	return &KeyRing{keys: keys}
+}
+
+func (r *KeyRing) Replace(keys []RingKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = keys
+}
+
+// Active returns the most recently activated key which is not retired
+func (r *KeyRing) Active() (Signer, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	now := time.Now()
+	var active *RingKey
+	for i := range r.keys {
+		key := &r.keys[i]
+		if key.ActivatesAt.After(now) || (!key.RetiresAt.IsZero() && !now.Before(key.RetiresAt)) {
+			continue
+		}
+		if active == nil || key.ActivatesAt.After(active.ActivatesAt) {
+			active = key
+		}
+	}
+	if active == nil {
+		return nil, ErrNoActiveKey
+	}
+	return active.Signer, nil
+}
+
+func (r *KeyRing) Verifier(kid string) (Signer, error) {
+	if kid == "" {
+		return r.Active()
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	now := time.Now()
+	for _, key := range r.keys {
+		if key.KeyID() == kid && (key.ExpiresAt.IsZero() || now.Before(key.ExpiresAt)) {
+			return key.Signer, nil
+		}
+	}
+	return nil, ErrUnknownKID
+}
+
+func (r *KeyRing) Algs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	algs := make([]string, 0, len(r.keys))
+	for _, key := range r.keys {
+		algs = append(algs, key.Method().Alg())
+	}
+	return algs
+}
+
+// JWKS returns the public keys of all keys which are not expired,
+// including the ones which do not sign yet
+func (r *KeyRing) JWKS() models.JWKSet {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	set := models.JWKSet{Keys: []models.JWK{}}
+	now := time.Now()
+	for _, key := range r.keys {
+		if !key.ExpiresAt.IsZero() && !now.Before(key.ExpiresAt) {
+			continue
+		}
+		if jwk, ok := key.PublicJWK(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
+// keyFunc selects the verification key by the kid header of the token
+func (r *KeyRing) keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	s, err := r.Verifier(kid)
+	if err != nil {
+		return nil, err
+	}
+	if s.Method().Alg() != t.Method.Alg() {
+		return nil, ErrKeyMismatch
+	}
+	return s.VerificationKey(), nil
+}
+
+func JWKS() (models.JWKSet, error) {
+	ring, err := currentKeyRing()
+	if err != nil {
+		return models.JWKSet{}, err
+	}
+	return ring.JWKS(), nil
+}
+
+func RingKeys(keys []models.SigningKey) ([]RingKey, error) {
+	ringKeys := make([]RingKey, 0, len(keys))
+	for _, key := range keys {
+		s, err := storedSigner(key)
+		if err != nil {
+			return nil, err
+		}
+		ringKeys = append(ringKeys, RingKey{
+			Signer:      s,
+			ActivatesAt: key.ActivatesAt,
+			RetiresAt:   key.RetiresAt,
+			ExpiresAt:   key.ExpiresAt,
+		})
+	}
+	return ringKeys, nil
+}
+
+// storedSigner makes the signer of a stored key, HMAC secrets are stored in base64
+func storedSigner(key models.SigningKey) (Signer, error) {
+	method := jwt.GetSigningMethod(key.Alg)
+	if method == nil {
+		return nil, ErrUnsupportedAlg
+	}
+	if hmacMethod, ok := method.(*jwt.SigningMethodHMAC); ok {
+		secret, err := base64.StdEncoding.DecodeString(key.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		return &hmacSigner{method: hmacMethod, kid: key.KID, secret: secret}, nil
+	}
+	privateKey, err := ParsePrivateKeyPEM([]byte(key.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+	return NewKeySigner(method, privateKey)
+}
+
+// NewHMACSigningKey wraps a shared secret into a signing key, a random kid is taken when kid is empty
+func NewHMACSigningKey(alg string, secret []byte, kid string) (models.SigningKey, error) {
+	if _, ok := jwt.GetSigningMethod(alg).(*jwt.SigningMethodHMAC); !ok {
+		return models.SigningKey{}, ErrUnsupportedAlg
+	}
+	if kid == "" {
+		id := make([]byte, 16)
+		_, err := rand.Read(id)
+		if err != nil {
+			return models.SigningKey{}, err
+		}
+		kid = b64(id)
+	}
+	return models.SigningKey{
+		KID:        kid,
+		Alg:        alg,
+		PrivateKey: base64.StdEncoding.EncodeToString(secret),
+	}, nil
+}
+
+// NewSigningKey wraps a private key into a PEM encoded signing key
+func NewSigningKey(alg string, key crypto.Signer) (models.SigningKey, error) {
+	method := jwt.GetSigningMethod(alg)
+	if method == nil {
+		return models.SigningKey{}, ErrUnsupportedAlg
+	}
+	s, err := NewKeySigner(method, key)
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+	return models.SigningKey{
+		KID:        s.KeyID(),
+		Alg:        alg,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	}, nil
+}
+
+func GenerateSigningKey(alg string) (models.SigningKey, error) {
+	var key crypto.Signer
+	var err error
+	switch alg {
+	case "HS256", "HS384", "HS512":
+		// the secret is as long as the hash output
+		secret := make([]byte, jwt.GetSigningMethod(alg).(*jwt.SigningMethodHMAC).Hash.Size())
+		_, err = rand.Read(secret)
+		if err != nil {
+			return models.SigningKey{}, err
+		}
+		return NewHMACSigningKey(alg, secret, "")
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+		key, err = rsa.GenerateKey(rand.Reader, 3072)
+	case "ES256":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ES384":
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ES512":
+		key, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case "EdDSA":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return models.SigningKey{}, ErrUnsupportedAlg
+	}
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+	return NewSigningKey(alg, key)
+}
+
+func signingKeyCipher() (cipher.AEAD, error) {
+	return newCipher(os.Getenv("SIGNING_KEY_ENCRYPTION_KEY"), ErrSigningEncryptionKey)
+}
+
+// EncryptSigningKey seals a private key with AES-256-GCM under SIGNING_KEY_ENCRYPTION_KEY
+func EncryptSigningKey(privateKey string) (string, error) {
+	aead, err := signingKeyCipher()
+	if err != nil {
+		return "", err
+	}
+	return seal(aead, privateKey)
+}
+
+func DecryptSigningKey(ciphertext string) (string, error) {
+	aead, err := signingKeyCipher()
+	if err != nil {
+		return "", err
+	}
+	return open(aead, ciphertext)
+}
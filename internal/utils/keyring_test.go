@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyRing(t *testing.T) {
+	now := time.Now()
+	newRingKey := func(activatesAt, retiresAt, expiresAt time.Time) RingKey {
+		key, err := GenerateSigningKey("ES256")
+		require.NoError(t, err)
+		key.ActivatesAt, key.RetiresAt, key.ExpiresAt = activatesAt, retiresAt, expiresAt
+		ringKeys, err := RingKeys([]models.SigningKey{key})
+		require.NoError(t, err)
+		return ringKeys[0]
+	}
+	expired := newRingKey(now.Add(-3*time.Hour), now.Add(-2*time.Hour), now.Add(-time.Hour))
+	retired := newRingKey(now.Add(-2*time.Hour), now.Add(-time.Minute), now.Add(time.Hour))
+	active := newRingKey(now.Add(-time.Minute), time.Time{}, time.Time{})
+	pending := newRingKey(now.Add(time.Hour), time.Time{}, time.Time{})
+
+	ring := NewKeyRing(expired, retired, active, pending)
+	SetKeyRing(ring)
+	defer SetKeyRing(nil)
+
+	s, err := ring.Active()
+	require.NoError(t, err)
+	assert.Equal(t, active.KeyID(), s.KeyID(), "подписывает не активный ключ")
+
+	jwks := ring.JWKS()
+	kids := []string{}
+	for _, jwk := range jwks.Keys {
+		kids = append(kids, jwk.Kid)
+	}
+	assert.ElementsMatch(t, []string{retired.KeyID(), active.KeyID(), pending.KeyID()}, kids, "неверный набор опубликованных ключей")
+
+	sign := func(key RingKey) string {
+		token := jwt.NewWithClaims(key.Method(), &models.Claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject:   "guid",
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+			},
+		})
+		token.Header["kid"] = key.KeyID()
+		aToken, err := token.SignedString(key.SigningKey())
+		require.NoError(t, err)
+		return aToken
+	}
+	_, err = ValidateAccessToken(sign(retired))
+	assert.NoError(t, err, "токен выведенного ключа не проверился")
+	_, err = ValidateAccessToken(sign(active))
+	assert.NoError(t, err)
+	_, err = ValidateAccessToken(sign(expired))
+	assert.ErrorIs(t, err, ErrUnknownKID)
+
+	os.Setenv("ATEXPIRES", "60")
+	aToken, _, err := GenerateTokens("guid", "session", "192.0.2.1")
+	require.NoError(t, err)
+	token, _, err := jwt.NewParser().ParseUnverified(aToken, jwt.MapClaims{})
+	require.NoError(t, err)
+	assert.Equal(t, active.KeyID(), token.Header["kid"])
+}
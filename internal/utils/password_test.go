@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestComparePassword(t *testing.T) {
+	os.Setenv("ARGON2_MEMORY", "1024")
+	os.Setenv("ARGON2_TIME", "1")
+	defer os.Unsetenv("ARGON2_MEMORY")
+	defer os.Unsetenv("ARGON2_TIME")
+
+	argonHash, err := HashPassword("secret")
+	require.NoError(t, err)
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	tests := []struct {
+		id         int
+		password   string
+		hash       string
+		wantOK     bool
+		wantRehash bool
+		wantErr    bool
+	}{
+		{id: 1, password: "secret", hash: argonHash, wantOK: true},
+		{id: 2, password: "wrong", hash: argonHash},
+		{id: 3, password: "secret", hash: string(bcryptHash), wantOK: true, wantRehash: true},
+		{id: 4, password: "wrong", hash: string(bcryptHash)},
+		{id: 5, password: "secret", hash: "plain", wantErr: true},
+	}
+	for _, test := range tests {
+		fmt.Printf("Тест id: %v\n", test.id)
+		ok, rehash, err := ComparePassword(test.password, test.hash)
+		if test.wantErr {
+			assert.Error(t, err, "ожидалась ошибка")
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, test.wantOK, ok, "результат проверки пароля не соответствует ожидаемому")
+		assert.Equal(t, test.wantRehash, rehash, "необходимость перехеширования не соответствует ожидаемой")
+	}
+
+	// hash made with other cost has to be upgraded
+	os.Setenv("ARGON2_TIME", "2")
+	ok, rehash, err := ComparePassword("secret", argonHash)
+	require.NoError(t, err)
+	assert.True(t, ok, "пароль не прошел проверку")
+	assert.True(t, rehash, "хеш со старыми параметрами не помечен для перехеширования")
+}
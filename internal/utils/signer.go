@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sater-151/tt-auth/internal/models"
+)
+
+var ErrInvalidPEM = errors.New("failed to decode pem block")
+var ErrKeyMismatch = errors.New("key type does not match signing algorithm")
+var ErrUnknownKID = errors.New("unknown key id")
+var ErrNoActiveKey = errors.New("no active signing key")
+
+// Signer signs access tokens. Asymmetric signers publish their public key as a JWK.
+type Signer interface {
+	Method() jwt.SigningMethod
+	KeyID() string
+	SigningKey() interface{}
+	VerificationKey() interface{}
+	PublicJWK() (models.JWK, bool)
+}
+
+// LoadSigner builds a signer from JWT_ALG and either JWT_SECRET for HMAC
+// or the PEM encoded private key from JWT_PRIVATE_KEY_FILE.
+func LoadSigner() (Signer, error) {
+	alg := os.Getenv("JWT_ALG")
+	if alg == "" {
+		alg = jwt.SigningMethodHS512.Alg()
+	}
+	method := jwt.GetSigningMethod(alg)
+	if method == nil || method == jwt.SigningMethodNone {
+		return nil, ErrUnsupportedAlg
+	}
+	if hmacMethod, ok := method.(*jwt.SigningMethodHMAC); ok {
+		return &hmacSigner{method: hmacMethod, kid: os.Getenv("JWT_KID"), secret: []byte(os.Getenv("JWT_SECRET"))}, nil
+	}
+
+	keyPEM, err := os.ReadFile(os.Getenv("JWT_PRIVATE_KEY_FILE"))
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeySigner(method, key)
+}
+
+func ParsePrivateKeyPEM(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, ErrInvalidPEM
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	keySigner, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, ErrKeyMismatch
+	}
+	return keySigner, nil
+}
+
+func NewKeySigner(method jwt.SigningMethod, key crypto.Signer) (Signer, error) {
+	switch method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		if _, ok := key.(*rsa.PrivateKey); !ok {
+			return nil, ErrKeyMismatch
+		}
+	case *jwt.SigningMethodECDSA:
+		ecKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok || ecKey.Curve.Params().BitSize != method.(*jwt.SigningMethodECDSA).CurveBits {
+			return nil, ErrKeyMismatch
+		}
+	case *jwt.SigningMethodEd25519:
+		if _, ok := key.(ed25519.PrivateKey); !ok {
+			return nil, ErrKeyMismatch
+		}
+	default:
+		return nil, ErrUnsupportedAlg
+	}
+	s := &keySigner{method: method, key: key}
+	jwk, _ := s.PublicJWK()
+	kid, err := thumbprint(jwk)
+	if err != nil {
+		return nil, err
+	}
+	s.kid = kid
+	return s, nil
+}
+
+type hmacSigner struct {
+	method *jwt.SigningMethodHMAC
+	kid    string
+	secret []byte
+}
+
+func (s *hmacSigner) Method() jwt.SigningMethod     { return s.method }
+func (s *hmacSigner) KeyID() string                 { return s.kid }
+func (s *hmacSigner) SigningKey() interface{}       { return s.secret }
+func (s *hmacSigner) VerificationKey() interface{}  { return s.secret }
+func (s *hmacSigner) PublicJWK() (models.JWK, bool) { return models.JWK{}, false }
+
+type keySigner struct {
+	method jwt.SigningMethod
+	kid    string
+	key    crypto.Signer
+}
+
+func (s *keySigner) Method() jwt.SigningMethod    { return s.method }
+func (s *keySigner) KeyID() string                { return s.kid }
+func (s *keySigner) SigningKey() interface{}      { return s.key }
+func (s *keySigner) VerificationKey() interface{} { return s.key.Public() }
+
+func (s *keySigner) PublicJWK() (models.JWK, bool) {
+	jwk := models.JWK{Use: "sig", Kid: s.kid, Alg: s.method.Alg()}
+	switch pub := s.key.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64(pub.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = b64(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = b64(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = b64(pub)
+	}
+	return jwk, true
+}
+
+// thumbprint computes RFC 7638 JWK thumbprint
+func thumbprint(jwk models.JWK) (string, error) {
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return b64(sum[:]), nil
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
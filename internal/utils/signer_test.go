@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadSigner(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		alg     string
+		key     crypto.Signer
+		wantKty string
+		wantErr error
+	}{
+		{
+			alg:     "RS256",
+			key:     rsaKey,
+			wantKty: "RSA",
+		},
+		{
+			alg:     "ES256",
+			key:     ecKey,
+			wantKty: "EC",
+		},
+		{
+			alg:     "EdDSA",
+			key:     edKey,
+			wantKty: "OKP",
+		},
+		{
+			alg:     "ES256",
+			key:     rsaKey,
+			wantErr: ErrKeyMismatch,
+		},
+	}
+	os.Setenv("ATEXPIRES", "60")
+	defer os.Unsetenv("JWT_ALG")
+	defer os.Unsetenv("JWT_PRIVATE_KEY_FILE")
+
+	for _, test := range tests {
+		der, err := x509.MarshalPKCS8PrivateKey(test.key)
+		require.NoError(t, err)
+		keyFile := filepath.Join(t.TempDir(), "key.pem")
+		err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+		require.NoError(t, err)
+		os.Setenv("JWT_ALG", test.alg)
+		os.Setenv("JWT_PRIVATE_KEY_FILE", keyFile)
+
+		s, err := LoadSigner()
+		if test.wantErr != nil {
+			assert.ErrorIs(t, err, test.wantErr)
+			continue
+		}
+		require.NoError(t, err)
+
+		aToken, _, err := GenerateTokens("guid", "session", "192.0.2.1")
+		require.NoError(t, err)
+		token, _, err := jwt.NewParser().ParseUnverified(aToken, jwt.MapClaims{})
+		require.NoError(t, err)
+		assert.Equal(t, test.alg, token.Method.Alg(), "неверный алгоритм подписи")
+		assert.Equal(t, s.KeyID(), token.Header["kid"], "неверный kid")
+
+		claims, err := ValidateAccessToken(aToken)
+		require.NoError(t, err)
+		assert.Equal(t, "guid", claims.Subject)
+
+		set, err := JWKS()
+		require.NoError(t, err)
+		require.Equal(t, 1, len(set.Keys))
+		assert.Equal(t, test.wantKty, set.Keys[0].Kty)
+		assert.Equal(t, s.KeyID(), set.Keys[0].Kid)
+	}
+
+	os.Setenv("JWT_ALG", "HS512")
+	set, err := JWKS()
+	require.NoError(t, err)
+	assert.Empty(t, set.Keys, "симметричный ключ опубликован")
+}
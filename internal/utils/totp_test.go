@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"encoding/base32"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, SHA1 secret, last 6 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	tests := []struct {
+		id   int
+		time int64
+		want string
+	}{
+		{id: 1, time: 59, want: "287082"},
+		{id: 2, time: 1111111109, want: "081804"},
+		{id: 3, time: 1234567890, want: "005924"},
+		{id: 4, time: 20000000000, want: "353130"},
+	}
+	for _, test := range tests {
+		fmt.Printf("Тест id: %v\n", test.id)
+		code, err := TOTPCode(secret, TOTPStep(time.Unix(test.time, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, test.want, code, "код не соответствует ожидаемому")
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	require.NoError(t, err)
+	now := time.Now()
+	step := TOTPStep(now)
+	code, err := TOTPCode(secret, step)
+	require.NoError(t, err)
+	previous, err := TOTPCode(secret, step-1)
+	require.NoError(t, err)
+	old, err := TOTPCode(secret, step-5)
+	require.NoError(t, err)
+
+	got, ok := ValidateTOTP(secret, code, now, 0)
+	assert.True(t, ok, "верный код не принят")
+	assert.Equal(t, step, got, "шаг не соответствует ожидаемому")
+	_, ok = ValidateTOTP(secret, previous, now, 0)
+	assert.True(t, ok, "код предыдущего периода не принят")
+	_, ok = ValidateTOTP(secret, code, now, step)
+	assert.False(t, ok, "повторно использованный код принят")
+	_, ok = ValidateTOTP(secret, old, now, 0)
+	assert.False(t, ok, "устаревший код принят")
+}
+
+func TestEncryptSecret(t *testing.T) {
+	os.Setenv("MFA_ENCRYPTION_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	defer os.Unsetenv("MFA_ENCRYPTION_KEY")
+
+	ciphertext, err := EncryptSecret("JBSWY3DPEHPK3PXP")
+	require.NoError(t, err)
+	assert.NotContains(t, ciphertext, "JBSWY3DPEHPK3PXP", "секрет хранится в открытом виде")
+	plain, err := DecryptSecret(ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", plain, "расшифрованный секрет не соответствует")
+
+	os.Setenv("MFA_ENCRYPTION_KEY", "c2hvcnQ=")
+	_, err = EncryptSecret("JBSWY3DPEHPK3PXP")
+	assert.Equal(t, ErrEncryptionKey, err)
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	require.NoError(t, err)
+	require.Len(t, codes, 10)
+	for _, code := range codes {
+		assert.Equal(t, code, NormalizeRecoveryCode(code), "код не в каноническом виде")
+		assert.Len(t, code, 11)
+	}
+	assert.Equal(t, "abcde-fghjk", NormalizeRecoveryCode(" ABCDEFGHJK "))
+}
@@ -1,81 +1,263 @@
-package utils
-
-import (
-	"crypto/rand"
-	"crypto/sha256"
-	"errors"
-	"fmt"
-	"os"
-	"strconv"
-	"time"
-
-	"github.com/golang-jwt/jwt/v5"
-)
-
-var ErrTypecastJWT = errors.New("failed to typecast jwt claims")
-
-const str = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz-"
-
-func CreateLink() (string, error) {
-	tokenLink := make([]byte, 32)
-	_, err := rand.Read(tokenLink)
-	if err != nil {
-		return "", err
-	}
-	for i, j := range tokenLink {
-		tokenLink[i] = str[j%byte(len(str))]
-	}
-	return string(tokenLink), nil
-}
-
-func GenerateTokens(host string) (string, string, error) {
-	var aToken, rToken string
-
-	tokenLink, err := CreateLink()
-	if err != nil {
-		return aToken, rToken, err
-	}
-
-	// access token generation
-	atTimeExp, err := strconv.Atoi(os.Getenv("ATEXPIRES"))
-	if err != nil {
-		return aToken, rToken, err
-	}
-	atExp := time.Now().Add(time.Duration(atTimeExp) * time.Second)
-	claims := &jwt.MapClaims{
-		"ExpiresAt":  atExp.Unix(),
-		"Host":       host,
-		"LinkString": tokenLink,
-	}
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
-	aToken, err = accessToken.SignedString([]byte(os.Getenv("JWT_SECRET")))
-	if err != nil {
-		return aToken, rToken, err
-	}
-
-	// refresh token generation
-	rtSH := sha256.Sum256([]byte(tokenLink))
-	rToken = fmt.Sprintf("%x%v", rtSH, aToken[len(aToken)-6:])
-	return aToken, rToken, nil
-}
-
-func CheckHost(aToken, host string) (bool, error) {
-	jwtToken, err := jwt.Parse(aToken, func(t *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
-	if err != nil {
-		return false, err
-	}
-	claims, ok := jwtToken.Claims.(jwt.MapClaims)
-	if !ok {
-		return false, ErrTypecastJWT
-	}
-	if claims["Host"].(string) == host {
-		return true, nil
-	}
-	return false, nil
-}
-
-func SendMasseg(mail string) error {
-	return nil
-}
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sater-151/tt-auth/internal/models"
+)
+
+var ErrTypecastJWT = errors.New("failed to typecast jwt claims")
+var ErrMalformedRT = errors.New("malformed refresh token")
+var ErrUnsupportedAlg = errors.New("unsupported signing algorithm")
+var ErrSubjectRequired = errors.New("token subject required")
+
+const str = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz-"
+
+func CreateLink() (string, error) {
+	tokenLink := make([]byte, 32)
+	_, err := rand.Read(tokenLink)
+	if err != nil {
+		return "", err
+	}
+	for i, j := range tokenLink {
+		tokenLink[i] = str[j%byte(len(str))]
+	}
+	return string(tokenLink), nil
+}
+
+func NewUUID() (string, error) {
+	uuid := make([]byte, 16)
+	_, err := rand.Read(uuid)
+	if err != nil {
+		return "", err
+	}
+	// version 4, variant RFC 4122
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:]), nil
+}
+
+// GenerateTokens issues a token pair, amr names the methods the user has just authenticated with.
+// Tokens of a refreshed session are issued without amr.
+func GenerateTokens(guid, sessionID, clientIP string, amr ...string) (string, string, error) {
+	var aToken, rToken string
+
+	// access token generation
+	claims := &models.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: guid},
+		ClientIP:         clientIP,
+		SessionID:        sessionID,
+	}
+	if len(amr) > 0 {
+		claims.AuthTime = jwt.NewNumericDate(time.Now())
+		claims.AMR = amr
+	}
+	aToken, err := NewAccessToken(claims, 0)
+	if err != nil {
+		return aToken, rToken, err
+	}
+
+	// refresh token generation
+	rToken, err = NewRefreshToken()
+	if err != nil {
+		return aToken, rToken, err
+	}
+	return aToken, rToken, nil
+}
+
+// NewAccessToken fills the registered claims except the subject and signs the token with the active key.
+// A zero ttl means ATEXPIRES, a longer one is cut to it.
+func NewAccessToken(claims *models.Claims, ttl time.Duration) (string, error) {
+	jti, err := CreateLink()
+	if err != nil {
+		return "", err
+	}
+	maxTTL, err := AccessTokenTTL()
+	if err != nil {
+		return "", err
+	}
+	if ttl <= 0 || ttl > maxTTL {
+		ttl = maxTTL
+	}
+	ring, err := currentKeyRing()
+	if err != nil {
+		return "", err
+	}
+	s, err := ring.Active()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims.Issuer = os.Getenv("JWT_ISSUER")
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+	claims.NotBefore = jwt.NewNumericDate(now)
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ID = jti
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		claims.Audience = jwt.ClaimStrings{audience}
+	}
+	accessToken := jwt.NewWithClaims(s.Method(), claims)
+	if s.KeyID() != "" {
+		accessToken.Header["kid"] = s.KeyID()
+	}
+	return accessToken.SignedString(s.SigningKey())
+}
+
+// AccessTokenTTL is the lifetime ATEXPIRES, also the longest one. A retired signing key
+// verifies tokens only that long.
+func AccessTokenTTL() (time.Duration, error) {
+	atTimeExp, err := strconv.Atoi(os.Getenv("ATEXPIRES"))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(atTimeExp) * time.Second, nil
+}
+
+func TokensExpiration() (time.Time, time.Time, error) {
+	atTimeExp, err := strconv.Atoi(os.Getenv("ATEXPIRES"))
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	rtTimeExp, err := strconv.Atoi(os.Getenv("RTEXPIRES"))
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	atExp := time.Now().Add(time.Duration(atTimeExp) * time.Second)
+	rtExp := time.Now().Add(time.Duration(rtTimeExp) * time.Second)
+	return atExp, rtExp, nil
+}
+
+// ReauthMaxAge is how long after the login REAUTH_MAX_AGE lets the user change
+// the ways to sign in, five minutes when not set
+func ReauthMaxAge() (time.Duration, error) {
+	maxAge := os.Getenv("REAUTH_MAX_AGE")
+	if maxAge == "" {
+		return 5 * time.Minute, nil
+	}
+	seconds, err := strconv.Atoi(maxAge)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// IdleExpiration returns zero time when RTIDLETIMEOUT is not set
+func IdleExpiration() (time.Time, error) {
+	idle := os.Getenv("RTIDLETIMEOUT")
+	if idle == "" {
+		return time.Time{}, nil
+	}
+	idleTimeout, err := strconv.Atoi(idle)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if idleTimeout <= 0 {
+		return time.Time{}, nil
+	}
+	return time.Now().Add(time.Duration(idleTimeout) * time.Second), nil
+}
+
+func ValidateAccessToken(aToken string) (*models.Claims, error) {
+	ring, err := currentKeyRing()
+	if err != nil {
+		return nil, err
+	}
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(ring.Algs()),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+	if leeway := os.Getenv("JWT_LEEWAY"); leeway != "" {
+		seconds, err := strconv.Atoi(leeway)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, jwt.WithLeeway(time.Duration(seconds)*time.Second))
+	}
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		options = append(options, jwt.WithIssuer(issuer))
+	}
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		options = append(options, jwt.WithAudience(audience))
+	}
+
+	claims := &models.Claims{}
+	_, err = jwt.ParseWithClaims(aToken, claims, ring.keyFunc, options...)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, ErrSubjectRequired
+	}
+	return claims, nil
+}
+
+// NewRefreshToken returns a token of the form "selector.verifier".
+// The selector is stored in plain text to find the token, the verifier only as a hash.
+func NewRefreshToken() (string, error) {
+	selector, err := CreateLink()
+	if err != nil {
+		return "", err
+	}
+	verifier := make([]byte, 32)
+	_, err = rand.Read(verifier)
+	if err != nil {
+		return "", err
+	}
+	return selector + "." + hex.EncodeToString(verifier), nil
+}
+
+func SplitRefreshToken(rToken string) (string, string, error) {
+	selector, verifier, ok := strings.Cut(rToken, ".")
+	if !ok || selector == "" || verifier == "" {
+		return "", "", ErrMalformedRT
+	}
+	return selector, verifier, nil
+}
+
+func HashToken(verifier string) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("RT_PEPPER")))
+	mac.Write([]byte(verifier))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func CompareTokenHash(verifier, hash string) bool {
+	want, err := hex.DecodeString(hash)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(os.Getenv("RT_PEPPER")))
+	mac.Write([]byte(verifier))
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+// CheckHost is called on refresh, when the access token has usually expired already,
+// so only the signature of the token is verified. The token tells the host only if it was
+// issued to the refreshed session. A token signed by a key that has been pruned since
+// fails with ErrUnknownKID.
+func CheckHost(aToken, sessionID, clientIP string) (bool, error) {
+	ring, err := currentKeyRing()
+	if err != nil {
+		return false, err
+	}
+	claims := &models.Claims{}
+	_, err = jwt.ParseWithClaims(aToken, claims, ring.keyFunc,
+		jwt.WithValidMethods(ring.Algs()), jwt.WithoutClaimsValidation())
+	if err != nil {
+		return false, err
+	}
+	if claims.SessionID == sessionID && claims.ClientIP == clientIP {
+		return true, nil
+	}
+	return false, nil
+}
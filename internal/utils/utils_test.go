@@ -1,47 +1,160 @@
-package utils
-
-import (
-	"os"
-	"testing"
-
-	"github.com/golang-jwt/jwt/v5"
-	"github.com/joho/godotenv"
-	"github.com/stretchr/testify/assert"
-)
-
-func TestCheckHost(t *testing.T) {
-	tests := []struct {
-		giveHost   string
-		wantResult bool
-		wantErr    error
-	}{
-		{
-			giveHost:   "localhost:8080",
-			wantResult: true,
-			wantErr:    nil,
-		},
-		{
-			giveHost:   "0.0.0.0:8080",
-			wantResult: false,
-			wantErr:    nil,
-		},
-	}
-	err := godotenv.Load()
-	assert.NotEqual(t, err, nil, err)
-
-	for _, testTask := range tests {
-		claims := &jwt.MapClaims{
-			"Host": "localhost:8080",
-		}
-		accessToken := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
-		aToken, err := accessToken.SignedString([]byte(os.Getenv("JWT_SECRET")))
-		if err != nil {
-			assert.NotEqual(t, err, nil, err)
-		}
-
-		check, err := CheckHost(aToken, testTask.giveHost)
-
-		assert.Equal(t, testTask.wantResult, check, "хост проверился неверно")
-		assert.Equal(t, testTask.wantErr, err, "вернулась ошибка, которой не должно быть")
-	}
-}
+package utils
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/joho/godotenv"
+	"github.com/sater-151/tt-auth/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckHost(t *testing.T) {
+	tests := []struct {
+		giveSessionID string
+		giveIP        string
+		tamper        bool
+		wantResult    bool
+		wantErr       bool
+	}{
+		{
+			giveSessionID: "session",
+			giveIP:        "192.0.2.1",
+			wantResult:    true,
+		},
+		{
+			giveSessionID: "session",
+			giveIP:        "198.51.100.1",
+			wantResult:    false,
+		},
+		{
+			giveSessionID: "other session",
+			giveIP:        "192.0.2.1",
+			wantResult:    false,
+		},
+		{
+			giveSessionID: "session",
+			giveIP:        "192.0.2.1",
+			tamper:        true,
+			wantResult:    false,
+			wantErr:       true,
+		},
+	}
+	err := godotenv.Load()
+	assert.NotEqual(t, err, nil, err)
+
+	for _, testTask := range tests {
+		claims := &jwt.MapClaims{
+			"ip":  "192.0.2.1",
+			"sid": "session",
+		}
+		accessToken := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
+		aToken, err := accessToken.SignedString([]byte(os.Getenv("JWT_SECRET")))
+		if err != nil {
+			assert.NotEqual(t, err, nil, err)
+		}
+		if testTask.tamper {
+			// the signature of another payload
+			other, err := jwt.NewWithClaims(jwt.SigningMethodHS512, &jwt.MapClaims{"ip": "198.51.100.1"}).
+				SignedString([]byte("another secret"))
+			assert.NoError(t, err)
+			aToken = aToken[:strings.LastIndex(aToken, ".")] + other[strings.LastIndex(other, "."):]
+		}
+
+		check, err := CheckHost(aToken, testTask.giveSessionID, testTask.giveIP)
+
+		assert.Equal(t, testTask.wantResult, check, "хост проверился неверно")
+		assert.Equal(t, testTask.wantErr, err != nil, "ошибка не соответствует ожидаемой")
+	}
+}
+
+func TestRefreshTokenHash(t *testing.T) {
+	os.Setenv("RT_PEPPER", "pepper")
+	rToken, err := NewRefreshToken()
+	assert.NoError(t, err)
+
+	selector, verifier, err := SplitRefreshToken(rToken)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, selector, "пустой селектор")
+
+	hash := HashToken(verifier)
+	assert.True(t, CompareTokenHash(verifier, hash), "хеш не совпал с токеном")
+	assert.False(t, CompareTokenHash(verifier+"0", hash), "хеш совпал с другим токеном")
+
+	os.Setenv("RT_PEPPER", "another pepper")
+	assert.False(t, CompareTokenHash(verifier, hash), "хеш совпал при другом перце")
+
+	_, _, err = SplitRefreshToken("8e4113a6af13308c5a228f994a21aa0ed0e4e7265efcd0adc9a5434c5cf8033fiYzgkg")
+	assert.Equal(t, ErrMalformedRT, err)
+}
+
+func TestValidateAccessToken(t *testing.T) {
+	os.Setenv("ATEXPIRES", "60")
+	os.Setenv("JWT_SECRET", "jwt_secret")
+	os.Setenv("JWT_ISSUER", "tt-auth")
+	os.Setenv("JWT_AUDIENCE", "api")
+	os.Setenv("JWT_LEEWAY", "30")
+	defer os.Unsetenv("JWT_ISSUER")
+	defer os.Unsetenv("JWT_AUDIENCE")
+	defer os.Unsetenv("JWT_LEEWAY")
+
+	aToken, _, err := GenerateTokens("guid", "session", "192.0.2.1")
+	assert.NoError(t, err)
+	claims, err := ValidateAccessToken(aToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "guid", claims.Subject, "неверный sub")
+	assert.NotEmpty(t, claims.ID, "пустой jti")
+
+	aToken, err = NewAccessToken(&models.Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "guid"}}, time.Hour)
+	assert.NoError(t, err)
+	claims, err = ValidateAccessToken(aToken)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), claims.ExpiresAt.Time, time.Second,
+		"токен живет дольше ATEXPIRES")
+
+	sign := func(alg jwt.SigningMethod, exp time.Time, issuer string) string {
+		token, err := jwt.NewWithClaims(alg, &models.Claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    issuer,
+				Subject:   "guid",
+				Audience:  jwt.ClaimStrings{"api"},
+				ExpiresAt: jwt.NewNumericDate(exp),
+				IssuedAt:  jwt.NewNumericDate(time.Now().Add(-time.Hour)),
+			},
+		}).SignedString([]byte("jwt_secret"))
+		assert.NoError(t, err)
+		return token
+	}
+	tests := []struct {
+		aToken  string
+		wantErr error
+	}{
+		{
+			aToken:  sign(jwt.SigningMethodHS512, time.Now().Add(-10*time.Second), "tt-auth"),
+			wantErr: nil,
+		},
+		{
+			aToken:  sign(jwt.SigningMethodHS512, time.Now().Add(-time.Minute), "tt-auth"),
+			wantErr: jwt.ErrTokenExpired,
+		},
+		{
+			aToken:  sign(jwt.SigningMethodHS256, time.Now().Add(time.Minute), "tt-auth"),
+			wantErr: jwt.ErrTokenSignatureInvalid,
+		},
+		{
+			aToken:  sign(jwt.SigningMethodHS512, time.Now().Add(time.Minute), "another"),
+			wantErr: jwt.ErrTokenInvalidIssuer,
+		},
+	}
+	for _, test := range tests {
+		_, err := ValidateAccessToken(test.aToken)
+		if test.wantErr == nil {
+			assert.NoError(t, err)
+			continue
+		}
+		assert.ErrorIs(t, err, test.wantErr)
+	}
+}
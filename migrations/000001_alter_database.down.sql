@@ -1 +1 @@
-DROP TABLE IF EXISTS users_auth;
+DROP TABLE IF EXISTS users_auth;
@@ -1,8 +1,8 @@
-CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
-CREATE EXTENSION IF NOT EXISTS pgcrypto;
-CREATE TABLE IF NOT EXISTS users_auth(
-    id uuid DEFAULT uuid_generate_v4 (),
-    user_id uuid DEFAULT uuid_generate_v4 (),
-    rt TEXT,
-    PRIMARY KEY (id)
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+CREATE TABLE IF NOT EXISTS users_auth(
+    id uuid DEFAULT uuid_generate_v4 (),
+    user_id uuid DEFAULT uuid_generate_v4 (),
+    rt TEXT,
+    PRIMARY KEY (id)
 );
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE users_auth ADD COLUMN IF NOT EXISTS rt TEXT;
+DROP TABLE IF EXISTS sessions;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS sessions(
+    id uuid DEFAULT uuid_generate_v4 (),
+    user_id uuid NOT NULL,
+    rt TEXT NOT NULL,
+    user_agent TEXT,
+    client_ip TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_used_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+INSERT INTO sessions (user_id, rt, expires_at)
+    SELECT user_id, rt, now() + interval '30 days' FROM users_auth WHERE rt IS NOT NULL;
+ALTER TABLE users_auth DROP COLUMN IF EXISTS rt;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS security_events;
+DROP TABLE IF EXISTS rotated_tokens;
+ALTER TABLE sessions DROP COLUMN IF EXISTS revoked_at;
@@ -0,0 +1,15 @@
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMPTZ;
+CREATE TABLE IF NOT EXISTS rotated_tokens(
+    rt TEXT NOT NULL,
+    session_id uuid NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+    rotated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS rotated_tokens_session_id_idx ON rotated_tokens (session_id);
+CREATE TABLE IF NOT EXISTS security_events(
+    id uuid DEFAULT uuid_generate_v4 (),
+    user_id uuid NOT NULL,
+    session_id uuid,
+    event TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (id)
+);
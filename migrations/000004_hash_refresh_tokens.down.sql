@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS rotated_tokens_rt_selector_idx;
+DELETE FROM rotated_tokens;
+ALTER TABLE rotated_tokens DROP COLUMN IF EXISTS rt_hash;
+ALTER TABLE rotated_tokens DROP COLUMN IF EXISTS rt_selector;
+ALTER TABLE rotated_tokens ADD COLUMN IF NOT EXISTS rt TEXT NOT NULL;
+
+DROP INDEX IF EXISTS sessions_rt_selector_idx;
+UPDATE sessions SET revoked_at=now() WHERE revoked_at IS NULL;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS rt TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions DROP COLUMN IF EXISTS rt_hash;
+ALTER TABLE sessions DROP COLUMN IF EXISTS rt_selector;
@@ -0,0 +1,12 @@
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS rt_selector TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS rt_hash TEXT;
+-- legacy crypt() hashes can not be verified any more, their owners have to get new tokens
+UPDATE sessions SET revoked_at=now() WHERE revoked_at IS NULL;
+ALTER TABLE sessions DROP COLUMN IF EXISTS rt;
+CREATE UNIQUE INDEX IF NOT EXISTS sessions_rt_selector_idx ON sessions (rt_selector);
+
+DELETE FROM rotated_tokens;
+ALTER TABLE rotated_tokens DROP COLUMN IF EXISTS rt;
+ALTER TABLE rotated_tokens ADD COLUMN IF NOT EXISTS rt_selector TEXT NOT NULL;
+ALTER TABLE rotated_tokens ADD COLUMN IF NOT EXISTS rt_hash TEXT NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS rotated_tokens_rt_selector_idx ON rotated_tokens (rt_selector);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS signing_keys(
+    kid TEXT NOT NULL,
+    alg TEXT NOT NULL,
+    private_key TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    activates_at TIMESTAMPTZ NOT NULL,
+    retires_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ,
+    PRIMARY KEY (kid)
+);
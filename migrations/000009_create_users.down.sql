@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS users_auth(
+    id uuid DEFAULT uuid_generate_v4 (),
+    user_id uuid DEFAULT uuid_generate_v4 (),
+    email TEXT,
+    PRIMARY KEY (id)
+);
+INSERT INTO users_auth (user_id, email) SELECT id, email FROM users;
+ALTER TABLE sessions DROP CONSTRAINT IF EXISTS sessions_user_id_fkey;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS users(
+    id uuid DEFAULT uuid_generate_v4 (),
+    email TEXT,
+    email_verified BOOLEAN NOT NULL DEFAULT false,
+    display_name TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'disabled', 'locked')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_idx ON users (lower(email));
+
+-- an email shared by several legacy users is kept only by the first of them
+INSERT INTO users (id, email)
+    SELECT user_id, CASE WHEN row_number() OVER (PARTITION BY lower(email) ORDER BY user_id) = 1 THEN email END
+    FROM (SELECT DISTINCT ON (user_id) user_id, email FROM users_auth ORDER BY user_id, email) AS legacy;
+
+DELETE FROM sessions WHERE user_id NOT IN (SELECT id FROM users);
+ALTER TABLE sessions ADD CONSTRAINT sessions_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+DROP TABLE IF EXISTS users_auth;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS user_tokens_created_at_idx;
+ALTER TABLE user_tokens DROP COLUMN IF EXISTS binding_hash;
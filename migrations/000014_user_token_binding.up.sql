@@ -0,0 +1,2 @@
+ALTER TABLE user_tokens ADD COLUMN IF NOT EXISTS binding_hash TEXT;
+CREATE INDEX IF NOT EXISTS user_tokens_created_at_idx ON user_tokens (user_id, purpose, created_at);
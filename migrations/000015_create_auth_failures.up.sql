@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS auth_failures(
+    key TEXT NOT NULL,
+    failures INT NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    locked_until TIMESTAMPTZ,
+    PRIMARY KEY (key)
+);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS rate_limits(
+    key TEXT NOT NULL,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (key)
+);
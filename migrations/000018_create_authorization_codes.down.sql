@@ -0,0 +1 @@
+DROP TABLE IF EXISTS authorization_codes;
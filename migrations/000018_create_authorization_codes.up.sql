@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS authorization_codes(
+    code_hash TEXT NOT NULL,
+    client_id TEXT NOT NULL,
+    user_id uuid NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scope TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (code_hash)
+);
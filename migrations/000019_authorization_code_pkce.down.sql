@@ -0,0 +1,2 @@
+ALTER TABLE authorization_codes DROP COLUMN IF EXISTS session_id;
+ALTER TABLE authorization_codes DROP COLUMN IF EXISTS code_challenge;
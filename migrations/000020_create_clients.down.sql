@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS client_assertions;
+DROP TABLE IF EXISTS clients;
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS clients(
+    id TEXT NOT NULL,
+    secret_hash TEXT,
+    name TEXT NOT NULL DEFAULT '',
+    client_type TEXT NOT NULL CHECK (client_type IN ('public', 'confidential')),
+    auth_method TEXT NOT NULL CHECK (auth_method IN ('none', 'client_secret_basic', 'client_secret_post', 'private_key_jwt')),
+    redirect_uris JSONB NOT NULL DEFAULT '[]',
+    grant_types JSONB NOT NULL DEFAULT '[]',
+    scope TEXT NOT NULL DEFAULT '',
+    jwks JSONB,
+    access_token_ttl INT NOT NULL DEFAULT 0,
+    refresh_token_ttl INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (id)
+);
+CREATE TABLE IF NOT EXISTS client_assertions(
+    client_id TEXT NOT NULL REFERENCES clients (id) ON DELETE CASCADE,
+    jti TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (client_id, jti)
+);
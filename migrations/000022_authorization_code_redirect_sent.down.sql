@@ -0,0 +1 @@
+ALTER TABLE authorization_codes DROP COLUMN IF EXISTS redirect_uri_sent;
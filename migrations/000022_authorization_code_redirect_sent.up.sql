@@ -0,0 +1 @@
+ALTER TABLE authorization_codes ADD COLUMN IF NOT EXISTS redirect_uri_sent BOOLEAN NOT NULL DEFAULT true;
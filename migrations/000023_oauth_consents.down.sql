@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS oauth_consents;
+ALTER TABLE clients DROP COLUMN IF EXISTS first_party;
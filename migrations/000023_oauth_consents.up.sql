@@ -0,0 +1,9 @@
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS first_party BOOLEAN NOT NULL DEFAULT false;
+CREATE TABLE IF NOT EXISTS oauth_consents(
+    user_id uuid NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    client_id TEXT NOT NULL REFERENCES clients (id) ON DELETE CASCADE,
+    scope TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, client_id)
+);